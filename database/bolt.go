@@ -0,0 +1,740 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/polisgo2020/search-K1ta/revindex"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// buckets of bolt file
+var (
+	// id of text -> title, length in words and source of text
+	titlesBucket = []byte("titles")
+	// id of text -> content of text
+	bodiesBucket = []byte("bodies")
+	// title -> id of text
+	titleIdsBucket = []byte("title_ids")
+	// word -> number of texts with word
+	wordsBucket = []byte("words")
+	// length of word, word and id of text -> positions of word in text
+	postingsBucket = []byte("postings")
+	// id of text and word -> nothing, words of texts to delete their postings
+	textWordsBucket = []byte("text_words")
+	settingsBucket  = []byte("settings")
+	// number of texts and their total length
+	statsBucket = []byte("stats")
+)
+
+var boltBuckets = [][]byte{titlesBucket, bodiesBucket, titleIdsBucket, wordsBucket, postingsBucket, textWordsBucket, settingsBucket, statsBucket}
+
+var (
+	textsKey   = []byte("texts")
+	lengthKey  = []byte("length")
+	versionKey = []byte("version")
+)
+
+// Bolt is a store of index in a single file. It does not need database server, but file can be opened
+// by one process only
+type Bolt struct {
+	db *bolt.DB
+}
+
+var _ revindex.Store = (*Bolt)(nil)
+
+// OpenBolt opens store in file and creates file if it does not exist
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file '%s': %w", path, err)
+	}
+	if err = db.Update(createBuckets); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("cannot init file '%s': %w", path, err)
+	}
+	return &Bolt{db}, nil
+}
+
+func createBuckets(tx *bolt.Tx) error {
+	for _, name := range boltBuckets {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// DropAll removes all texts and settings. Version of store is kept, so it is not reused
+func (b *Bolt) DropAll() error {
+	return b.update(func(tx boltTx) error {
+		return tx.DropAll()
+	})
+}
+
+// Batch applies all changes of function in one transaction
+func (b *Bolt) Batch(fn func(revindex.Store) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b *Bolt) update(fn func(boltTx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b *Bolt) view(fn func(boltTx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b *Bolt) AddTitle(title string, body string, length int) (id int64, err error) {
+	err = b.update(func(tx boltTx) error {
+		id, err = tx.AddTitle(title, body, length)
+		return err
+	})
+	return id, err
+}
+
+func (b *Bolt) AddPostings(word string, postings map[int64][]int) error {
+	return b.update(func(tx boltTx) error {
+		return tx.AddPostings(word, postings)
+	})
+}
+
+func (b *Bolt) DeleteTitle(title string) (ok bool, err error) {
+	err = b.update(func(tx boltTx) error {
+		ok, err = tx.DeleteTitle(title)
+		return err
+	})
+	return ok, err
+}
+
+func (b *Bolt) GetWordsPostings(words []string) (postings map[string]map[int64][]int, err error) {
+	err = b.view(func(tx boltTx) error {
+		postings, err = tx.GetWordsPostings(words)
+		return err
+	})
+	return postings, err
+}
+
+func (b *Bolt) GetWordsLike(pattern string, limit int) (words []string, err error) {
+	err = b.view(func(tx boltTx) error {
+		words, err = tx.GetWordsLike(pattern, limit)
+		return err
+	})
+	return words, err
+}
+
+func (b *Bolt) GetWordsByLength(min int, max int) (words []string, err error) {
+	err = b.view(func(tx boltTx) error {
+		words, err = tx.GetWordsByLength(min, max)
+		return err
+	})
+	return words, err
+}
+
+func (b *Bolt) GetWordFrequencies() (frequencies map[string]int, err error) {
+	err = b.view(func(tx boltTx) error {
+		frequencies, err = tx.GetWordFrequencies()
+		return err
+	})
+	return frequencies, err
+}
+
+func (b *Bolt) GetTitles(ids []int64) (titles map[int64]revindex.TextInfo, err error) {
+	err = b.view(func(tx boltTx) error {
+		titles, err = tx.GetTitles(ids)
+		return err
+	})
+	return titles, err
+}
+
+func (b *Bolt) GetTexts(ids []int64) (texts map[int64]string, err error) {
+	err = b.view(func(tx boltTx) error {
+		texts, err = tx.GetTexts(ids)
+		return err
+	})
+	return texts, err
+}
+
+func (b *Bolt) GetTitleIds() (ids []int64, err error) {
+	err = b.view(func(tx boltTx) error {
+		ids, err = tx.GetTitleIds()
+		return err
+	})
+	return ids, err
+}
+
+func (b *Bolt) GetStats() (count int, avgLength float64, err error) {
+	err = b.view(func(tx boltTx) error {
+		count, avgLength, err = tx.GetStats()
+		return err
+	})
+	return count, avgLength, err
+}
+
+func (b *Bolt) GetVersion() (version int64, err error) {
+	err = b.view(func(tx boltTx) error {
+		version, err = tx.GetVersion()
+		return err
+	})
+	return version, err
+}
+
+func (b *Bolt) GetSetting(key string) (value string, ok bool, err error) {
+	err = b.view(func(tx boltTx) error {
+		value, ok, err = tx.GetSetting(key)
+		return err
+	})
+	return value, ok, err
+}
+
+func (b *Bolt) SetSetting(key string, value string) error {
+	return b.update(func(tx boltTx) error {
+		return tx.SetSetting(key, value)
+	})
+}
+
+func (b *Bolt) GetDocuments() (docs map[string]revindex.Document, err error) {
+	err = b.view(func(tx boltTx) error {
+		docs, err = tx.GetDocuments()
+		return err
+	})
+	return docs, err
+}
+
+func (b *Bolt) SetDocument(title string, doc revindex.Document) error {
+	return b.update(func(tx boltTx) error {
+		return tx.SetDocument(title, doc)
+	})
+}
+
+// boltTx is a store in transaction of bolt file. Keys and values passed to bolt must not be changed
+// until the end of transaction, so they are not reused
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// boltText is a value of text in titles bucket
+type boltText struct {
+	title  string
+	length int
+	doc    revindex.Document
+}
+
+// DropAll removes all texts and settings in transaction, so they are dropped together with changes after it
+func (s boltTx) DropAll() error {
+	version, err := s.GetVersion()
+	if err != nil {
+		return err
+	}
+	for _, name := range boltBuckets {
+		if err := s.tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	if err = createBuckets(s.tx); err != nil {
+		return err
+	}
+	return s.tx.Bucket(statsBucket).Put(versionKey, encodeInt(version+1))
+}
+
+func (s boltTx) AddTitle(title string, body string, length int) (int64, error) {
+	titles := s.tx.Bucket(titlesBucket)
+	var text boltText
+	var id int64
+	if key := s.tx.Bucket(titleIdsBucket).Get([]byte(title)); key != nil {
+		// replaced text keeps its id and source
+		id = decodeInt(key)
+		var err error
+		if text, err = decodeText(titles.Get(key)); err != nil {
+			return -1, err
+		}
+		if err = s.addStats(-1, -text.length); err != nil {
+			return -1, err
+		}
+	} else {
+		seq, err := titles.NextSequence()
+		if err != nil {
+			return -1, err
+		}
+		id = int64(seq)
+		text.title = title
+	}
+	text.length = length
+	if err := titles.Put(encodeInt(id), encodeText(text)); err != nil {
+		return -1, err
+	}
+	if err := s.tx.Bucket(bodiesBucket).Put(encodeInt(id), []byte(body)); err != nil {
+		return -1, err
+	}
+	if err := s.tx.Bucket(titleIdsBucket).Put([]byte(title), encodeInt(id)); err != nil {
+		return -1, err
+	}
+	return id, s.addStats(1, length)
+}
+
+// AddIndex saves texts and postings of index. Texts with titles existing in store are replaced with their postings.
+// Keys are put to each bucket in sorted order, because bolt shifts keys of node on each put until commit,
+// so large transaction with keys in random order is quadratic
+func (s boltTx) AddIndex(index *revindex.Index) error {
+	// the last text with title replaces previous ones
+	last := make(map[string]int, len(index.Titles))
+	for i, title := range index.Titles {
+		last[title] = i
+	}
+	docs := make([]int, 0, len(last))
+	for _, i := range last {
+		docs = append(docs, i)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return index.Titles[docs[i]] < index.Titles[docs[j]]
+	})
+	ids := make(map[int]int64, len(docs))
+	for _, doc := range docs {
+		title := index.Titles[doc]
+		if _, err := s.DeleteTitle(title); err != nil {
+			return fmt.Errorf("cannot delete replaced text: %w", err)
+		}
+		length := 0
+		if doc < len(index.Lengths) {
+			length = index.Lengths[doc]
+		}
+		text := ""
+		if doc < len(index.Texts) {
+			text = index.Texts[doc]
+		}
+		id, err := s.AddTitle(title, text, length)
+		if err != nil {
+			return fmt.Errorf("cannot add title: %w", err)
+		}
+		ids[doc] = id
+	}
+	postings := make([]boltEntry, 0)
+	textWords := make([]boltEntry, 0)
+	counts := make(map[string]uint64)
+	for word, wordPostings := range index.Data {
+		for doc, positions := range wordPostings {
+			id, ok := ids[doc]
+			if !ok {
+				continue
+			}
+			postings = append(postings, boltEntry{postingsKey(word, id), revindex.AppendPositions(nil, positions)})
+			textWords = append(textWords, boltEntry{textWordKey(id, word), []byte{}})
+			counts[word]++
+		}
+	}
+	words := make([]boltEntry, 0, len(counts))
+	for word, count := range counts {
+		saved, err := decodeCount(s.tx.Bucket(wordsBucket).Get([]byte(word)))
+		if err != nil {
+			return err
+		}
+		words = append(words, boltEntry{[]byte(word), revindex.AppendUvarint(nil, saved+count)})
+	}
+	for _, b := range []struct {
+		name    []byte
+		entries []boltEntry
+	}{
+		{postingsBucket, postings}, {textWordsBucket, textWords}, {wordsBucket, words},
+	} {
+		if err := putSorted(s.tx.Bucket(b.name), b.entries); err != nil {
+			return fmt.Errorf("cannot add postings: %w", err)
+		}
+	}
+	return nil
+}
+
+// boltEntry is a key and value to put to bucket
+type boltEntry struct {
+	key   []byte
+	value []byte
+}
+
+// putSorted puts entries to bucket in order of their keys
+func putSorted(bucket *bolt.Bucket, entries []boltEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	for _, e := range entries {
+		if err := bucket.Put(e.key, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPostings saves positions of word in each text under its own key, so saved postings of word are not read
+func (s boltTx) AddPostings(word string, postings map[int64][]int) error {
+	words := s.tx.Bucket(wordsBucket)
+	count, err := decodeCount(words.Get([]byte(word)))
+	if err != nil {
+		return err
+	}
+	postingsOfWords := s.tx.Bucket(postingsBucket)
+	for id, positions := range postings {
+		key := postingsKey(word, id)
+		if postingsOfWords.Get(key) != nil {
+			return fmt.Errorf("postings of word '%s' in text %d are already added", word, id)
+		}
+		if s.tx.Bucket(titlesBucket).Get(encodeInt(id)) == nil {
+			return fmt.Errorf("no text with id %d", id)
+		}
+		if err = postingsOfWords.Put(key, revindex.AppendPositions(nil, positions)); err != nil {
+			return err
+		}
+		if err = s.tx.Bucket(textWordsBucket).Put(textWordKey(id, word), []byte{}); err != nil {
+			return err
+		}
+		count++
+	}
+	return words.Put([]byte(word), revindex.AppendUvarint(nil, count))
+}
+
+func (s boltTx) DeleteTitle(title string) (bool, error) {
+	key := s.tx.Bucket(titleIdsBucket).Get([]byte(title))
+	if key == nil {
+		return false, nil
+	}
+	id := decodeInt(key)
+	prefix := encodeInt(id)
+	// keys are collected first, because bucket must not be changed while cursor iterates over it
+	textWords := s.tx.Bucket(textWordsBucket)
+	keys := make([][]byte, 0)
+	c := textWords.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	words := s.tx.Bucket(wordsBucket)
+	for _, k := range keys {
+		word := k[len(prefix):]
+		count, err := decodeCount(words.Get(word))
+		if err != nil {
+			return false, err
+		}
+		if count <= 1 {
+			err = words.Delete(word)
+		} else {
+			err = words.Put(word, revindex.AppendUvarint(nil, count-1))
+		}
+		if err != nil {
+			return false, fmt.Errorf("cannot delete postings: %w", err)
+		}
+		if err = s.tx.Bucket(postingsBucket).Delete(postingsKey(string(word), id)); err != nil {
+			return false, fmt.Errorf("cannot delete postings: %w", err)
+		}
+		if err = textWords.Delete(k); err != nil {
+			return false, fmt.Errorf("cannot delete postings: %w", err)
+		}
+	}
+	titles := s.tx.Bucket(titlesBucket)
+	text, err := decodeText(titles.Get(prefix))
+	if err != nil {
+		return false, err
+	}
+	if err = s.addStats(-1, -text.length); err != nil {
+		return false, err
+	}
+	for _, err = range []error{
+		titles.Delete(prefix),
+		s.tx.Bucket(bodiesBucket).Delete(prefix),
+		s.tx.Bucket(titleIdsBucket).Delete([]byte(title)),
+	} {
+		if err != nil {
+			return false, fmt.Errorf("cannot delete title: %w", err)
+		}
+	}
+	return true, nil
+}
+
+func (s boltTx) GetWordsPostings(words []string) (map[string]map[int64][]int, error) {
+	res := make(map[string]map[int64][]int, len(words))
+	c := s.tx.Bucket(postingsBucket).Cursor()
+	for _, word := range words {
+		prefix := wordPrefix(word)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			positions, err := revindex.DecodePositions(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid postings of word '%s': %w", word, err)
+			}
+			if res[word] == nil {
+				res[word] = make(map[int64][]int)
+			}
+			res[word][decodeInt(k[len(prefix):])] = positions
+		}
+	}
+	return res, nil
+}
+
+func (s boltTx) GetWordsLike(pattern string, limit int) ([]string, error) {
+	// words matching pattern start with its literal prefix
+	prefix := []byte(likePrefix(pattern))
+	res := make([]string, 0)
+	frequencies := make(map[string]int)
+	c := s.tx.Bucket(wordsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		word := string(k)
+		if !revindex.MatchLike(pattern, word) {
+			continue
+		}
+		frequency, err := decodeCount(v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, word)
+		frequencies[word] = int(frequency)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if frequencies[res[i]] != frequencies[res[j]] {
+			return frequencies[res[i]] > frequencies[res[j]]
+		}
+		return res[i] < res[j]
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (s boltTx) GetWordsByLength(min int, max int) ([]string, error) {
+	res := make([]string, 0)
+	err := s.tx.Bucket(wordsBucket).ForEach(func(k, _ []byte) error {
+		if length := utf8.RuneCount(k); length >= min && length <= max {
+			res = append(res, string(k))
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s boltTx) GetWordFrequencies() (map[string]int, error) {
+	res := make(map[string]int)
+	err := s.tx.Bucket(wordsBucket).ForEach(func(k, v []byte) error {
+		frequency, err := decodeCount(v)
+		if err != nil {
+			return err
+		}
+		res[string(k)] = int(frequency)
+		return nil
+	})
+	return res, err
+}
+
+func (s boltTx) GetTitles(ids []int64) (map[int64]revindex.TextInfo, error) {
+	res := make(map[int64]revindex.TextInfo, len(ids))
+	for _, id := range ids {
+		value := s.tx.Bucket(titlesBucket).Get(encodeInt(id))
+		if value == nil {
+			continue
+		}
+		text, err := decodeText(value)
+		if err != nil {
+			return nil, err
+		}
+		res[id] = revindex.TextInfo{Title: text.title, Length: text.length}
+	}
+	return res, nil
+}
+
+func (s boltTx) GetTexts(ids []int64) (map[int64]string, error) {
+	res := make(map[int64]string, len(ids))
+	for _, id := range ids {
+		if body := s.tx.Bucket(bodiesBucket).Get(encodeInt(id)); body != nil {
+			res[id] = string(body)
+		}
+	}
+	return res, nil
+}
+
+func (s boltTx) GetTitleIds() ([]int64, error) {
+	res := make([]int64, 0)
+	err := s.tx.Bucket(titlesBucket).ForEach(func(k, _ []byte) error {
+		res = append(res, decodeInt(k))
+		return nil
+	})
+	return res, err
+}
+
+func (s boltTx) GetStats() (int, float64, error) {
+	stats := s.tx.Bucket(statsBucket)
+	count, length := decodeStat(stats.Get(textsKey)), decodeStat(stats.Get(lengthKey))
+	if count == 0 {
+		return 0, 0, nil
+	}
+	return int(count), float64(length) / float64(count), nil
+}
+
+func (s boltTx) GetVersion() (int64, error) {
+	return decodeStat(s.tx.Bucket(statsBucket).Get(versionKey)), nil
+}
+
+// addStats changes number of texts and their total length. Titles are changed, so version of store is changed too
+func (s boltTx) addStats(texts int, length int) error {
+	stats := s.tx.Bucket(statsBucket)
+	if err := stats.Put(versionKey, encodeInt(decodeStat(stats.Get(versionKey))+1)); err != nil {
+		return err
+	}
+	if err := stats.Put(textsKey, encodeInt(decodeStat(stats.Get(textsKey))+int64(texts))); err != nil {
+		return err
+	}
+	return stats.Put(lengthKey, encodeInt(decodeStat(stats.Get(lengthKey))+int64(length)))
+}
+
+func (s boltTx) GetSetting(key string) (string, bool, error) {
+	value := s.tx.Bucket(settingsBucket).Get([]byte(key))
+	return string(value), value != nil, nil
+}
+
+func (s boltTx) SetSetting(key string, value string) error {
+	return s.tx.Bucket(settingsBucket).Put([]byte(key), []byte(value))
+}
+
+func (s boltTx) GetDocuments() (map[string]revindex.Document, error) {
+	res := make(map[string]revindex.Document)
+	err := s.tx.Bucket(titlesBucket).ForEach(func(_, v []byte) error {
+		text, err := decodeText(v)
+		if err != nil {
+			return err
+		}
+		res[text.title] = text.doc
+		return nil
+	})
+	return res, err
+}
+
+func (s boltTx) SetDocument(title string, doc revindex.Document) error {
+	key := s.tx.Bucket(titleIdsBucket).Get([]byte(title))
+	if key == nil {
+		return nil
+	}
+	titles := s.tx.Bucket(titlesBucket)
+	text, err := decodeText(titles.Get(key))
+	if err != nil {
+		return err
+	}
+	text.doc = doc
+	return titles.Put(encodeInt(decodeInt(key)), encodeText(text))
+}
+
+// likePrefix returns literal prefix of LIKE pattern before the first '%' or '_'
+func likePrefix(pattern string) string {
+	res := make([]byte, 0, len(pattern))
+	escaped := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case escaped:
+			escaped = false
+			res = append(res, c)
+		case c == '\\':
+			escaped = true
+		case c == '%' || c == '_':
+			return string(res)
+		default:
+			res = append(res, c)
+		}
+	}
+	return string(res)
+}
+
+// encodeInt encodes value in big endian order, so keys of ids are sorted by them
+func encodeInt(value int64) []byte {
+	res := make([]byte, 8)
+	binary.BigEndian.PutUint64(res, uint64(value))
+	return res
+}
+
+func decodeInt(value []byte) int64 {
+	return int64(binary.BigEndian.Uint64(value))
+}
+
+// decodeStat decodes value of stats bucket, missing value is zero
+func decodeStat(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return decodeInt(value)
+}
+
+func textWordKey(id int64, word string) []byte {
+	return append(encodeInt(id), word...)
+}
+
+// encodeText writes title, length and source of text
+func encodeText(text boltText) []byte {
+	res := revindex.AppendUvarint(nil, uint64(len(text.title)))
+	res = append(res, text.title...)
+	res = revindex.AppendUvarint(res, uint64(text.length))
+	res = revindex.AppendUvarint(res, uint64(len(text.doc.Hash)))
+	res = append(res, text.doc.Hash...)
+	return appendVarint(res, text.doc.ModTime)
+}
+
+func decodeText(value []byte) (boltText, error) {
+	r := bytes.NewReader(value)
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return "", errors.New("invalid text")
+		}
+		s := make([]byte, n)
+		_, _ = r.Read(s)
+		return string(s), nil
+	}
+	var text boltText
+	var err error
+	if text.title, err = readString(); err != nil {
+		return boltText{}, err
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return boltText{}, errors.New("invalid text")
+	}
+	text.length = int(length)
+	if text.doc.Hash, err = readString(); err != nil {
+		return boltText{}, err
+	}
+	if text.doc.ModTime, err = binary.ReadVarint(r); err != nil {
+		return boltText{}, errors.New("invalid text")
+	}
+	return text, nil
+}
+
+// wordPrefix is a prefix of keys of postings of word. Word is prefixed by its length, so it is not a prefix
+// of keys of other words
+func wordPrefix(word string) []byte {
+	return append(revindex.AppendUvarint(nil, uint64(len(word))), word...)
+}
+
+func postingsKey(word string, id int64) []byte {
+	return append(wordPrefix(word), encodeInt(id)...)
+}
+
+// decodeCount decodes number of texts with word, missing value is zero
+func decodeCount(value []byte) (uint64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	count, n := binary.Uvarint(value)
+	if n <= 0 {
+		return 0, errors.New("invalid number of texts")
+	}
+	return count, nil
+}
+
+func appendVarint(buf []byte, value int64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	return append(buf, tmp[:binary.PutVarint(tmp, value)]...)
+}
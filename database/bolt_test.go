@@ -0,0 +1,306 @@
+package database
+
+import (
+	"errors"
+	"github.com/polisgo2020/search-K1ta/revindex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var boltTexts = []string{
+	"search engine for texts",
+	"searching texts in index",
+	"database index",
+	"engine of a car",
+}
+
+var boltTitles = []string{"se", "st", "db", "car"}
+
+var boltQueries = []string{"index", "texts -engine", "\"search engine\"", "search* OR db?", "enigne~", "NOT index", "car"}
+
+// openBolt opens store in temporary dir and returns function removing it
+func openBolt(t *testing.T) (*Bolt, string, func()) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal("Cannot create dir:", err)
+	}
+	path := filepath.Join(dir, "index.db")
+	store, err := OpenBolt(path)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal("Cannot open store:", err)
+	}
+	return store, path, func() {
+		_ = store.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// checkStores compares results of queries and documents in stores
+func checkStores(t *testing.T, exp revindex.Store, act revindex.Store) {
+	for _, q := range boltQueries {
+		expRes, err := revindex.FindInStore(q, exp, revindex.DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		actRes, err := revindex.FindInStore(q, act, revindex.DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		if !reflect.DeepEqual(actRes, expRes) {
+			t.Log("query:", q)
+			t.Log("exp=", expRes)
+			t.Log("act=", actRes)
+			t.Fatal("Wrong result")
+		}
+	}
+	expDocs, err := exp.GetDocuments()
+	if err != nil {
+		t.Fatal("Cannot get documents:", err)
+	}
+	actDocs, err := act.GetDocuments()
+	if err != nil {
+		t.Fatal("Cannot get documents:", err)
+	}
+	if !reflect.DeepEqual(actDocs, expDocs) {
+		t.Log("exp=", expDocs)
+		t.Log("act=", actDocs)
+		t.Fatal("Wrong documents")
+	}
+}
+
+func TestBolt(t *testing.T) {
+	index, err := revindex.Build(boltTexts, boltTitles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	exp := revindex.NewMemoryStore()
+	store, path, remove := openBolt(t)
+	defer remove()
+	for _, s := range []revindex.Store{exp, store} {
+		if err = index.SaveToStore(s); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		if err = s.SetDocument("se", revindex.Document{Hash: "hash", ModTime: 1}); err != nil {
+			t.Fatal("Cannot set document:", err)
+		}
+	}
+	checkStores(t, exp, store)
+
+	t.Run("delete and update", func(t *testing.T) {
+		for _, s := range []revindex.Store{exp, store} {
+			if ok, err := s.DeleteTitle("db"); err != nil || !ok {
+				t.Fatal("Wrong result of delete:", ok, err)
+			}
+			if ok, _ := s.DeleteTitle("db"); ok {
+				t.Fatal("Text is deleted twice")
+			}
+			if err := revindex.UpdateInStore(s, "st", "texts of new car"); err != nil {
+				t.Fatal("Cannot update text:", err)
+			}
+		}
+		checkStores(t, exp, store)
+		expWords, _ := exp.GetWordsByLength(1, 100)
+		actWords, err := store.GetWordsByLength(1, 100)
+		if err != nil {
+			t.Fatal("Cannot get words:", err)
+		}
+		sort.Strings(expWords)
+		if !reflect.DeepEqual(actWords, expWords) {
+			t.Log("exp=", expWords)
+			t.Log("act=", actWords)
+			t.Fatal("Wrong words")
+		}
+	})
+
+	t.Run("reopened file", func(t *testing.T) {
+		if err := store.Close(); err != nil {
+			t.Fatal("Cannot close store:", err)
+		}
+		if store, err = OpenBolt(path); err != nil {
+			t.Fatal("Cannot open store:", err)
+		}
+		checkStores(t, exp, store)
+	})
+
+	t.Run("drop all", func(t *testing.T) {
+		if err := store.DropAll(); err != nil {
+			t.Fatal("Cannot drop store:", err)
+		}
+		checkStores(t, revindex.NewMemoryStore(), store)
+		if count, _, err := store.GetStats(); err != nil || count != 0 {
+			t.Fatal("Wrong stats:", count, err)
+		}
+	})
+}
+
+func TestBolt_Batch(t *testing.T) {
+	store, _, remove := openBolt(t)
+	defer remove()
+	index, err := revindex.Build(boltTexts, boltTitles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	// failed batch changes nothing
+	err = revindex.Batch(store, func(s revindex.Store) error {
+		if err := index.SaveToStore(s); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		return errors.New("failed build")
+	})
+	t.Log("err:", err)
+	if ids, err := store.GetTitleIds(); err != nil || len(ids) != 0 {
+		t.Fatal("Texts of failed batch are saved:", ids, err)
+	}
+
+	t.Run("replaced texts", func(t *testing.T) {
+		// texts saved twice replace previous ones with their postings
+		for i := 0; i < 2; i++ {
+			if err := index.SaveToStore(store); err != nil {
+				t.Fatal("Cannot save index:", err)
+			}
+		}
+		exp := revindex.NewMemoryStore()
+		if err := index.SaveToStore(exp); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		checkStores(t, exp, store)
+		if err := store.DropAll(); err != nil {
+			t.Fatal("Cannot drop store:", err)
+		}
+	})
+
+	t.Run("drop all", func(t *testing.T) {
+		if err := index.SaveToStore(store); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		// texts dropped in failed batch are kept
+		err := revindex.Batch(store, func(s revindex.Store) error {
+			if err := s.(boltTx).DropAll(); err != nil {
+				t.Fatal("Cannot drop store:", err)
+			}
+			if ids, err := s.GetTitleIds(); err != nil || len(ids) != 0 {
+				t.Fatal("Texts are not dropped in batch:", ids, err)
+			}
+			return errors.New("failed build")
+		})
+		t.Log("err:", err)
+		if ids, err := store.GetTitleIds(); err != nil || len(ids) != len(boltTitles) {
+			t.Fatal("Texts are dropped by failed batch:", ids, err)
+		}
+	})
+}
+
+func TestBolt_AddPostings(t *testing.T) {
+	store, _, remove := openBolt(t)
+	defer remove()
+	ids := make([]int64, 2)
+	for i, title := range []string{"a", "b"} {
+		id, err := store.AddTitle(title, "", 2)
+		if err != nil {
+			t.Fatal("Cannot add title:", err)
+		}
+		ids[i] = id
+	}
+	// postings of word are added by several calls, word is a prefix of other word
+	for _, p := range []struct {
+		word     string
+		postings map[int64][]int
+	}{
+		{"word", map[int64][]int{ids[0]: {0, 1}}},
+		{"words", map[int64][]int{ids[0]: {1}}},
+		{"word", map[int64][]int{ids[1]: {1}}},
+	} {
+		if err := store.AddPostings(p.word, p.postings); err != nil {
+			t.Fatal("Cannot add postings:", err)
+		}
+	}
+	if err := store.AddPostings("word", map[int64][]int{ids[1]: {0}}); err == nil {
+		t.Fatal("Postings of word in text are added twice")
+	}
+	postings, err := store.GetWordsPostings([]string{"word", "words", "wor"})
+	if err != nil {
+		t.Fatal("Cannot get postings:", err)
+	}
+	exp := map[string]map[int64][]int{
+		"word":  {ids[0]: {0, 1}, ids[1]: {1}},
+		"words": {ids[0]: {1}},
+	}
+	t.Log("exp=", exp)
+	t.Log("act=", postings)
+	if !reflect.DeepEqual(postings, exp) {
+		t.Fatal("Wrong postings")
+	}
+
+	t.Run("delete title", func(t *testing.T) {
+		if _, err := store.DeleteTitle("a"); err != nil {
+			t.Fatal("Cannot delete title:", err)
+		}
+		frequencies, err := store.GetWordFrequencies()
+		if err != nil {
+			t.Fatal("Cannot get frequencies:", err)
+		}
+		postings, err := store.GetWordsPostings([]string{"word", "words"})
+		if err != nil {
+			t.Fatal("Cannot get postings:", err)
+		}
+		t.Log("act=", frequencies, postings)
+		if !reflect.DeepEqual(frequencies, map[string]int{"word": 1}) ||
+			!reflect.DeepEqual(postings, map[string]map[int64][]int{"word": {ids[1]: {1}}}) {
+			t.Fatal("Wrong postings after delete")
+		}
+	})
+}
+
+func TestBolt_CachedTitles(t *testing.T) {
+	store, _, remove := openBolt(t)
+	defer remove()
+	searcher := revindex.NewStoreSearcher(store)
+	for _, title := range []string{"car", "other car"} {
+		// texts get the same ids and stats after store is cleared
+		if err := store.DropAll(); err != nil {
+			t.Fatal("Cannot drop store:", err)
+		}
+		index, err := revindex.Build([]string{"engine of a car"}, []string{title})
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		if err = index.SaveToStore(store); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		res, err := searcher.Find("car", revindex.DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		t.Log("exp=", title)
+		t.Log("act=", res)
+		if len(res) != 1 || res[0].Title != title {
+			t.Fatal("Wrong result")
+		}
+	}
+}
+
+func TestLikePrefix(t *testing.T) {
+	tests := map[string]string{
+		"sea%":     "sea",
+		"s_a%":     "s",
+		"%ch":      "",
+		"100\\%%":  "100%",
+		"a\\_b":    "a_b",
+		"word":     "word",
+		"по\\_иск": "по_иск",
+	}
+	for pattern, exp := range tests {
+		t.Run(pattern, func(t *testing.T) {
+			if act := likePrefix(pattern); act != exp {
+				t.Log("exp=", exp)
+				t.Log("act=", act)
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
@@ -3,23 +3,63 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"github.com/lib/pq"
+	"github.com/polisgo2020/search-K1ta/revindex"
 )
 
+// DB is a store of index in Postgres
 type DB struct {
 	*sql.DB
+	// transaction of batch running all queries of store
+	tx *sql.Tx
 }
 
+// querier runs queries in database or in transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+var _ revindex.Store = (*DB)(nil)
+
 type TransactionErr struct {
 	ExecErr     string
 	RollbackErr string
 }
 
 const (
-	addTitle   = "insert into titles (title) values ($1) on conflict (title) do update SET title = $1 returning id"
-	addWord    = "insert into words (word) values ($1) on conflict (word) do update SET word = $1 returning id"
-	getIndices = "select title_id from word_title where word_id = (select id from words where word = $1)"
-	getTitle   = "select title from titles where id = $1"
-	dropAll    = "drop table if exists word_title; drop table if exists words; drop table if exists  titles"
+	addTitle         = "insert into titles (title, body, length) values ($1, $2, $3) on conflict (title) do update SET body = $2, length = $3 returning id"
+	addWord          = "insert into words (word) values ($1) on conflict (word) do update SET word = $1 returning id"
+	addPostings      = "insert into word_title (word_id, title_id, positions) values ($1, $2, $3)"
+	getPostings      = "select w.word, wt.title_id, wt.positions from words w join word_title wt on wt.word_id = w.id where w.word = any($1)"
+	getWordsLike     = "select w.word from words w join word_title wt on wt.word_id = w.id where w.word like $1 escape '\\' group by w.word order by count(*) desc, w.word limit $2"
+	getWordsByLength = "select word from words where char_length(word) between $1 and $2 order by word collate \"C\""
+	getFrequencies   = "select w.word, count(*) from words w join word_title wt on wt.word_id = w.id group by w.word"
+	getTitle         = "select title, length from titles where id = $1"
+	getTitles        = "select id, title, length from titles where id = any($1)"
+	getTexts         = "select id, body from titles where id = any($1)"
+	getStats         = "select count(*), coalesce(avg(length), 0) from titles"
+	getTitleIds      = "select id from titles"
+	getDocuments     = "select title, hash, mtime from titles"
+	setDocument      = "update titles set hash = $2, mtime = $3 where title = $1"
+	getStoreVersion  = "select coalesce((select value::bigint from settings where key = 'version'), 0)"
+	// transaction ids of Postgres grow, so versions are not reused after tables are dropped
+	setStoreVersion = "insert into settings (key, value) values ('version', txid_current()::text) on conflict (key) do update set value = excluded.value"
+	getSetting      = "select value from settings where key = $1"
+	setSetting      = "insert into settings (key, value) values ($1, $2) on conflict (key) do update set value = $2"
+	getTitleId      = "select id from titles where title = $1"
+	deletePostings  = "delete from word_title where title_id = $1 returning word_id"
+	deleteOrphans   = "delete from words w where w.id = any($1) and not exists (select 1 from word_title wt where wt.word_id = w.id)"
+	deleteTitle     = "delete from titles where id = $1"
+	createStaging   = "create temp table if not exists titles_staging (idx integer not null, title text not null, body text not null, length integer not null) on commit drop; create temp table if not exists word_title_staging (word text not null, title_idx integer not null, positions integer[] not null) on commit drop; truncate titles_staging, word_title_staging"
+	mergeTitles     = "insert into titles (title, body, length) select distinct on (title) title, body, length from titles_staging order by title, idx desc on conflict (title) do update set body = excluded.body, length = excluded.length"
+	mergeWords      = "insert into words (word) select distinct word from word_title_staging on conflict (word) do nothing"
+	// postings of texts in store replaced by texts in staging
+	dropStale     = "delete from word_title wt using titles t where wt.title_id = t.id and t.title in (select title from titles_staging) returning wt.word_id"
+	dropReplaced  = "delete from word_title_staging where title_idx not in (select max(idx) from titles_staging group by title)"
+	mergePostings = "insert into word_title (word_id, title_id, positions) select w.id, t.id, s.positions from word_title_staging s join words w on w.word = s.word join titles_staging ts on ts.idx = s.title_idx join titles t on t.title = ts.title"
+	dropAll       = "drop table if exists word_title; drop table if exists words; drop table if exists  titles; drop table if exists settings; drop table if exists schema_version"
 )
 
 func Connect(host string, port string, user string, password string, dbName string) (*DB, error) {
@@ -31,54 +71,22 @@ func Connect(host string, port string, user string, password string, dbName stri
 		return &DB{}, err
 	}
 	err = db.Ping()
-	return &DB{db}, err
-}
-
-func (db *DB) Init() error {
-	_, err := db.Exec(`create table if not exists words
-(
-	id serial not null
-		constraint words_pk
-			primary key,
-	word text
-);
-
-alter table words owner to postgres;
-
-create unique index if not exists words_word_uindex
-	on words (word);
-
-create table if not exists titles
-(
-	id serial not null
-		constraint titles_pk
-			primary key,
-	title text
-);
-
-alter table titles owner to postgres;
-
-create unique index if not exists titles_title_uindex
-	on titles (title);
-
-create table if not exists word_title
-(
-	word_id integer not null
-		constraint word_title_words_id_fk
-			references words,
-	title_id integer not null
-		constraint word_title_titles_id_fk
-			references titles,
-	constraint word_title_pk
-		primary key (word_id, title_id)
-);
-
-alter table word_title owner to postgres;
-`)
-	return err
+	return &DB{DB: db}, err
+}
+
+// q returns transaction of batch or database if store is not in batch
+func (db *DB) q() querier {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.DB
 }
 
-func (db *DB) DropAll() (err error) {
+// transaction calls function in transaction of batch or in a new one that is committed if function succeeds
+func (db *DB) transaction(fn func(tx *sql.Tx) error) (err error) {
+	if db.tx != nil {
+		return fn(db.tx)
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("cannot begin transaction: %w", err)
@@ -91,66 +99,431 @@ func (db *DB) DropAll() (err error) {
 			err = fmt.Errorf("error on transaction: %w", err)
 		}
 	}()
-	_, err = tx.Exec(dropAll)
-	if err != nil {
-		return
+	if err = fn(tx); err != nil {
+		return err
 	}
 	return tx.Commit()
 }
 
-func (db *DB) AddTitle(title string) (int64, error) {
+// Batch applies all changes of function in one transaction
+func (db *DB) Batch(fn func(revindex.Store) error) error {
+	return db.transaction(func(tx *sql.Tx) error {
+		return fn(&DB{DB: db.DB, tx: tx})
+	})
+}
+
+func (db *DB) DropAll() error {
+	return db.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(dropAll)
+		return err
+	})
+}
+
+// AddTitle saves title of text with its content and length in words
+func (db *DB) AddTitle(title string, body string, length int) (int64, error) {
 	lastInsertedId := int64(-1)
-	err := db.QueryRow(addTitle, title).Scan(&lastInsertedId)
+	err := db.transaction(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(addTitle, title, body, length).Scan(&lastInsertedId); err != nil {
+			return err
+		}
+		return bumpVersion(tx)
+	})
 	return lastInsertedId, err
 }
 
+// bumpVersion changes version of titles in store
+func bumpVersion(tx *sql.Tx) error {
+	if _, err := tx.Exec(setStoreVersion); err != nil {
+		return fmt.Errorf("cannot change version: %w", err)
+	}
+	return nil
+}
+
+// DeleteTitle removes text with title, its postings and words that are not used by other texts.
+// Returns false if there is no such title
+func (db *DB) DeleteTitle(title string) (ok bool, err error) {
+	err = db.transaction(func(tx *sql.Tx) error {
+		var titleId int64
+		err := tx.QueryRow(getTitleId, title).Scan(&titleId)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot get title: %w", err)
+		}
+		wordIds, err := queryIds(tx, deletePostings, titleId)
+		if err != nil {
+			return fmt.Errorf("cannot delete postings: %w", err)
+		}
+		if _, err = tx.Exec(deleteOrphans, pq.Array(wordIds)); err != nil {
+			return fmt.Errorf("cannot delete words: %w", err)
+		}
+		if _, err = tx.Exec(deleteTitle, titleId); err != nil {
+			return fmt.Errorf("cannot delete title: %w", err)
+		}
+		ok = true
+		return bumpVersion(tx)
+	})
+	return ok, err
+}
+
+// queryIds runs query returning ids in transaction
+func queryIds(tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+// AddIndex saves texts and postings of index in one transaction. Rows are copied to temporary staging tables
+// and merged to tables of store by a few queries, so it is much faster than adding them one by one.
+// Texts with titles existing in store are replaced with their postings, words left without texts are removed
+func (db *DB) AddIndex(index *revindex.Index) error {
+	return db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(createStaging); err != nil {
+			return fmt.Errorf("cannot create staging tables: %w", err)
+		}
+		err := copyIn(tx, pq.CopyIn("titles_staging", "idx", "title", "body", "length"), func(add copyRow) error {
+			for i, title := range index.Titles {
+				length := 0
+				if i < len(index.Lengths) {
+					length = index.Lengths[i]
+				}
+				body := ""
+				if i < len(index.Texts) {
+					body = index.Texts[i]
+				}
+				if err := add(i, title, body, length); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("cannot copy titles: %w", err)
+		}
+		// postings of replaced texts are deleted before merge of titles keeping their ids
+		staleWords, err := queryIds(tx, dropStale)
+		if err != nil {
+			return fmt.Errorf("cannot delete postings of replaced texts: %w", err)
+		}
+		if _, err = tx.Exec(mergeTitles); err != nil {
+			return fmt.Errorf("cannot add titles: %w", err)
+		}
+		if err = bumpVersion(tx); err != nil {
+			return err
+		}
+		err = copyIn(tx, pq.CopyIn("word_title_staging", "word", "title_idx", "positions"), func(add copyRow) error {
+			for word, postings := range index.Data {
+				for i, positions := range postings {
+					if err := add(word, i, pq.Array(toInt64s(positions))); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("cannot copy postings: %w", err)
+		}
+		// texts with the same title replace previous ones like in AddTitle, so only postings of the last one are kept
+		if _, err = tx.Exec(dropReplaced); err != nil {
+			return fmt.Errorf("cannot drop postings of replaced texts: %w", err)
+		}
+		if _, err = tx.Exec(mergeWords); err != nil {
+			return fmt.Errorf("cannot add words: %w", err)
+		}
+		if _, err = tx.Exec(mergePostings); err != nil {
+			return fmt.Errorf("cannot add postings: %w", err)
+		}
+		if _, err = tx.Exec(deleteOrphans, pq.Array(staleWords)); err != nil {
+			return fmt.Errorf("cannot delete words: %w", err)
+		}
+		return nil
+	})
+}
+
+// copyRow adds row to COPY
+type copyRow func(values ...interface{}) error
+
+// copyIn runs COPY statement in transaction with rows added by function
+func copyIn(tx *sql.Tx, query string, rows func(add copyRow) error) (err error) {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := stmt.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	err = rows(func(values ...interface{}) error {
+		_, err := stmt.Exec(values...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// flush buffered rows
+	_, err = stmt.Exec()
+	return err
+}
+
 func (db *DB) AddWord(word string) (int64, error) {
 	lastInsertedId := int64(-1)
-	err := db.QueryRow(addWord, word).Scan(&lastInsertedId)
+	err := db.q().QueryRow(addWord, word).Scan(&lastInsertedId)
 	return lastInsertedId, err
 }
 
-func (db *DB) AddWordsIndices(wordId int64, indices []int64) (err error) {
-	tx, err := db.Begin()
+// AddPostings saves word and its positions in each title
+func (db *DB) AddPostings(word string, postings map[int64][]int) error {
+	wordId, err := db.AddWord(word)
 	if err != nil {
-		return fmt.Errorf("cannot begin transaction: %w", err)
+		return fmt.Errorf("cannot add word: %w", err)
 	}
-	defer func() {
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				err = fmt.Errorf("%s; cannot rollback: %w", err, rollbackErr)
+	if wordId == -1 {
+		return fmt.Errorf("failed to add word")
+	}
+	return db.AddWordPostings(wordId, postings)
+}
+
+// AddWordPostings saves positions of word in each title
+func (db *DB) AddWordPostings(wordId int64, postings map[int64][]int) error {
+	return db.transaction(func(tx *sql.Tx) error {
+		for titleId, positions := range postings {
+			if _, err := tx.Exec(addPostings, wordId, titleId, pq.Array(toInt64s(positions))); err != nil {
+				return fmt.Errorf("cannot insert: %w", err)
 			}
-			err = fmt.Errorf("error on transaction: %w", err)
 		}
-	}()
-	for _, titleId := range indices {
-		if _, err = tx.Exec("insert into word_title (word_id, title_id) values ($1, $2)", wordId, titleId); err != nil {
-			return fmt.Errorf("cannot insert: %w", err)
+		return nil
+	})
+}
+
+// GetWordsPostings returns positions of words in each title by one query
+func (db *DB) GetWordsPostings(words []string) (map[string]map[int64][]int, error) {
+	rows, err := db.q().Query(getPostings, pq.Array(words))
+	if err != nil {
+		return nil, fmt.Errorf("error on get postings: %w", err)
+	}
+	defer rows.Close()
+	res := make(map[string]map[int64][]int, len(words))
+	for rows.Next() {
+		var word string
+		var titleId int64
+		var positions []int64
+		if err = rows.Scan(&word, &titleId, pq.Array(&positions)); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		if res[word] == nil {
+			res[word] = make(map[int64][]int)
 		}
+		res[word][titleId] = toInts(positions)
 	}
-	err = tx.Commit()
-	return
+	return res, rows.Err()
 }
 
-func (db *DB) GetWordIndiced(word string) ([]int64, error) {
-	rows, err := db.Query(getIndices, word)
+// GetWordsLike returns at most limit words matching LIKE pattern escaped with '\', the most frequent first.
+// Patterns with literal prefix use index of words
+func (db *DB) GetWordsLike(pattern string, limit int) ([]string, error) {
+	rows, err := db.q().Query(getWordsLike, pattern, limit)
 	if err != nil {
-		return nil, fmt.Errorf("error on get indices: %w", err)
+		return nil, fmt.Errorf("error on get words: %w", err)
 	}
-	res := make([]int64, 0)
+	defer rows.Close()
+	res := make([]string, 0)
 	for rows.Next() {
-		var index int64
-		err = rows.Scan(&index)
-		if err != nil {
+		var word string
+		if err = rows.Scan(&word); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		res = append(res, word)
+	}
+	return res, rows.Err()
+}
+
+// GetWordsByLength returns words with length in characters between min and max sorted by bytes
+func (db *DB) GetWordsByLength(min int, max int) ([]string, error) {
+	rows, err := db.q().Query(getWordsByLength, min, max)
+	if err != nil {
+		return nil, fmt.Errorf("error on get words: %w", err)
+	}
+	defer rows.Close()
+	res := make([]string, 0)
+	for rows.Next() {
+		var word string
+		if err = rows.Scan(&word); err != nil {
 			return nil, fmt.Errorf("error on scan: %w", err)
 		}
-		res = append(res, index)
+		res = append(res, word)
 	}
-	return res, nil
+	return res, rows.Err()
+}
+
+// GetWordFrequencies returns number of titles with each word
+func (db *DB) GetWordFrequencies() (map[string]int, error) {
+	rows, err := db.q().Query(getFrequencies)
+	if err != nil {
+		return nil, fmt.Errorf("error on get word frequencies: %w", err)
+	}
+	defer rows.Close()
+	res := make(map[string]int)
+	for rows.Next() {
+		var word string
+		var frequency int
+		if err = rows.Scan(&word, &frequency); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		res[word] = frequency
+	}
+	return res, rows.Err()
 }
 
 func (db *DB) GetTitleById(id int64) (string, error) {
-	var title string
-	err := db.QueryRow(getTitle, id).Scan(&title)
+	title, _, err := db.GetTitle(id)
 	return title, err
 }
+
+// GetTitle returns title and length of text by id
+func (db *DB) GetTitle(id int64) (string, int, error) {
+	var title string
+	var length int
+	err := db.q().QueryRow(getTitle, id).Scan(&title, &length)
+	return title, length, err
+}
+
+// GetTitles returns titles and lengths of texts by ids by one query
+func (db *DB) GetTitles(ids []int64) (map[int64]revindex.TextInfo, error) {
+	rows, err := db.q().Query(getTitles, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("error on get titles: %w", err)
+	}
+	defer rows.Close()
+	res := make(map[int64]revindex.TextInfo, len(ids))
+	for rows.Next() {
+		var id int64
+		var title revindex.TextInfo
+		if err = rows.Scan(&id, &title.Title, &title.Length); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		res[id] = title
+	}
+	return res, rows.Err()
+}
+
+// GetTexts returns contents of texts by ids by one query
+func (db *DB) GetTexts(ids []int64) (map[int64]string, error) {
+	rows, err := db.q().Query(getTexts, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("error on get texts: %w", err)
+	}
+	defer rows.Close()
+	res := make(map[int64]string, len(ids))
+	for rows.Next() {
+		var id int64
+		var body string
+		if err = rows.Scan(&id, &body); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		res[id] = body
+	}
+	return res, rows.Err()
+}
+
+// GetTitleIds returns ids of all texts
+func (db *DB) GetTitleIds() ([]int64, error) {
+	rows, err := db.q().Query(getTitleIds)
+	if err != nil {
+		return nil, fmt.Errorf("error on get title ids: %w", err)
+	}
+	defer rows.Close()
+	res := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+// GetDocuments returns sources of all texts by their titles
+func (db *DB) GetDocuments() (map[string]revindex.Document, error) {
+	rows, err := db.q().Query(getDocuments)
+	if err != nil {
+		return nil, fmt.Errorf("error on get documents: %w", err)
+	}
+	defer rows.Close()
+	res := make(map[string]revindex.Document)
+	for rows.Next() {
+		var title string
+		var doc revindex.Document
+		if err = rows.Scan(&title, &doc.Hash, &doc.ModTime); err != nil {
+			return nil, fmt.Errorf("error on scan: %w", err)
+		}
+		res[title] = doc
+	}
+	return res, rows.Err()
+}
+
+// SetDocument saves source of text with title
+func (db *DB) SetDocument(title string, doc revindex.Document) error {
+	_, err := db.q().Exec(setDocument, title, doc.Hash, doc.ModTime)
+	return err
+}
+
+// GetVersion returns version of titles in store, 0 if texts were not added yet
+func (db *DB) GetVersion() (int64, error) {
+	var version int64
+	err := db.q().QueryRow(getStoreVersion).Scan(&version)
+	return version, err
+}
+
+// GetSetting returns value of setting and false if it is not set
+func (db *DB) GetSetting(key string) (string, bool, error) {
+	var value string
+	err := db.q().QueryRow(getSetting, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (db *DB) SetSetting(key string, value string) error {
+	_, err := db.q().Exec(setSetting, key, value)
+	return err
+}
+
+// GetStats returns number of texts and their average length
+func (db *DB) GetStats() (int, float64, error) {
+	var count int
+	var avgLength float64
+	err := db.q().QueryRow(getStats).Scan(&count, &avgLength)
+	return count, avgLength, err
+}
+
+func toInt64s(values []int) []int64 {
+	res := make([]int64, len(values))
+	for i, v := range values {
+		res[i] = int64(v)
+	}
+	return res
+}
+
+func toInts(values []int64) []int {
+	res := make([]int, len(values))
+	for i, v := range values {
+		res[i] = int(v)
+	}
+	return res
+}
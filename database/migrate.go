@@ -0,0 +1,269 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration changes schema of database to its version. Down reverts changes of Up
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration with time when it was applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations of schema ordered by versions starting from 1. Applied migrations must not be changed, add new ones instead.
+// Migrations up to 6 create schema made by Init before migrations, so they skip existing tables and columns
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create words, titles and word_title",
+		Up: `create table if not exists words
+(
+	id serial not null
+		constraint words_pk
+			primary key,
+	word text
+);
+
+alter table words owner to postgres;
+
+create unique index if not exists words_word_uindex
+	on words (word);
+
+create table if not exists titles
+(
+	id serial not null
+		constraint titles_pk
+			primary key,
+	title text
+);
+
+alter table titles owner to postgres;
+
+create unique index if not exists titles_title_uindex
+	on titles (title);
+
+create table if not exists word_title
+(
+	word_id integer not null
+		constraint word_title_words_id_fk
+			references words,
+	title_id integer not null
+		constraint word_title_titles_id_fk
+			references titles,
+	constraint word_title_pk
+		primary key (word_id, title_id)
+);
+
+alter table word_title owner to postgres;
+`,
+		Down: "drop table if exists word_title; drop table if exists words; drop table if exists titles",
+	},
+	{
+		Version: 2,
+		Name:    "add positions of words",
+		Up:      "alter table word_title add column if not exists positions integer[] not null default '{}'",
+		Down:    "alter table word_title drop column if exists positions",
+	},
+	{
+		Version: 3,
+		Name:    "add lengths and contents of texts",
+		Up: `alter table titles add column if not exists length integer not null default 0;
+
+alter table titles add column if not exists body text not null default '';
+`,
+		Down: "alter table titles drop column if exists length; alter table titles drop column if exists body",
+	},
+	{
+		Version: 4,
+		Name:    "create settings",
+		Up: `create table if not exists settings
+(
+	key text not null
+		constraint settings_pk
+			primary key,
+	value text not null
+);
+
+alter table settings owner to postgres;
+`,
+		Down: "drop table if exists settings",
+	},
+	{
+		Version: 5,
+		Name:    "add indexes of words for wildcards and typos",
+		Up: `create index if not exists words_word_pattern_index
+	on words (word text_pattern_ops);
+
+create index if not exists words_length_index
+	on words (char_length(word));
+`,
+		Down: "drop index if exists words_word_pattern_index; drop index if exists words_length_index",
+	},
+	{
+		Version: 6,
+		Name:    "add sources of texts",
+		Up: `alter table titles add column if not exists hash text not null default '';
+
+alter table titles add column if not exists mtime bigint not null default 0;
+`,
+		Down: "alter table titles drop column if exists hash; alter table titles drop column if exists mtime",
+	},
+}
+
+const (
+	createVersionTable = `create table if not exists schema_version
+(
+	version integer not null
+		constraint schema_version_pk
+			primary key,
+	name text not null,
+	applied_at timestamp with time zone not null default now()
+)`
+	// key of advisory lock, so migrations are not applied concurrently
+	lockMigrations = "select pg_advisory_xact_lock(7226458)"
+	getVersion     = "select coalesce(max(version), 0) from schema_version"
+	getApplied     = "select version, applied_at from schema_version"
+	addVersion     = "insert into schema_version (version, name) values ($1, $2)"
+	deleteVersion  = "delete from schema_version where version = $1"
+)
+
+// LatestVersion returns version of schema after all migrations
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Init creates schema of database or updates it to the latest version
+func (db *DB) Init() error {
+	_, err := db.MigrateUp(LatestVersion())
+	return err
+}
+
+// Version returns version of schema, 0 if no migrations are applied
+func (db *DB) Version() (version int, err error) {
+	err = db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(createVersionTable); err != nil {
+			return fmt.Errorf("cannot create version table: %w", err)
+		}
+		return tx.QueryRow(getVersion).Scan(&version)
+	})
+	return version, err
+}
+
+// MigrationStatus returns all migrations and whether they are applied
+func (db *DB) MigrationStatus() (res []MigrationStatus, err error) {
+	err = db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(createVersionTable); err != nil {
+			return fmt.Errorf("cannot create version table: %w", err)
+		}
+		rows, err := tx.Query(getApplied)
+		if err != nil {
+			return fmt.Errorf("error on get versions: %w", err)
+		}
+		defer rows.Close()
+		applied := make(map[int]time.Time)
+		for rows.Next() {
+			var version int
+			var appliedAt time.Time
+			if err = rows.Scan(&version, &appliedAt); err != nil {
+				return fmt.Errorf("error on scan: %w", err)
+			}
+			applied[version] = appliedAt
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		res = make([]MigrationStatus, 0, len(migrations))
+		for _, m := range migrations {
+			appliedAt, ok := applied[m.Version]
+			res = append(res, MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return res, err
+}
+
+// MigrateUp applies migrations up to version in one transaction and returns applied ones
+func (db *DB) MigrateUp(version int) ([]Migration, error) {
+	return db.migrate(version, true)
+}
+
+// MigrateDown reverts migrations down to version in one transaction and returns reverted ones.
+// Version 0 reverts all migrations
+func (db *DB) MigrateDown(version int) ([]Migration, error) {
+	return db.migrate(version, false)
+}
+
+func (db *DB) migrate(target int, up bool) (res []Migration, err error) {
+	err = db.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(lockMigrations); err != nil {
+			return fmt.Errorf("cannot lock migrations: %w", err)
+		}
+		if _, err := tx.Exec(createVersionTable); err != nil {
+			return fmt.Errorf("cannot create version table: %w", err)
+		}
+		var version int
+		if err := tx.QueryRow(getVersion).Scan(&version); err != nil {
+			return fmt.Errorf("cannot get version: %w", err)
+		}
+		plan, err := planMigrations(version, target, up)
+		if err != nil {
+			return err
+		}
+		for _, m := range plan {
+			if up {
+				if _, err = tx.Exec(m.Up); err != nil {
+					return fmt.Errorf("cannot apply migration %d '%s': %w", m.Version, m.Name, err)
+				}
+				_, err = tx.Exec(addVersion, m.Version, m.Name)
+			} else {
+				if _, err = tx.Exec(m.Down); err != nil {
+					return fmt.Errorf("cannot revert migration %d '%s': %w", m.Version, m.Name, err)
+				}
+				_, err = tx.Exec(deleteVersion, m.Version)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot save version %d: %w", m.Version, err)
+			}
+		}
+		res = plan
+		return nil
+	})
+	return res, err
+}
+
+// planMigrations returns migrations changing schema from version to target in order of applying.
+// Migrations up are applied from older to newer ones and migrations down are reverted from newer to older ones
+func planMigrations(version int, target int, up bool) ([]Migration, error) {
+	latest := LatestVersion()
+	if version > latest {
+		return nil, fmt.Errorf("version of schema %d is newer than the latest known version %d", version, latest)
+	}
+	if target < 0 || target > latest {
+		return nil, fmt.Errorf("unknown version %d, versions are from 0 to %d", target, latest)
+	}
+	if up {
+		if target < version {
+			return nil, fmt.Errorf("cannot migrate up from version %d to older version %d", version, target)
+		}
+		return migrations[version:target], nil
+	}
+	if target > version {
+		return nil, fmt.Errorf("cannot migrate down from version %d to newer version %d", version, target)
+	}
+	res := make([]Migration, 0, version-target)
+	for i := version - 1; i >= target; i-- {
+		res = append(res, migrations[i])
+	}
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("Migration '%s' has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+		if m.Name == "" || m.Up == "" || m.Down == "" {
+			t.Fatalf("Migration %d is not complete", m.Version)
+		}
+	}
+}
+
+func TestPlanMigrations(t *testing.T) {
+	latest := LatestVersion()
+	versions := func(plan []Migration) []int {
+		res := make([]int, 0, len(plan))
+		for _, m := range plan {
+			res = append(res, m.Version)
+		}
+		return res
+	}
+	tests := []struct {
+		name    string
+		version int
+		target  int
+		up      bool
+		exp     []int
+	}{
+		{name: "up from empty", version: 0, target: 3, up: true, exp: []int{1, 2, 3}},
+		{name: "up to latest", version: latest - 1, target: latest, up: true, exp: []int{latest}},
+		{name: "up to current", version: 2, target: 2, up: true, exp: []int{}},
+		{name: "down", version: 3, target: 1, up: false, exp: []int{3, 2}},
+		{name: "down to empty", version: 2, target: 0, up: false, exp: []int{2, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := planMigrations(test.version, test.target, test.up)
+			if err != nil {
+				t.Fatal("Cannot plan migrations:", err)
+			}
+			act := versions(plan)
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Fatal("Wrong migrations")
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		version int
+		target  int
+		up      bool
+	}{
+		{name: "up to older", version: 3, target: 1, up: true},
+		{name: "down to newer", version: 1, target: 3, up: false},
+		{name: "unknown target", version: 0, target: latest + 1, up: true},
+		{name: "negative target", version: 1, target: -1, up: false},
+		{name: "unknown schema", version: latest + 1, target: latest, up: false},
+	}
+	for _, test := range errTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := planMigrations(test.version, test.target, test.up)
+			t.Log("err:", err)
+			if err == nil {
+				t.Fatal("Plan must return an error")
+			}
+		})
+	}
+}
@@ -8,4 +8,6 @@ require (
 	github.com/lib/pq v1.4.0
 	github.com/sirupsen/logrus v1.5.0
 	github.com/urfave/cli/v2 v2.2.0
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/text v0.3.2
 )
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/polisgo2020/search-K1ta/revindex"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Changes of files in dir since the previous build
+type changes struct {
+	// new and changed files, they are read again on indexing
+	files []dirFile
+	// titles of changed and removed files, their old texts must be deleted
+	changed []string
+	removed []string
+	// sources of all files in dir
+	docs      map[string]revindex.Document
+	added     int
+	unchanged int
+}
+
+// Compare files in dir with sources of texts saved by the previous build.
+// Files with the same modification time are not read, other files are compared by hash of content
+func getChanges(dir string, opts walkOptions, saved map[string]revindex.Document) (*changes, error) {
+	files, err := walkDir(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := changes{docs: make(map[string]revindex.Document, len(files))}
+	for _, file := range files {
+		name := file.title
+		old, ok := saved[name]
+		doc := revindex.Document{ModTime: file.info.ModTime().UnixNano()}
+		if ok && old.ModTime == doc.ModTime {
+			c.docs[name] = old
+			c.unchanged++
+			continue
+		}
+		hash, err := fileHash(file.path)
+		if err != nil {
+			return nil, fmt.Errorf("error on reading '%s': %w", name, err)
+		}
+		doc.Hash = hash
+		c.docs[name] = doc
+		switch {
+		case ok && old.Hash == doc.Hash:
+			// file is touched, only its modification time is saved
+			c.unchanged++
+			continue
+		case ok:
+			c.changed = append(c.changed, name)
+		default:
+			c.added++
+		}
+		c.files = append(c.files, file)
+	}
+	for name := range saved {
+		if _, ok := c.docs[name]; !ok {
+			c.removed = append(c.removed, name)
+		}
+	}
+	sort.Strings(c.removed)
+	return &c, nil
+}
+
+// Hash of content of file, file is read by blocks
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Summary of changes for console
+func (c *changes) summary() string {
+	return fmt.Sprintf("Added: %d, changed: %d, removed: %d, unchanged: %d",
+		c.added, len(c.changed), len(c.removed), c.unchanged)
+}
+
+// Add new and changed files in dir to store and remove vanished ones. Files are indexed in chunks
+// of texts up to budget bytes, so they are not in memory at once. Store is cleared in the same batch
+// if clearDb is set, so it keeps old texts if build fails
+func buildStore(dir string, opts walkOptions, analyzer revindex.Analyzer, workers int, budget int, clearDb bool, store revindex.Store) {
+	saved := make(map[string]revindex.Document)
+	if !clearDb {
+		var err error
+		if saved, err = store.GetDocuments(); err != nil {
+			console.Fatal("Error on getting texts from store:", err)
+		}
+	}
+	c, err := getChanges(dir, opts, saved)
+	if err != nil {
+		console.Fatal("Error:", err)
+	}
+	// changes are applied at once if store supports transactions
+	err = revindex.Batch(store, func(store revindex.Store) error {
+		if clearDb {
+			console.Println("Clearing database")
+			if err := clearStore(store); err != nil {
+				return fmt.Errorf("cannot clear store: %w", err)
+			}
+		}
+		for _, title := range append(c.removed, c.changed...) {
+			if _, err := store.DeleteTitle(title); err != nil {
+				return fmt.Errorf("cannot remove text: %w", err)
+			}
+		}
+		if err := saveFiles(store, c.files, analyzer, workers, budget); err != nil {
+			return err
+		}
+		for title, doc := range c.docs {
+			if doc != saved[title] {
+				if err := store.SetDocument(title, doc); err != nil {
+					return fmt.Errorf("cannot save source of text: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		console.Fatal("Error on saving to store: ", err)
+	}
+	console.Println(c.summary())
+}
+
+// Index files by chunks of texts up to budget bytes and save them to store
+func saveFiles(store revindex.Store, files []dirFile, analyzer revindex.Analyzer, workers int, budget int) error {
+	texts := make([]string, 0)
+	titles := make([]string, 0)
+	size := 0
+	flush := func() error {
+		index, err := revindex.BuildParallel(texts, titles, analyzer, workers)
+		if err != nil {
+			return fmt.Errorf("cannot build index: %w", err)
+		}
+		if err = index.SaveToStore(store); err != nil {
+			return fmt.Errorf("cannot save index: %w", err)
+		}
+		texts, titles, size = texts[:0], titles[:0], 0
+		return nil
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			return fmt.Errorf("error on reading '%s': %w", file.title, err)
+		}
+		texts = append(texts, string(content))
+		titles = append(titles, file.title)
+		size += len(content)
+		if size >= budget {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	return flush()
+}
+
+// Add new and changed files in dir to dir of segments as a new segment and remove vanished ones.
+// Files are read by stream builder, so memory is limited by budget
+func buildSegments(dir string, opts walkOptions, analyzer revindex.Analyzer, workers int, budget int, segmentsDir string) {
+	segments, err := revindex.OpenSegments(segmentsDir)
+	if err != nil {
+		console.Fatal("Cannot open segments: ", err)
+	}
+	saved, err := segments.Documents()
+	if err != nil {
+		console.Fatal("Cannot read segments: ", err)
+	}
+	c, err := getChanges(dir, opts, saved)
+	if err != nil {
+		console.Fatal("Error:", err)
+	}
+	builder, err := revindex.NewStreamBuilder(analyzer, budget, workers, "")
+	if err != nil {
+		console.Fatal("Error on building index: ", err)
+	}
+	for _, file := range c.files {
+		if err = builder.AddFile(file.title, file.path); err != nil {
+			_ = builder.Close()
+			console.Fatal("Error on building index: ", err)
+		}
+	}
+	// old texts are deleted in the same update of segments as new ones are added
+	if _, err = segments.Replace(append(c.removed, c.changed...), builder); err != nil {
+		console.Fatal("Cannot add segment: ", err)
+	}
+	if err = segments.SetDocuments(c.docs); err != nil {
+		console.Fatal("Cannot save sources of texts: ", err)
+	}
+	if err = segments.Close(); err != nil {
+		console.Fatal("Cannot merge segments: ", err)
+	}
+	console.Println(c.summary())
+}
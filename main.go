@@ -1,7 +1,9 @@
 package main
 
 import (
-	"fmt"
+	"bufio"
+	"bytes"
+	"errors"
 	"github.com/caarlos0/env/v6"
 	_ "github.com/lib/pq"
 	"github.com/polisgo2020/search-K1ta/database"
@@ -12,8 +14,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
-	"sync"
+	"strings"
+	"time"
 )
 
 // app config
@@ -24,8 +26,25 @@ type Config struct {
 	Username     string `env:"DB_USERNAME" envDefault:"postgres"`
 	Password     string `env:"DB_PASSWORD" envDefault:"postgres"`
 	DatabaseName string `env:"DB_NAME" envDefault:"postgres"`
+	// parameters of BM25 ranking
+	K1 float64 `env:"BM25_K1" envDefault:"1.2"`
+	B  float64 `env:"BM25_B" envDefault:"0.75"`
+	// search words with typos if there are no exact results
+	FuzzyFallback bool `env:"FUZZY_FALLBACK" envDefault:"false"`
+	// binary index file or dir of segments to search instead of store
+	IndexFile string `env:"INDEX_FILE"`
+	// store of index: postgres, bolt or memory. Memory store is not saved between runs
+	Store string `env:"STORE" envDefault:"postgres"`
+	// file of bolt store
+	DbFile string `env:"DB_FILE" envDefault:"index.db"`
 }
 
+const (
+	storePostgres = "postgres"
+	storeBolt     = "bolt"
+	storeMemory   = "memory"
+)
+
 // logger for console
 var console = log.New(os.Stdout, "", 0)
 var cfg Config
@@ -40,7 +59,7 @@ func main() {
 			{
 				Name:    "build",
 				Aliases: []string{"b"},
-				Usage:   "Build index by files in dir",
+				Usage:   "Build index by files in dir and its subdirs. Repeated build to store or segments indexes only new and changed files",
 				Flags: []cli.Flag{
 					&cli.BoolFlag{
 						Name:    "clear",
@@ -48,6 +67,60 @@ func main() {
 						Usage:   "clear database before saving index",
 						Value:   false,
 					},
+					&cli.StringFlag{
+						Name:    "analyzer",
+						Aliases: []string{"a"},
+						Usage: "analyzer of texts: tokenizer (words, letters) and filters (lowercase, nfkc, length=<min>,<max>, " +
+							"stem=<en|ru>, stop=<en,ru>) separated by '|'. Must be the same for all texts in store",
+						Value: revindex.DefaultAnalyzerSpec,
+					},
+					&cli.StringFlag{
+						Name:    "lang",
+						Aliases: []string{"l"},
+						Usage:   "comma separated languages of texts (en, ru) to use their analyzer with stopwords and stemming instead of --analyzer",
+					},
+					&cli.StringFlag{
+						Name:    "stopwords",
+						Aliases: []string{"s"},
+						Usage:   "file with additional stopwords separated by spaces or new lines",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "save index to binary file instead of store",
+					},
+					&cli.IntFlag{
+						Name:  "memory",
+						Usage: "memory budget in MB on building, postings exceeding it are flushed to temporary files and texts exceeding it are saved to store in parts",
+						Value: revindex.DefaultBudget >> 20,
+					},
+					&cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "glob pattern of files to index, e.g. '*.txt' or 'docs/**/*.md'. Pattern without '/' matches names of files",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "glob pattern of files and dirs to skip, e.g. 'drafts' or 'docs/old/**'",
+					},
+					&cli.StringFlag{
+						Name:  "symlinks",
+						Usage: "policy of symbolic links: skip, files (read links to files only) or follow (walk links to dirs too)",
+						Value: symlinksFiles,
+					},
+					&cli.BoolFlag{
+						Name:  "hidden",
+						Usage: "index files and dirs with names starting with '.'",
+					},
+					&cli.IntFlag{
+						Name:    "workers",
+						Aliases: []string{"w"},
+						Usage:   "number of workers reading and analyzing texts in parallel",
+						Value:   1,
+					},
+					&cli.StringFlag{
+						Name:  "segments",
+						Usage: "add texts to dir of index segments as a new segment instead of store. Small segments are merged",
+					},
 				},
 				ArgsUsage: "<dir>",
 				Action: func(ctx *cli.Context) error {
@@ -56,39 +129,247 @@ func main() {
 						console.Fatal("Specify dir with files")
 					}
 					clearDb := ctx.Bool("clear")
-					build(dir, clearDb)
+					spec := ctx.String("analyzer")
+					if ctx.IsSet("lang") {
+						if ctx.IsSet("analyzer") {
+							console.Fatal("Specify either analyzer or languages")
+						}
+						var err error
+						if spec, err = revindex.LanguageAnalyzerSpec(strings.Split(ctx.String("lang"), ",")...); err != nil {
+							console.Fatal("Invalid languages: ", err)
+						}
+					}
+					var stopwords []string
+					if file := ctx.String("stopwords"); file != "" {
+						spec, stopwords = withStopwordsFromFile(spec, file)
+					}
+					analyzer, err := revindex.ParseAnalyzerWithStopwords(spec, stopwords)
+					if err != nil {
+						console.Fatal("Invalid analyzer: ", err)
+					}
+					if ctx.IsSet("output") && ctx.IsSet("segments") {
+						console.Fatal("Specify either output file or segments dir")
+					}
+					opts := walkOptions{
+						include:  ctx.StringSlice("include"),
+						exclude:  ctx.StringSlice("exclude"),
+						symlinks: ctx.String("symlinks"),
+						hidden:   ctx.Bool("hidden"),
+					}
+					if ctx.Int("workers") < 1 {
+						console.Fatal("Number of workers must be positive")
+					}
+					build(dir, opts, clearDb, analyzer, ctx.Int("workers"), ctx.String("output"), ctx.Int("memory")<<20, ctx.String("segments"))
+					return nil
+				},
+			},
+			{
+				Name:      "remove",
+				Aliases:   []string{"rm"},
+				Usage:     "Remove texts with title from index",
+				ArgsUsage: "<title>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "index",
+						Aliases: []string{"i"},
+						Usage:   "index file or dir of segments to change instead of store. Env variable: INDEX_FILE",
+						Value:   cfg.IndexFile,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					title := ctx.Args().Get(0)
+					if title == "" {
+						console.Fatal("Specify title of text")
+					}
+					remove(title, ctx.String("index"))
+					return nil
+				},
+			},
+			{
+				Name:        "update",
+				Aliases:     []string{"u"},
+				Usage:       "Replace texts with title of file by content of file or add it to index",
+				Description: "Title of text is path of file relative to dir of build command set by --dir as titles of built texts, or it is set by --title",
+				ArgsUsage:   "<file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "title",
+						Aliases: []string{"t"},
+						Usage:   "title of text instead of path of file relative to dir",
+					},
+					&cli.StringFlag{
+						Name:    "dir",
+						Aliases: []string{"d"},
+						Usage:   "dir of build command containing file, title is path of file relative to it",
+					},
+					&cli.StringFlag{
+						Name:    "index",
+						Aliases: []string{"i"},
+						Usage:   "index file or dir of segments to change instead of store. Env variable: INDEX_FILE",
+						Value:   cfg.IndexFile,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					file := ctx.Args().Get(0)
+					if file == "" {
+						console.Fatal("Specify file with text")
+					}
+					if ctx.IsSet("title") == ctx.IsSet("dir") {
+						console.Fatal("Specify either title of text or dir of build command")
+					}
+					title := ctx.String("title")
+					if ctx.IsSet("dir") {
+						var err error
+						if title, err = fileTitle(ctx.String("dir"), file); err != nil {
+							console.Fatal("Error: ", err)
+						}
+					}
+					update(file, title, ctx.String("index"))
 					return nil
 				},
 			},
 			{
-				Name:      "find",
-				Aliases:   []string{"f"},
-				Usage:     "Find phrase in specified index",
-				ArgsUsage: "\"<phrase>\"",
+				Name:        "find",
+				Aliases:     []string{"f"},
+				Usage:       "Find phrase in specified index",
+				Description: "Wrap words into quotes to search exact phrase, e.g. '\"new york\" city'. Use +word and -word to require or exclude word, AND, OR, NOT and parentheses to combine terms, * and ? for any characters, e.g. 'databas*', word~, word~1 or word~2 for words with typos",
+				ArgsUsage:   "\"<phrase>\"",
+				Flags: []cli.Flag{
+					&cli.Float64Flag{
+						Name:  "k1",
+						Usage: "BM25 term frequency saturation. Env variable: BM25_K1",
+						Value: cfg.K1,
+					},
+					&cli.Float64Flag{
+						Name:  "b",
+						Usage: "BM25 length normalization. Env variable: BM25_B",
+						Value: cfg.B,
+					},
+					&cli.BoolFlag{
+						Name:  "fuzzy",
+						Usage: "search words with typos if there are no exact results. Env variable: FUZZY_FALLBACK",
+						Value: cfg.FuzzyFallback,
+					},
+					&cli.StringFlag{
+						Name:    "index",
+						Aliases: []string{"i"},
+						Usage:   "binary index file or dir of segments to search instead of store. Env variable: INDEX_FILE",
+						Value:   cfg.IndexFile,
+					},
+				},
 				Action: func(ctx *cli.Context) error {
 					phrase := ctx.Args().Get(0)
-					findInDb(phrase)
+					searcher, closeSearcher := openSearcher(ctx.String("index"))
+					defer closeSearcher()
+					find(searcher, phrase, revindex.BM25{K1: ctx.Float64("k1"), B: ctx.Float64("b")}, ctx.Bool("fuzzy"))
+					return nil
+				},
+			},
+			{
+				Name:      "convert",
+				Usage:     "Convert index saved in text format to binary format",
+				ArgsUsage: "<text index> <binary index>",
+				Action: func(ctx *cli.Context) error {
+					src, dst := ctx.Args().Get(0), ctx.Args().Get(1)
+					if src == "" || dst == "" {
+						console.Fatal("Specify source and destination files")
+					}
+					convert(src, dst)
 					return nil
 				},
 			},
+			{
+				Name:        "migrate",
+				Usage:       "Show and change version of schema of Postgres store",
+				Description: "Build command migrates schema to the latest version itself. Use down to return to schema of older version of tool",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "status",
+						Usage: "Show migrations and whether they are applied",
+						Action: func(ctx *cli.Context) error {
+							migrate(func(db *database.DB) {
+								status, err := db.MigrationStatus()
+								if err != nil {
+									console.Fatal("Cannot get migrations:", err)
+								}
+								for _, m := range status {
+									applied := "not applied"
+									if m.Applied {
+										applied = "applied at " + m.AppliedAt.Format(time.RFC3339)
+									}
+									console.Printf("%d\t%s\t%s\n", m.Version, m.Name, applied)
+								}
+							})
+							return nil
+						},
+					},
+					{
+						Name:  "up",
+						Usage: "Apply migrations up to the latest version",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "to",
+								Usage: "version to migrate to instead of the latest one",
+								Value: database.LatestVersion(),
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							migrate(func(db *database.DB) {
+								applied, err := db.MigrateUp(ctx.Int("to"))
+								if err != nil {
+									console.Fatal("Cannot migrate:", err)
+								}
+								for _, m := range applied {
+									console.Printf("Applied %d %s\n", m.Version, m.Name)
+								}
+							})
+							return nil
+						},
+					},
+					{
+						Name:  "down",
+						Usage: "Revert the last applied migration",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "to",
+								Usage: "version to migrate to, 0 reverts all migrations",
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							migrate(func(db *database.DB) {
+								version := ctx.Int("to")
+								if !ctx.IsSet("to") {
+									current, err := db.Version()
+									if err != nil {
+										console.Fatal("Cannot get version:", err)
+									}
+									if current == 0 {
+										console.Fatal("No migrations are applied")
+									}
+									version = current - 1
+								}
+								reverted, err := db.MigrateDown(version)
+								if err != nil {
+									console.Fatal("Cannot migrate:", err)
+								}
+								for _, m := range reverted {
+									console.Printf("Reverted %d %s\n", m.Version, m.Name)
+								}
+							})
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name:        "start",
 				Aliases:     []string{"s"},
-				Usage:       "Start server for searching phrases. Main page is on /",
-				Description: "Env variable for server addr: POLISGO_ADDR=ADDR. Default is localhost:8080",
+				Usage:       "Start server for searching phrases. Main page is on /, JSON API on /api/search/?phrase= and /autocomplete/?q=",
+				Description: "Env variable for server addr: POLISGO_ADDR=ADDR. Default is localhost:8080. BM25 parameters: BM25_K1, BM25_B. Search with typos if there are no exact results: FUZZY_FALLBACK=true. Binary index file or dir of segments to search instead of store: INDEX_FILE. Store: STORE=postgres (default), bolt with file DB_FILE (default index.db) or memory",
 				Action: func(ctx *cli.Context) error {
-					// connect to db
-					db, err := database.Connect(cfg.Hostname, cfg.Hostport, cfg.Username, cfg.Password, cfg.DatabaseName)
-					if err != nil {
-						logrus.Fatal("Error on connecting to database:", err)
-					}
-					defer func() {
-						err = db.Close()
-						if err != nil {
-							logrus.Fatal("Error on closing connection to database:", err)
-						}
-					}()
-					return server.Start(cfg.Addr, db)
+					searcher, closeSearcher := openSearcher(cfg.IndexFile)
+					defer closeSearcher()
+					return server.Start(cfg.Addr, searcher, revindex.BM25{K1: cfg.K1, B: cfg.B}, cfg.FuzzyFallback)
 				},
 			},
 		},
@@ -100,98 +381,335 @@ func main() {
 	}
 }
 
-// Get texts and titles from dir
-func getTextsAndTitlesFromDir(dirPath string) ([]string, []string, error) {
-	files, err := ioutil.ReadDir(dirPath)
+// Add stopwords from file to analyzer spec, it refers to returned stopwords by hash
+func withStopwordsFromFile(spec string, file string) (string, []string) {
+	f, err := os.Open(file)
+	if err != nil {
+		console.Fatal("Cannot open stopwords file: ", err)
+	}
+	defer f.Close()
+	words, err := revindex.ReadStopwords(f)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error while reading dir '%s': %w", dirPath, err)
-	}
-	texts := make([]string, 0, len(files))
-	titles := make([]string, 0, len(files))
-	var mux sync.Mutex
-	var wg sync.WaitGroup
-	wg.Add(len(files))
-	for _, fileInfo := range files {
-		go func(fileInfo os.FileInfo) {
-			filename := filepath.Join(dirPath, fileInfo.Name())
-			// read bytes from file
-			bytes, err := ioutil.ReadFile(filename)
-			if err != nil {
-				console.Printf("Error on reading '%s': %s\n", filename, err)
+		console.Fatal("Error: ", err)
+	}
+	spec, err = revindex.WithStopwords(spec, words)
+	if err != nil {
+		console.Fatal("Invalid stopwords: ", err)
+	}
+	return spec, words
+}
+
+// Build index from files in dir and save it to binary file, to dir of segments or to store.
+// Segments and store are updated only with new, changed and removed files
+func build(dir string, opts walkOptions, clearDb bool, analyzer revindex.Analyzer, workers int, output string, budget int, segments string) {
+	if output != "" {
+		buildBinary(dir, opts, analyzer, workers, output, budget)
+		return
+	}
+	if segments != "" {
+		buildSegments(dir, opts, analyzer, workers, budget, segments)
+		return
+	}
+	// save to store
+	store, closeStore := openStore()
+	defer closeStore()
+	if db, ok := store.(*database.DB); ok && !clearDb {
+		if err := db.Init(); err != nil {
+			console.Fatal("Error on init db:", err)
+		}
+	}
+	buildStore(dir, opts, analyzer, workers, budget, clearDb, store)
+}
+
+// Store removing all its texts. Postgres and bolt stores in batch drop them in transaction of batch
+type dropper interface {
+	DropAll() error
+}
+
+// Remove all texts of store. Schema of Postgres is created again after dropping its tables
+func clearStore(store revindex.Store) error {
+	db, ok := store.(dropper)
+	if !ok {
+		return nil
+	}
+	if err := db.DropAll(); err != nil {
+		return err
+	}
+	if db, ok := store.(*database.DB); ok {
+		return db.Init()
+	}
+	return nil
+}
+
+// Open store selected by config. Returned function closes it
+func openStore() (revindex.Store, func()) {
+	switch cfg.Store {
+	case storeMemory:
+		return revindex.NewMemoryStore(), func() {}
+	case storeBolt:
+		db, err := database.OpenBolt(cfg.DbFile)
+		if err != nil {
+			console.Fatal("Error on opening database:", err)
+		}
+		return db, func() {
+			if err := db.Close(); err != nil {
+				console.Fatal("Error on closing database:", err)
+			}
+		}
+	case storePostgres:
+		db, err := database.Connect(cfg.Hostname, cfg.Hostport, cfg.Username, cfg.Password, cfg.DatabaseName)
+		if err != nil {
+			console.Fatal("Error on connecting to database:", err)
+		}
+		return db, func() {
+			if err := db.Close(); err != nil {
+				console.Fatal("Error on closing connection to database:", err)
 			}
-			mux.Lock()
-			texts = append(texts, string(bytes))
-			titles = append(titles, fileInfo.Name())
-			mux.Unlock()
-			wg.Done()
-		}(fileInfo)
+		}
+	}
+	console.Fatalf("Unknown store '%s', use %s, %s or %s", cfg.Store, storePostgres, storeBolt, storeMemory)
+	return nil, nil
+}
+
+// Call function with Postgres store. Other stores have no migrations
+func migrate(fn func(db *database.DB)) {
+	store, closeStore := openStore()
+	defer closeStore()
+	db, ok := store.(*database.DB)
+	if !ok {
+		console.Fatalf("Migrations are only for %s store, current store is '%s'", storePostgres, cfg.Store)
 	}
-	wg.Wait()
-	return texts, titles, nil
+	fn(db)
 }
 
-// Build index from files in dir and save it to file "index.txt"
-func build(dir string, clearDb bool) {
-	// get texts and titles
-	texts, titles, err := getTextsAndTitlesFromDir(dir)
+// Build binary file by files in dir reading them one by one, so memory is limited by budget
+func buildBinary(dir string, opts walkOptions, analyzer revindex.Analyzer, workers int, output string, budget int) {
+	files, err := walkDir(dir, opts)
 	if err != nil {
 		console.Fatal("Error:", err)
 	}
-	// build index
-	index, err := revindex.Build(texts, titles)
+	builder, err := revindex.NewStreamBuilder(analyzer, budget, workers, "")
 	if err != nil {
-		console.Fatal("Error on building index:", err)
+		console.Fatal("Error on building index: ", err)
 	}
-	// save to db
-	db, err := database.Connect(cfg.Hostname, cfg.Hostport, cfg.Username, cfg.Password, cfg.DatabaseName)
+	for _, file := range files {
+		if err = builder.AddFile(file.title, file.path); err != nil {
+			_ = builder.Close()
+			console.Fatal("Error on building index: ", err)
+		}
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		console.Fatal("Cannot create file: ", err)
+	}
+	writer := bufio.NewWriter(f)
+	if err = builder.Finish(writer); err != nil {
+		console.Fatal("Error on building index: ", err)
+	}
+	if err = writer.Flush(); err != nil {
+		console.Fatal("Cannot write index: ", err)
+	}
+	if err = f.Close(); err != nil {
+		console.Fatal("Cannot close file: ", err)
+	}
+	console.Printf("Indexed %d files\n", len(files))
+}
+
+// Save index to binary file
+func saveBinary(index *revindex.Index, file string) {
+	f, err := os.Create(file)
+	if err != nil {
+		console.Fatal("Cannot create file: ", err)
+	}
+	writer := bufio.NewWriter(f)
+	if err = index.SaveBinary(writer); err != nil {
+		console.Fatal("Error on saving index: ", err)
+	}
+	if err = writer.Flush(); err != nil {
+		console.Fatal("Cannot write index: ", err)
+	}
+	if err = f.Close(); err != nil {
+		console.Fatal("Cannot close file: ", err)
+	}
+}
+
+// Remove texts with title from index file, dir of segments or store if file is not specified
+func remove(title string, indexFile string) {
+	removed := 0
+	changeIndex(indexFile, func(index *revindex.Index) {
+		removed = index.Delete(title)
+	}, func(segments *revindex.Segments) error {
+		var err error
+		removed, err = segments.Delete(title)
+		return err
+	}, func(store revindex.Store) error {
+		ok, err := store.DeleteTitle(title)
+		if ok {
+			removed = 1
+		}
+		return err
+	})
+	console.Printf("Removed %d texts\n", removed)
+}
+
+// Replace texts with title by content of file in index file, dir of segments or store if file is not specified
+func update(file string, title string, indexFile string) {
+	content, err := ioutil.ReadFile(file)
 	if err != nil {
-		console.Fatal("Error on connecting to database:", err)
+		console.Fatal("Cannot read file: ", err)
+	}
+	text := string(content)
+	changeIndex(indexFile, func(index *revindex.Index) {
+		index.Update(title, text)
+	}, func(segments *revindex.Segments) error {
+		return segments.Update(title, text)
+	}, func(store revindex.Store) error {
+		return revindex.UpdateInStore(store, title, text)
+	})
+}
+
+// Change index file in text or binary format, dir of segments or store if file is not specified
+func changeIndex(indexFile string, changeFile func(*revindex.Index), changeSegments func(*revindex.Segments) error,
+	changeStore func(revindex.Store) error) {
+	if info, err := os.Stat(indexFile); err == nil && info.IsDir() {
+		segments, err := revindex.OpenSegments(indexFile)
+		if err != nil {
+			console.Fatal("Error on opening segments: ", err)
+		}
+		if err = changeSegments(segments); err != nil {
+			console.Fatal("Cannot change segments: ", err)
+		}
+		if err = segments.Close(); err != nil {
+			console.Fatal("Cannot merge segments: ", err)
+		}
+		return
 	}
-	defer func() {
-		err = db.Close()
+	if indexFile != "" {
+		data, err := ioutil.ReadFile(indexFile)
+		if err != nil {
+			console.Fatal("Cannot read index: ", err)
+		}
+		var index revindex.Index
+		binary := revindex.IsBinary(data)
+		if binary {
+			index, err = revindex.ReadBinary(bytes.NewReader(data))
+		} else {
+			index, err = revindex.Read(bytes.NewReader(data))
+		}
 		if err != nil {
-			console.Fatal("Error on closing connection to database:", err)
+			console.Fatal("Invalid index: ", err)
 		}
-	}()
-	// clear db if we need
-	if clearDb {
-		console.Println("Clearing database")
-		err = db.DropAll()
+		changeFile(&index)
+		if binary {
+			saveBinary(&index, indexFile)
+			return
+		}
+		f, err := os.Create(indexFile)
 		if err != nil {
-			console.Fatal("Error on clearing db:", err)
+			console.Fatal("Cannot create file: ", err)
+		}
+		if err = index.Save(f); err != nil {
+			console.Fatal("Error on saving index: ", err)
+		}
+		if err = f.Close(); err != nil {
+			console.Fatal("Cannot close file: ", err)
 		}
+		return
+	}
+	store, closeStore := openStore()
+	defer closeStore()
+	if err := changeStore(store); err != nil {
+		console.Fatal("Cannot change store: ", err)
 	}
-	err = db.Init()
+}
+
+// Convert index in text format from file src to binary format in file dst
+func convert(src string, dst string) {
+	in, err := os.Open(src)
 	if err != nil {
-		console.Fatal("Error on init db:", err)
+		console.Fatal("Cannot open index: ", err)
 	}
-	err = index.SaveToDb(db)
+	defer in.Close()
+	out, err := os.Create(dst)
 	if err != nil {
-		console.Fatal("Error on saving index to db:", err)
+		console.Fatal("Cannot create file: ", err)
+	}
+	writer := bufio.NewWriter(out)
+	if err = revindex.ConvertToBinary(bufio.NewReader(in), writer); err != nil {
+		console.Fatal("Cannot convert index: ", err)
+	}
+	if err = writer.Flush(); err != nil {
+		console.Fatal("Cannot write index: ", err)
+	}
+	if err = out.Close(); err != nil {
+		console.Fatal("Cannot close file: ", err)
 	}
 }
 
-func findInDb(phrase string) {
-	db, err := database.Connect(cfg.Hostname, cfg.Hostport, cfg.Username, cfg.Password, cfg.DatabaseName)
-	if err != nil {
-		console.Fatal("Error on connecting to database:", err)
+// Open searcher of binary index file, of dir of segments or of store if file is not specified.
+// Returned function closes it
+func openSearcher(indexFile string) (*revindex.Searcher, func()) {
+	if info, err := os.Stat(indexFile); err == nil && info.IsDir() {
+		segments, err := revindex.OpenSegments(indexFile)
+		if err != nil {
+			console.Fatal("Error on opening segments:", err)
+		}
+		return segments.Searcher(), func() {
+			if err := segments.Close(); err != nil {
+				console.Fatal("Error on closing segments:", err)
+			}
+		}
 	}
-	defer func() {
-		err = db.Close()
+	if indexFile != "" {
+		index, err := revindex.OpenMapped(indexFile)
 		if err != nil {
-			console.Fatal("Error on closing connection to database:", err)
+			console.Fatal("Error on opening index:", err)
 		}
-	}()
-	res, err := revindex.FindInDb(phrase, db)
+		return index.Searcher(), func() {
+			if err := index.Close(); err != nil {
+				console.Fatal("Error on closing index:", err)
+			}
+		}
+	}
+	store, closeStore := openStore()
+	return revindex.NewStoreSearcher(store), closeStore
+}
+
+func find(searcher *revindex.Searcher, phrase string, scorer revindex.BM25, fuzzyFallback bool) {
+	res, err := searcher.Find(phrase, scorer)
+	var parseErr *revindex.ParseError
+	if errors.As(err, &parseErr) {
+		console.Fatal("Invalid query: ", parseErr.Msg, " at position ", parseErr.Pos)
+	}
 	if err != nil {
-		console.Fatal("Cannot find phrase in db:", err)
+		console.Fatal("Cannot find phrase:", err)
+	}
+	if len(res) == 0 {
+		suggester, err := searcher.Suggester()
+		if err != nil {
+			console.Fatal("Cannot get suggestions:", err)
+		}
+		if suggestion, ok := suggester.Suggest(phrase); ok {
+			console.Println("Did you mean:", suggestion)
+		}
+	}
+	if len(res) == 0 && fuzzyFallback {
+		if res, err = searcher.FindFuzzy(phrase, scorer); err != nil {
+			console.Fatal("Cannot find phrase:", err)
+		}
+		if len(res) > 0 {
+			console.Println("No exact entries, showing entries of similar words")
+		}
 	}
 	if len(res) == 0 {
 		console.Println("No entries")
 		return
 	}
 	console.Println("Entries:")
-	for title, amount := range res {
-		console.Printf("%s; entries: %d\n", title, amount)
+	for _, r := range res {
+		console.Printf("%s; score: %.3f\n", r.Title, r.Score)
+		if len(r.Snippet) > 0 {
+			console.Println("\t" + r.Snippet.Highlight("[", "]"))
+		}
 	}
 }
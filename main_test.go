@@ -2,14 +2,172 @@ package main
 
 import (
 	"fmt"
+	"github.com/polisgo2020/search-K1ta/revindex"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 )
 
-func BenchmarkGetTextsAndTitlesFromDir(b *testing.B) {
+func TestGetChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polisgo")
+	if err != nil {
+		t.Fatal("Cannot create dir:", err)
+	}
+	defer os.RemoveAll(dir)
+	mtime := time.Unix(1000, 0)
+	for name, text := range map[string]string{"same": "text", "touched": "text", "changed": "new text", "added": "text"} {
+		path := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal("Cannot write file:", err)
+		}
+		if err = os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal("Cannot change time of file:", err)
+		}
+	}
+	// sha256 of "text"
+	hash := "982d9e3eb996f559e633f4d194def3761d909f5a3b647d1a851fead67c32c9d1"
+	saved := map[string]revindex.Document{
+		"same":    {Hash: "different hash, it is not checked", ModTime: mtime.UnixNano()},
+		"touched": {Hash: hash, ModTime: 1},
+		"changed": {Hash: hash, ModTime: 1},
+		"removed": {Hash: hash, ModTime: 1},
+	}
+	c, err := getChanges(dir, walkOptions{}, saved)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	t.Log("act=", c.summary(), c.files, c.changed, c.removed)
+	if c.added != 1 || c.unchanged != 2 || !reflect.DeepEqual(c.changed, []string{"changed"}) ||
+		!reflect.DeepEqual(c.removed, []string{"removed"}) || len(c.files) != 2 {
+		t.Fatal("Wrong changes")
+	}
+	if c.docs["touched"] != (revindex.Document{Hash: hash, ModTime: mtime.UnixNano()}) {
+		t.Fatal("Wrong source of touched file:", c.docs["touched"])
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polisgo")
+	if err != nil {
+		t.Fatal("Cannot create dir:", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.txt", "sub/a.txt", "sub/deep/b.md", ".hidden/c.txt", ".h.txt", "drafts/d.txt"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal("Cannot create dir:", err)
+		}
+		if err = ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal("Cannot write file:", err)
+		}
+	}
+	for link, target := range map[string]string{"link": "sub", "flink.txt": "a.txt", "sub/loop": ".."} {
+		if err = os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skip("Cannot create symlink:", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts walkOptions
+		exp  []string
+	}{
+		{name: "default", opts: walkOptions{}, exp: []string{"a.txt", "drafts/d.txt", "flink.txt", "sub/a.txt", "sub/deep/b.md"}},
+		{name: "hidden", opts: walkOptions{hidden: true, symlinks: symlinksSkip},
+			exp: []string{".h.txt", ".hidden/c.txt", "a.txt", "drafts/d.txt", "sub/a.txt", "sub/deep/b.md"}},
+		{name: "follow links", opts: walkOptions{symlinks: symlinksFollow, exclude: []string{"drafts"}},
+			exp: []string{"a.txt", "flink.txt", "link/a.txt", "link/deep/b.md"}},
+		{name: "include", opts: walkOptions{include: []string{"*.md", "sub/*.txt"}}, exp: []string{"sub/a.txt", "sub/deep/b.md"}},
+		{name: "exclude with **", opts: walkOptions{exclude: []string{"sub/**", "flink.txt"}}, exp: []string{"a.txt", "drafts/d.txt"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := walkDir(dir, test.opts)
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+			act := make([]string, len(files))
+			for i, file := range files {
+				act[i] = file.title
+			}
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		if _, err := walkDir(dir, walkOptions{include: []string{"["}}); err == nil {
+			t.Fatal("Walk must return an error")
+		}
+	})
+}
+
+func TestFileTitle(t *testing.T) {
+	tests := []struct {
+		root string
+		file string
+		exp  string
+	}{
+		{root: "texts", file: "texts/a.txt", exp: "a.txt"},
+		{root: "texts/", file: "texts/sub/b.txt", exp: "sub/b.txt"},
+		{root: ".", file: "texts/sub/b.txt", exp: "texts/sub/b.txt"},
+	}
+	for _, test := range tests {
+		t.Run(test.root+" "+test.file, func(t *testing.T) {
+			act, err := fileTitle(test.root, test.file)
+			if err != nil {
+				t.Fatal("Cannot get title:", err)
+			}
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if act != test.exp {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("file out of dir", func(t *testing.T) {
+		for _, file := range []string{"other/a.txt", "texts"} {
+			if _, err := fileTitle("texts", file); err == nil {
+				t.Fatal("Title must return an error for", file)
+			}
+		}
+	})
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		exp     bool
+	}{
+		{pattern: "*.txt", path: "a/b/c.txt", exp: true},
+		{pattern: "a/*.txt", path: "a/b/c.txt", exp: false},
+		{pattern: "a/**/*.txt", path: "a/b/c.txt", exp: true},
+		{pattern: "a/**/*.txt", path: "a/c.txt", exp: true},
+		{pattern: "**/c.txt", path: "c.txt", exp: true},
+		{pattern: "a/**", path: "a", exp: true},
+		{pattern: "b/**", path: "a/b/c.txt", exp: false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			if act := matchGlob(test.pattern, test.path); act != test.exp {
+				t.Log("exp=", test.exp)
+				t.Log("act=", act)
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
+
+func BenchmarkGetChanges(b *testing.B) {
 	// functions for creating and deleting directory
 	createDir := func(path string) {
 		if err := os.Mkdir(path, os.ModePerm); err != nil {
@@ -62,7 +220,7 @@ func BenchmarkGetTextsAndTitlesFromDir(b *testing.B) {
 	// start benchmarks
 	b.Run("read a lot of small files", func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			if _, _, err := getTextsAndTitlesFromDir(smallDirName); err != nil {
+			if _, err := getChanges(smallDirName, walkOptions{}, nil); err != nil {
 				b.Fatal("Error:", err)
 			}
 		}
@@ -70,7 +228,7 @@ func BenchmarkGetTextsAndTitlesFromDir(b *testing.B) {
 
 	b.Run("read a few of large files", func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			if _, _, err := getTextsAndTitlesFromDir(largeDirName); err != nil {
+			if _, err := getChanges(largeDirName, walkOptions{}, nil); err != nil {
 				b.Fatal("Error:", err)
 			}
 		}
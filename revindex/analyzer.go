@@ -0,0 +1,353 @@
+package revindex
+
+import (
+	"fmt"
+	"github.com/polisgo2020/search-K1ta/revindex/stemmer"
+	"golang.org/x/text/unicode/norm"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultAnalyzerSpec splits text by spaces, trims punctuation and lowercases words
+const DefaultAnalyzerSpec = "words|lowercase"
+
+// Token is a term of text with its position
+type Token struct {
+	Term     string
+	Position int
+	// byte offsets of word in text, word is text[Start:End]
+	Start int
+	End   int
+}
+
+// Tokenizer splits text into tokens
+type Tokenizer interface {
+	Tokenize(text string) []Token
+}
+
+// TokenFilter changes or removes tokens. Positions of removed tokens stay unused,
+// so phrases match only words that were next to each other in text
+type TokenFilter interface {
+	Filter(tokens []Token) []Token
+}
+
+// Normalizer is a filter that changes characters of terms only, e.g. case. Such filters can be applied to
+// parts of words, like patterns of wildcard queries
+type Normalizer interface {
+	Normalize(term string) string
+}
+
+// Analyzer turns text into terms for indexing and searching
+type Analyzer interface {
+	Analyze(text string) []Token
+	// Normalize applies to term only character changes of analysis, without stemming or removing it
+	Normalize(term string) string
+	// Spec describes analyzer, so it can be created again with ParseAnalyzer
+	Spec() string
+}
+
+// Pipeline is an analyzer made of tokenizer and chain of filters
+type Pipeline struct {
+	spec      string
+	Tokenizer Tokenizer
+	Filters   []TokenFilter
+	// stopwords referred by hash in spec
+	stopwords []string
+}
+
+func (p *Pipeline) Analyze(text string) []Token {
+	tokens := p.Tokenizer.Tokenize(text)
+	for _, filter := range p.Filters {
+		tokens = filter.Filter(tokens)
+	}
+	// filters may leave empty terms
+	res := tokens[:0]
+	for _, token := range tokens {
+		if token.Term != "" {
+			res = append(res, token)
+		}
+	}
+	return res
+}
+
+func (p *Pipeline) Normalize(term string) string {
+	for _, filter := range p.Filters {
+		if normalizer, ok := filter.(Normalizer); ok {
+			term = normalizer.Normalize(term)
+		}
+	}
+	return term
+}
+
+func (p *Pipeline) Spec() string {
+	return p.spec
+}
+
+// Stopwords returns stopwords of 'stopwords' filter. Spec has only their hash, so they are saved apart from it
+func (p *Pipeline) Stopwords() []string {
+	return p.stopwords
+}
+
+// tokenizers and filters by names used in analyzer spec. Argument is a part of spec after '='
+var (
+	tokenizers = map[string]func(arg string) (Tokenizer, error){
+		"words": func(string) (Tokenizer, error) {
+			return WordsTokenizer{}, nil
+		},
+		"letters": func(string) (Tokenizer, error) {
+			return LettersTokenizer{}, nil
+		},
+	}
+	filters = map[string]func(arg string) (TokenFilter, error){
+		"lowercase": func(string) (TokenFilter, error) {
+			return LowercaseFilter{}, nil
+		},
+		"nfkc": func(string) (TokenFilter, error) {
+			return NFKCFilter{}, nil
+		},
+		"length": parseLengthFilter,
+		"stem":   parseStemFilter,
+		"stop":   parseStopFilter,
+	}
+)
+
+// ParseAnalyzer creates analyzer by spec: tokenizer and filters separated by '|'.
+// Filter may have an argument after '=', e.g. "words|nfkc|lowercase|length=2,64".
+// Tokenizers: words, letters. Filters: lowercase, nfkc, length=<min>,<max>, stem=<en|ru>,
+// stop=<comma separated languages>, stopwords=<hash of stopwords>. Analyzer with stopwords is created
+// by ParseAnalyzerWithStopwords
+func ParseAnalyzer(spec string) (Analyzer, error) {
+	return ParseAnalyzerWithStopwords(spec, nil)
+}
+
+// ParseAnalyzerWithStopwords creates analyzer by spec like ParseAnalyzer, filter 'stopwords' of spec
+// removes stopwords. Their hash must be equal to the one in spec
+func ParseAnalyzerWithStopwords(spec string, stopwords []string) (Analyzer, error) {
+	parts := strings.Split(spec, "|")
+	name, arg := splitSpecPart(parts[0])
+	newTokenizer, ok := tokenizers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tokenizer '%s'", name)
+	}
+	tokenizer, err := newTokenizer(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tokenizer '%s': %w", parts[0], err)
+	}
+	pipeline := Pipeline{spec: spec, Tokenizer: tokenizer}
+	for _, part := range parts[1:] {
+		name, arg := splitSpecPart(part)
+		if name == "stopwords" {
+			if len(stopwords) == 0 || arg != stopwordsHash(stopwords) {
+				return nil, fmt.Errorf("no stopwords with hash '%s'", arg)
+			}
+			pipeline.stopwords = uniqueStopwords(stopwords)
+			pipeline.Filters = append(pipeline.Filters, newStopwordsFilter(pipeline.stopwords))
+			continue
+		}
+		newFilter, ok := filters[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter '%s'", name)
+		}
+		filter, err := newFilter(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter '%s': %w", part, err)
+		}
+		pipeline.Filters = append(pipeline.Filters, filter)
+	}
+	return &pipeline, nil
+}
+
+// LanguageAnalyzerSpec returns spec of analyzer for texts in given languages: en, ru.
+// Analyzer removes stopwords and stems words of languages
+func LanguageAnalyzerSpec(languages ...string) (string, error) {
+	names := make([]string, 0, len(languages))
+	stems := ""
+	for _, language := range languages {
+		language = strings.TrimSpace(language)
+		if _, ok := languageStemmers[language]; !ok {
+			return "", fmt.Errorf("unknown language '%s'", language)
+		}
+		names = append(names, language)
+		stems += "|stem=" + language
+	}
+	return "words|nfkc|lowercase|stop=" + strings.Join(names, ",") + stems, nil
+}
+
+// DefaultAnalyzer returns analyzer with DefaultAnalyzerSpec
+func DefaultAnalyzer() Analyzer {
+	analyzer, err := ParseAnalyzer(DefaultAnalyzerSpec)
+	if err != nil {
+		panic(err)
+	}
+	return analyzer
+}
+
+func splitSpecPart(part string) (string, string) {
+	part = strings.TrimSpace(part)
+	if i := strings.Index(part, "="); i != -1 {
+		return part[:i], part[i+1:]
+	}
+	return part, ""
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsDigit(r) || unicode.IsLetter(r)
+}
+
+func isNotWordRune(r rune) bool {
+	return !isWordRune(r)
+}
+
+// fieldsFunc returns byte offsets of parts of text separated by runes satisfying isSeparator
+func fieldsFunc(text string, isSeparator func(rune) bool) [][2]int {
+	res := make([][2]int, 0)
+	start := -1
+	for i, r := range text {
+		switch {
+		case isSeparator(r) && start != -1:
+			res = append(res, [2]int{start, i})
+			start = -1
+		case !isSeparator(r) && start == -1:
+			start = i
+		}
+	}
+	if start != -1 {
+		res = append(res, [2]int{start, len(text)})
+	}
+	return res
+}
+
+// WordsTokenizer splits text by spaces and trims punctuation around words
+type WordsTokenizer struct{}
+
+func (WordsTokenizer) Tokenize(text string) []Token {
+	tokens := make([]Token, 0)
+	for _, field := range fieldsFunc(text, unicode.IsSpace) {
+		word := text[field[0]:field[1]]
+		trimmed := strings.TrimLeftFunc(word, isNotWordRune)
+		start := field[0] + len(word) - len(trimmed)
+		word = strings.TrimRightFunc(trimmed, isNotWordRune)
+		// punctuation between words does not take position
+		if word != "" {
+			tokens = append(tokens, Token{Term: word, Position: len(tokens), Start: start, End: start + len(word)})
+		}
+	}
+	return tokens
+}
+
+// LettersTokenizer splits text by any character except letters and digits
+type LettersTokenizer struct{}
+
+func (LettersTokenizer) Tokenize(text string) []Token {
+	tokens := make([]Token, 0)
+	for _, field := range fieldsFunc(text, isNotWordRune) {
+		tokens = append(tokens, Token{Term: text[field[0]:field[1]], Position: len(tokens), Start: field[0], End: field[1]})
+	}
+	return tokens
+}
+
+// LowercaseFilter maps terms to lower case
+type LowercaseFilter struct{}
+
+func (f LowercaseFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = f.Normalize(tokens[i].Term)
+	}
+	return tokens
+}
+
+func (LowercaseFilter) Normalize(term string) string {
+	return strings.ToLower(term)
+}
+
+// NFKCFilter applies Unicode NFKC normalization to terms, e.g. 'ﬁ' becomes 'fi'
+type NFKCFilter struct{}
+
+func (f NFKCFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = f.Normalize(tokens[i].Term)
+	}
+	return tokens
+}
+
+func (NFKCFilter) Normalize(term string) string {
+	return norm.NFKC.String(term)
+}
+
+// LengthFilter removes terms shorter than Min or longer than Max runes. Zero Max means no limit
+type LengthFilter struct {
+	Min int
+	Max int
+}
+
+func parseLengthFilter(arg string) (TokenFilter, error) {
+	var filter LengthFilter
+	bounds := strings.Split(arg, ",")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("expected '<min>,<max>'")
+	}
+	var err error
+	if filter.Min, err = strconv.Atoi(bounds[0]); err != nil {
+		return nil, fmt.Errorf("invalid min length: %w", err)
+	}
+	if filter.Max, err = strconv.Atoi(bounds[1]); err != nil {
+		return nil, fmt.Errorf("invalid max length: %w", err)
+	}
+	return filter, nil
+}
+
+func (f LengthFilter) Filter(tokens []Token) []Token {
+	res := tokens[:0]
+	for _, token := range tokens {
+		length := utf8.RuneCountInString(token.Term)
+		if length >= f.Min && (f.Max == 0 || length <= f.Max) {
+			res = append(res, token)
+		}
+	}
+	return res
+}
+
+// stemmers of languages and alphabets of words they can stem
+var languageStemmers = map[string]struct {
+	stem     func(string) string
+	alphabet *unicode.RangeTable
+}{
+	"en": {stem: stemmer.English, alphabet: unicode.Latin},
+	"ru": {stem: stemmer.Russian, alphabet: unicode.Cyrillic},
+}
+
+// StemFilter reduces words of language to their stems, e.g. 'книги' becomes 'книг'.
+// Words written in other alphabet are not changed, so filters of several languages may be chained.
+// Terms must be in lower case
+type StemFilter struct {
+	Language string
+}
+
+func parseStemFilter(arg string) (TokenFilter, error) {
+	if _, ok := languageStemmers[arg]; !ok {
+		return nil, fmt.Errorf("unknown language '%s'", arg)
+	}
+	return StemFilter{Language: arg}, nil
+}
+
+func (f StemFilter) Filter(tokens []Token) []Token {
+	s := languageStemmers[f.Language]
+	for i := range tokens {
+		if inAlphabet(tokens[i].Term, s.alphabet) {
+			tokens[i].Term = s.stem(tokens[i].Term)
+		}
+	}
+	return tokens
+}
+
+// inAlphabet checks that all letters of word are from alphabet
+func inAlphabet(word string, alphabet *unicode.RangeTable) bool {
+	for _, r := range word {
+		if unicode.IsLetter(r) && !unicode.Is(alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package revindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+// terms returns terms of tokens
+func terms(tokens []Token) []string {
+	res := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		res = append(res, token.Term)
+	}
+	return res
+}
+
+func TestDefaultAnalyzer(t *testing.T) {
+	t.Run("punctuation and case", func(t *testing.T) {
+		tokens := DefaultAnalyzer().Analyze("1GgФф.,:!?\"'[]{}()`-_+=*/#$")
+		t.Log("tokens:", tokens)
+		if !reflect.DeepEqual(terms(tokens), []string{"1ggфф"}) {
+			t.Fatal("Term is invalid")
+		}
+	})
+
+	t.Run("offsets of words without punctuation", func(t *testing.T) {
+		act := DefaultAnalyzer().Analyze(" («Ёлка», 2)")
+		exp := []Token{{Term: "ёлка", Position: 0, Start: 4, End: 12}, {Term: "2", Position: 1, Start: 16, End: 17}}
+		t.Log("exp:", exp)
+		t.Log("act:", act)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("punctuation does not take position", func(t *testing.T) {
+		act := DefaultAnalyzer().Analyze("a -- b")
+		exp := []Token{{Term: "a", Position: 0, Start: 0, End: 1}, {Term: "b", Position: 1, Start: 5, End: 6}}
+		t.Log("exp:", exp)
+		t.Log("act:", act)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+}
+
+func TestParseAnalyzer(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		text string
+		exp  []string
+	}{
+		{name: "letters tokenizer", spec: "letters", text: "e-mail, don't", exp: []string{"e", "mail", "don", "t"}},
+		{name: "nfkc", spec: "words|nfkc", text: "ﬁle ２", exp: []string{"file", "2"}},
+		{name: "length", spec: "words|length=2,3", text: "a bb ccc dddd", exp: []string{"bb", "ccc"}},
+		{name: "length without max", spec: "words|length=2,0", text: "a bb ccc dddd", exp: []string{"bb", "ccc", "dddd"}},
+		{name: "filters order", spec: "words|nfkc|lowercase", text: "ＡＢＣ", exp: []string{"abc"}},
+		{name: "russian stemmer", spec: "words|lowercase|stem=ru", text: "Книги running", exp: []string{"книг", "running"}},
+		{name: "english stemmer", spec: "words|lowercase|stem=en", text: "Книги running", exp: []string{"книги", "run"}},
+		{name: "two stemmers", spec: "words|lowercase|stem=ru|stem=en", text: "Книги running", exp: []string{"книг", "run"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analyzer, err := ParseAnalyzer(test.spec)
+			if err != nil {
+				t.Fatal("Cannot parse analyzer:", err)
+			}
+			if analyzer.Spec() != test.spec {
+				t.Fatal("Wrong spec of analyzer:", analyzer.Spec())
+			}
+			act := terms(analyzer.Analyze(test.text))
+			t.Log("exp:", test.exp)
+			t.Log("act:", act)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("removed tokens keep positions", func(t *testing.T) {
+		analyzer, err := ParseAnalyzer("words|length=2,0")
+		if err != nil {
+			t.Fatal("Cannot parse analyzer:", err)
+		}
+		act := analyzer.Analyze("bank of a city")
+		exp := []Token{
+			{Term: "bank", Position: 0, Start: 0, End: 4},
+			{Term: "of", Position: 1, Start: 5, End: 7},
+			{Term: "city", Position: 3, Start: 10, End: 14},
+		}
+		t.Log("exp:", exp)
+		t.Log("act:", act)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	for _, spec := range []string{"", "unknown", "words|unknown", "words|length=1", "words|length=a,2", "words|stem=de"} {
+		t.Run("invalid spec "+spec, func(t *testing.T) {
+			_, err := ParseAnalyzer(spec)
+			t.Log("err:", err)
+			if err == nil {
+				t.Fatal("ParseAnalyzer must return an error")
+			}
+		})
+	}
+}
+
+func TestIndex_Find_Analyzer(t *testing.T) {
+	analyzer, err := ParseAnalyzer("words|lowercase|length=2,0")
+	if err != nil {
+		t.Fatal("Cannot parse analyzer:", err)
+	}
+	index, err := BuildWithAnalyzer([]string{"bank of a city", "city bank"}, []string{"1", "2"}, analyzer)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	// removed word of query keeps its position in phrase
+	act, err := index.Find("\"OF X CITY\"", DefaultBM25)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	t.Log("act:", act)
+	if !reflect.DeepEqual(resultTitles(act), []string{"1"}) {
+		t.Fatal("Wrong result")
+	}
+}
+
+func TestLanguageAnalyzerSpec(t *testing.T) {
+	spec, err := LanguageAnalyzerSpec("ru", "en")
+	if err != nil {
+		t.Fatal("Cannot get spec:", err)
+	}
+	analyzer, err := ParseAnalyzer(spec)
+	if err != nil {
+		t.Fatal("Cannot parse analyzer:", err)
+	}
+	index, err := BuildWithAnalyzer([]string{"Книга о поиске", "Searching books"}, []string{"ru", "en"}, analyzer)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	act, err := index.Find("книги поиск book", DefaultBM25)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	t.Log("act:", act)
+	if !reflect.DeepEqual(resultTitles(act), []string{"ru", "en"}) {
+		t.Fatal("Wrong result")
+	}
+
+	if _, err = LanguageAnalyzerSpec("de"); err == nil {
+		t.Fatal("Unknown language must return an error")
+	}
+}
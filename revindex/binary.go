@@ -0,0 +1,451 @@
+package revindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// Binary format of index. All numbers are little endian.
+//
+//	header      magic "RVIX", version uint32, offset and length uint64 of each section
+//	meta        analyzer spec: uvarint length and bytes
+//	stopwords   table of sorted stopwords referred by hash in analyzer spec
+//	docs        table of titles, extra values are lengths of texts in words and their total length after them
+//	texts       table of contents of texts, empty if texts are not saved
+//	terms       table of sorted words
+//	postings    postings of words in order of terms
+//	footer      CRC32 (IEEE) of all previous bytes, uint32
+//
+// Table is uint32 number of entries n, n+1 uint64 offsets of entries in data and data itself,
+// so i-th entry is data[offsets[i]:offsets[i+1]]. Docs and terms tables have also n+1 uint64 extra values
+// after offsets of entries, for terms they are offsets of postings of words. Postings of word are uvarint number
+// of texts, then for each text uvarint delta of text index, uvarint number of positions and uvarint deltas of positions.
+
+// BinaryVersion is a version of binary format written by SaveBinary
+const BinaryVersion = 1
+
+var binaryMagic = [4]byte{'R', 'V', 'I', 'X'}
+
+// sections of binary index in order of writing
+const (
+	sectionMeta = iota
+	sectionStopwords
+	sectionDocs
+	sectionTexts
+	sectionTerms
+	sectionPostings
+	sectionCount
+)
+
+// size of header: magic, version and offset and length of each section
+const binaryHeaderSize = 4 + 4 + sectionCount*16
+
+// ErrChecksum is returned when binary index is corrupted
+var ErrChecksum = errors.New("checksum mismatch")
+
+// SaveBinary writes index in binary format
+func (index *Index) SaveBinary(writer io.Writer) error {
+	index = index.compacted()
+	sections := make([][]byte, sectionCount)
+	sections[sectionMeta] = appendString(nil, index.analyzer().Spec())
+	sections[sectionStopwords] = encodeTable(analyzerStopwords(index.analyzer()), nil)
+	// docs with lengths of texts and their total length
+	lengths := make([]uint64, 0, len(index.Titles)+1)
+	total := uint64(0)
+	for i := range index.Titles {
+		length := 0
+		if i < len(index.Lengths) {
+			length = index.Lengths[i]
+		}
+		lengths = append(lengths, uint64(length))
+		total += uint64(length)
+	}
+	sections[sectionDocs] = encodeTable(index.Titles, append(lengths, total))
+	sections[sectionTexts] = encodeTable(index.Texts, nil)
+	// postings of words in order of terms
+	terms := index.sortedTerms()
+	postings := make([]byte, 0)
+	postingsOffsets := make([]uint64, 0, len(terms)+1)
+	for _, word := range terms {
+		postingsOffsets = append(postingsOffsets, uint64(len(postings)))
+		postings = appendPostings(postings, index.Data[word])
+	}
+	postingsOffsets = append(postingsOffsets, uint64(len(postings)))
+	sections[sectionTerms] = encodeTable(terms, postingsOffsets)
+	sections[sectionPostings] = postings
+
+	// header with offsets of sections
+	header := make([]byte, binaryHeaderSize)
+	copy(header, binaryMagic[:])
+	binary.LittleEndian.PutUint32(header[4:], BinaryVersion)
+	offset := uint64(binaryHeaderSize)
+	for i, section := range sections {
+		binary.LittleEndian.PutUint64(header[8+i*16:], offset)
+		binary.LittleEndian.PutUint64(header[16+i*16:], uint64(len(section)))
+		offset += uint64(len(section))
+	}
+
+	checksum := crc32.NewIEEE()
+	w := io.MultiWriter(writer, checksum)
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("cannot write header: %w", err)
+	}
+	for _, section := range sections {
+		if _, err := w.Write(section); err != nil {
+			return fmt.Errorf("cannot write index: %w", err)
+		}
+	}
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, checksum.Sum32())
+	if _, err := writer.Write(footer); err != nil {
+		return fmt.Errorf("cannot write checksum: %w", err)
+	}
+	return nil
+}
+
+// ReadBinary reads index written by SaveBinary
+func ReadBinary(reader io.Reader) (Index, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return Index{}, fmt.Errorf("cannot read index: %w", err)
+	}
+	sections, err := binarySections(data)
+	if err != nil {
+		return Index{}, err
+	}
+	if err = verifyChecksum(data); err != nil {
+		return Index{}, err
+	}
+	index := Index{Data: make(map[string]Postings)}
+	// meta
+	if index.Analyzer, err = decodeAnalyzer(sections); err != nil {
+		return Index{}, err
+	}
+	// docs
+	if index.Titles, index.Lengths, err = decodeDocs(sections[sectionDocs]); err != nil {
+		return Index{}, fmt.Errorf("invalid docs: %w", err)
+	}
+	// texts
+	texts, err := newBinaryTable(sections[sectionTexts], false)
+	if err != nil {
+		return Index{}, fmt.Errorf("invalid texts: %w", err)
+	}
+	for i := 0; i < texts.len(); i++ {
+		index.Texts = append(index.Texts, string(texts.entry(i)))
+	}
+	// terms and postings
+	terms, err := newBinaryTable(sections[sectionTerms], true)
+	if err != nil {
+		return Index{}, fmt.Errorf("invalid terms: %w", err)
+	}
+	for i := 0; i < terms.len(); i++ {
+		start, end := terms.postingsRange(i)
+		if start > end || end > uint64(len(sections[sectionPostings])) {
+			return Index{}, fmt.Errorf("invalid postings of term %d", i)
+		}
+		postings, err := decodePostings(sections[sectionPostings][start:end])
+		if err != nil {
+			return Index{}, fmt.Errorf("invalid postings of term %d: %w", i, err)
+		}
+		word := string(terms.entry(i))
+		index.Data[word] = postings
+		// terms table is sorted
+		index.terms = append(index.terms, word)
+	}
+	return index, nil
+}
+
+// ConvertToBinary reads index saved by Save and writes it in binary format
+func ConvertToBinary(reader io.Reader, writer io.Writer) error {
+	index, err := Read(reader)
+	if err != nil {
+		return err
+	}
+	return index.SaveBinary(writer)
+}
+
+// IsBinary checks that data starts with header of binary index
+func IsBinary(data []byte) bool {
+	return len(data) >= len(binaryMagic) && bytes.Equal(data[:len(binaryMagic)], binaryMagic[:])
+}
+
+// binarySections checks header of binary index and returns its sections
+func binarySections(data []byte) ([][]byte, error) {
+	if len(data) < binaryHeaderSize+4 || !IsBinary(data) {
+		return nil, fmt.Errorf("invalid format of index")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != BinaryVersion {
+		return nil, fmt.Errorf("unsupported version %d of index", version)
+	}
+	body := data[:len(data)-4]
+	sections := make([][]byte, sectionCount)
+	for i := range sections {
+		offset := binary.LittleEndian.Uint64(data[8+i*16:])
+		length := binary.LittleEndian.Uint64(data[16+i*16:])
+		if offset > uint64(len(body)) || length > uint64(len(body))-offset {
+			return nil, fmt.Errorf("invalid section %d of index", i)
+		}
+		sections[i] = body[offset : offset+length]
+	}
+	return sections, nil
+}
+
+// verifyChecksum checks footer of binary index with valid header
+func verifyChecksum(data []byte) error {
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// decodeAnalyzer creates analyzer by spec in meta and stopwords sections
+func decodeAnalyzer(sections [][]byte) (Analyzer, error) {
+	spec, _, err := readString(sections[sectionMeta], 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid meta: %w", err)
+	}
+	table, err := newBinaryTable(sections[sectionStopwords], false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stopwords: %w", err)
+	}
+	stopwords := make([]string, table.len())
+	for i := range stopwords {
+		stopwords[i] = string(table.entry(i))
+	}
+	analyzer, err := ParseAnalyzerWithStopwords(spec, stopwords)
+	if err != nil {
+		return nil, fmt.Errorf("invalid analyzer of index: %w", err)
+	}
+	return analyzer, nil
+}
+
+// encodeTable writes entries with their offsets. Extra offsets are written after offsets of entries
+func encodeTable(entries []string, extra []uint64) []byte {
+	res := make([]byte, 4, 4+(len(entries)+1)*8+len(extra)*8)
+	binary.LittleEndian.PutUint32(res, uint32(len(entries)))
+	offset := uint64(0)
+	for _, entry := range entries {
+		res = appendUint64(res, offset)
+		offset += uint64(len(entry))
+	}
+	res = appendUint64(res, offset)
+	for _, value := range extra {
+		res = appendUint64(res, value)
+	}
+	for _, entry := range entries {
+		res = append(res, entry...)
+	}
+	return res
+}
+
+// binaryTable provides access to entries of table without decoding it
+type binaryTable struct {
+	n       int
+	offsets []byte
+	// offsets of postings for terms table
+	extra []byte
+	data  []byte
+}
+
+func newBinaryTable(section []byte, withExtra bool) (binaryTable, error) {
+	if len(section) < 4 {
+		return binaryTable{}, fmt.Errorf("table is too short")
+	}
+	n := int(binary.LittleEndian.Uint32(section))
+	size := uint64(n+1) * 8
+	tableSize := size
+	if withExtra {
+		tableSize *= 2
+	}
+	if uint64(len(section)-4) < tableSize {
+		return binaryTable{}, fmt.Errorf("table is too short")
+	}
+	t := binaryTable{n: n, offsets: section[4 : 4+size], data: section[4+tableSize:]}
+	if withExtra {
+		t.extra = section[4+size : 4+tableSize]
+	}
+	// offsets must be sorted and inside data
+	prev := uint64(0)
+	for i := 0; i <= n; i++ {
+		offset := binary.LittleEndian.Uint64(t.offsets[i*8:])
+		if offset < prev || offset > uint64(len(t.data)) {
+			return binaryTable{}, fmt.Errorf("invalid offset of entry %d", i)
+		}
+		prev = offset
+	}
+	return t, nil
+}
+
+func (t binaryTable) len() int {
+	return t.n
+}
+
+func (t binaryTable) entry(i int) []byte {
+	return t.data[binary.LittleEndian.Uint64(t.offsets[i*8:]):binary.LittleEndian.Uint64(t.offsets[(i+1)*8:])]
+}
+
+// find returns index of entry equal to key in table of sorted entries or -1
+func (t binaryTable) find(key string) int {
+	i := t.search(key)
+	if i < t.n && string(t.entry(i)) == key {
+		return i
+	}
+	return -1
+}
+
+// search returns index of the first entry not less than key in table of sorted entries
+func (t binaryTable) search(key string) int {
+	return sort.Search(t.n, func(i int) bool {
+		return string(t.entry(i)) >= key
+	})
+}
+
+func (t binaryTable) postingsRange(i int) (uint64, uint64) {
+	return t.value(i), t.value(i + 1)
+}
+
+// value returns i-th extra value of table
+func (t binaryTable) value(i int) uint64 {
+	return binary.LittleEndian.Uint64(t.extra[i*8:])
+}
+
+func appendPostings(buf []byte, postings Postings) []byte {
+	docs := make([]int, 0, len(postings))
+	for doc := range postings {
+		docs = append(docs, doc)
+	}
+	sort.Ints(docs)
+	buf = AppendUvarint(buf, uint64(len(docs)))
+	prevDoc := 0
+	for _, doc := range docs {
+		buf = AppendUvarint(buf, uint64(doc-prevDoc))
+		prevDoc = doc
+		buf = AppendPositions(buf, postings[doc])
+	}
+	return buf
+}
+
+// AppendPositions appends number of positions of word in text and deltas of sorted positions as uvarints.
+// Postings of each text are encoded so in binary index and in bolt store
+func AppendPositions(buf []byte, positions []int) []byte {
+	buf = AppendUvarint(buf, uint64(len(positions)))
+	prev := 0
+	for _, position := range positions {
+		buf = AppendUvarint(buf, uint64(position-prev))
+		prev = position
+	}
+	return buf
+}
+
+// DecodePositions decodes positions written by AppendPositions
+func DecodePositions(data []byte) ([]int, error) {
+	r := varintReader{data: data}
+	positions := r.positions()
+	if r.err == nil && len(r.data) > 0 {
+		return nil, fmt.Errorf("unexpected data after positions")
+	}
+	return positions, r.err
+}
+
+func decodePostings(data []byte) (Postings, error) {
+	r := varintReader{data: data}
+	n := r.next()
+	if n > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid number of texts")
+	}
+	postings := make(Postings, n)
+	doc := 0
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		doc += int(r.next())
+		postings[doc] = r.positions()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return postings, nil
+}
+
+// decodeDocs returns titles and lengths of texts from docs table
+func decodeDocs(data []byte) ([]string, []int, error) {
+	docs, err := newBinaryTable(data, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	titles := make([]string, docs.len())
+	lengths := make([]int, docs.len())
+	for i := range titles {
+		titles[i], lengths[i] = string(docs.entry(i)), int(docs.value(i))
+	}
+	return titles, lengths, nil
+}
+
+// varintReader reads uvarints keeping the first error
+type varintReader struct {
+	data []byte
+	err  error
+}
+
+// positions reads positions written by AppendPositions
+func (r *varintReader) positions() []int {
+	count := r.next()
+	if count > uint64(len(r.data)) {
+		r.err = fmt.Errorf("invalid number of positions")
+		return nil
+	}
+	positions := make([]int, 0, count)
+	position := 0
+	for j := uint64(0); j < count && r.err == nil; j++ {
+		position += int(r.next())
+		positions = append(positions, position)
+	}
+	return positions
+}
+
+func (r *varintReader) next() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, size := binary.Uvarint(r.data)
+	if size <= 0 {
+		r.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+	r.data = r.data[size:]
+	return value
+}
+
+// AppendUvarint appends value as uvarint
+func AppendUvarint(buf []byte, value uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], value)]...)
+}
+
+func appendUint64(buf []byte, value uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], value)
+	return append(buf, tmp[:]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(AppendUvarint(buf, uint64(len(s))), s...)
+}
+
+// readString reads string written by appendString at offset and returns offset after it
+func readString(data []byte, offset int) (string, int, error) {
+	if offset > len(data) {
+		return "", 0, fmt.Errorf("unexpected end of data")
+	}
+	length, size := binary.Uvarint(data[offset:])
+	if size <= 0 || length > uint64(len(data)-offset-size) {
+		return "", 0, fmt.Errorf("invalid string")
+	}
+	start := offset + size
+	return string(data[start : start+int(length)]), start + int(length), nil
+}
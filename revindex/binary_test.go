@@ -0,0 +1,138 @@
+package revindex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndex_SaveBinary(t *testing.T) {
+	saveAndRead := func(index Index) Index {
+		buf := bytes.NewBuffer(nil)
+		if err := index.SaveBinary(buf); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		res, err := ReadBinary(buf)
+		if err != nil {
+			t.Fatal("Read failed:", err)
+		}
+		return res
+	}
+
+	t.Run("index with texts", func(t *testing.T) {
+		analyzer, err := ParseAnalyzer("letters|lowercase")
+		if err != nil {
+			t.Fatal("Cannot parse analyzer:", err)
+		}
+		exp, err := BuildWithAnalyzer([]string{"a b a-c", "", "ёлка\n-\nb"}, []string{"1", "2:", "-"}, analyzer)
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		act := saveAndRead(exp)
+		t.Log("exp:", exp)
+		t.Log("act:", act)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("index without texts", func(t *testing.T) {
+		exp := Index{
+			Analyzer: DefaultAnalyzer(),
+			Titles:   []string{"1", "2"},
+			Lengths:  []int{2, 3},
+			Data: map[string]Postings{
+				"a": {0: {0}},
+				"b": {0: {1}, 1: {0, 2, 300}},
+			},
+		}
+		// read index has sorted words
+		exp.sortTerms()
+		act := saveAndRead(exp)
+		t.Log("exp:", exp)
+		t.Log("act:", act)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("corrupted index", func(t *testing.T) {
+		index, err := Build([]string{"a b"}, []string{"1"})
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := index.SaveBinary(buf); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		data := buf.Bytes()
+		data[len(data)-6] ^= 1
+		_, err = ReadBinary(bytes.NewReader(data))
+		t.Log("err:", err)
+		if !errors.Is(err, ErrChecksum) {
+			t.Fatal("Read must return ErrChecksum")
+		}
+		if _, err = ReadBinary(strings.NewReader("RVIX")); err == nil {
+			t.Fatal("Read must return an error")
+		}
+	})
+}
+
+func TestConvertToBinary(t *testing.T) {
+	exp, err := Build([]string{"a b", "b c"}, []string{"1", "2"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	// save copy, so expected index does not cache sorted terms
+	saved := exp
+	text := bytes.NewBuffer(nil)
+	if err = saved.Save(text); err != nil {
+		t.Fatal("Cannot save index:", err)
+	}
+	converted := bytes.NewBuffer(nil)
+	if err = ConvertToBinary(text, converted); err != nil {
+		t.Fatal("Convert failed:", err)
+	}
+	if !IsBinary(converted.Bytes()) {
+		t.Fatal("Converted index must be binary")
+	}
+	act, err := ReadBinary(converted)
+	if err != nil {
+		t.Fatal("Read failed:", err)
+	}
+	t.Log("exp:", exp)
+	t.Log("act:", act)
+	if !reflect.DeepEqual(act, exp) {
+		t.Fatal("Wrong result")
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	index := generateIndex(1000, 1000)
+	text, binary := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := index.Save(text); err != nil {
+		b.Fatal(err)
+	}
+	if err := index.SaveBinary(binary); err != nil {
+		b.Fatal(err)
+	}
+	b.Logf("text: %d bytes, binary: %d bytes", text.Len(), binary.Len())
+
+	b.Run("text", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := Read(bytes.NewReader(text.Bytes())); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("binary", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadBinary(bytes.NewReader(binary.Bytes())); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package revindex
+
+import "math"
+
+// BM25 is Okapi BM25 ranking function. K1 controls term frequency saturation,
+// B controls how much document length normalizes the score
+type BM25 struct {
+	K1 float64
+	B  float64
+}
+
+// DefaultBM25 has commonly used values of parameters
+var DefaultBM25 = BM25{K1: 1.2, B: 0.75}
+
+// Result of search: title of text, its relevance score and part of text with matching words
+type Result struct {
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet Snippet `json:"snippet,omitempty"`
+}
+
+// idf returns inverse document frequency of term found in df of docs texts
+func (s BM25) idf(df int, docs int) float64 {
+	return math.Log(1 + (float64(docs)-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// score returns relevance of text with length, where term has frequency tf
+func (s BM25) score(idf float64, tf int, length int, avgLength float64) float64 {
+	ratio := 1.0
+	if avgLength > 0 {
+		ratio = float64(length) / avgLength
+	}
+	norm := s.K1 * (1 - s.B + s.B*ratio)
+	return idf * float64(tf) * (s.K1 + 1) / (float64(tf) + norm)
+}
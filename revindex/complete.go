@@ -0,0 +1,148 @@
+package revindex
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+)
+
+// Completer completes prefixes to the most frequent words of texts.
+// Words with prefix are a range of sorted words, the most frequent of them are found by segment tree
+// of the most frequent word of each range, so completion does not visit all words with prefix
+type Completer struct {
+	analyzer Analyzer
+	size     int
+	// sorted words and their document frequencies
+	words       []string
+	frequencies []int32
+	// tree[n+i] is i-th word and tree[i] is the most frequent word of children 2i and 2i+1 for n words
+	tree []int32
+}
+
+// NewCompleter creates completer by words of texts analyzed with analyzer and their document frequencies.
+// Each prefix has at most size completions
+func NewCompleter(analyzer Analyzer, frequencies map[string]int, size int) *Completer {
+	words := make([]string, 0, len(frequencies))
+	for word := range frequencies {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	c := Completer{
+		analyzer:    analyzer,
+		size:        size,
+		words:       words,
+		frequencies: make([]int32, len(words)),
+		tree:        make([]int32, 2*len(words)),
+	}
+	n := len(words)
+	for i, word := range words {
+		c.frequencies[i] = int32(frequencies[word])
+		c.tree[n+i] = int32(i)
+	}
+	for i := n - 1; i > 0; i-- {
+		c.tree[i] = c.best(c.tree[2*i], c.tree[2*i+1])
+	}
+	return &c
+}
+
+// Completer creates completer by words of index
+func (index *Index) Completer(size int) *Completer {
+	frequencies, _ := indexSource{index}.frequencies()
+	return NewCompleter(index.analyzer(), frequencies, size)
+}
+
+// Complete returns the most frequent words starting with prefix normalized by analyzer, the most frequent first.
+// Words are terms made by analyzer, so they may be stems if analyzer stems words
+func (c *Completer) Complete(prefix string) []string {
+	prefix = c.analyzer.Normalize(prefix)
+	start := sort.SearchStrings(c.words, prefix)
+	end := start + sort.Search(len(c.words)-start, func(i int) bool {
+		return !strings.HasPrefix(c.words[start+i], prefix)
+	})
+	res := make([]string, 0)
+	// ranges of words ordered by their most frequent word, it is the next completion.
+	// The range of taken word is split into ranges before and after it
+	ranges := completerRanges{completer: c}
+	c.push(&ranges, start, end)
+	for len(res) < c.size && len(ranges.ranges) > 0 {
+		r := heap.Pop(&ranges).(completerRange)
+		res = append(res, c.words[r.top])
+		c.push(&ranges, r.start, int(r.top))
+		c.push(&ranges, int(r.top)+1, r.end)
+	}
+	return res
+}
+
+// push adds range of words to heap if it is not empty
+func (c *Completer) push(ranges *completerRanges, start int, end int) {
+	if start < end {
+		heap.Push(ranges, completerRange{start: start, end: end, top: c.top(start, end)})
+	}
+}
+
+// top returns the most frequent word in range of words
+func (c *Completer) top(start int, end int) int32 {
+	res := int32(-1)
+	for l, r := start+len(c.words), end+len(c.words); l < r; l, r = l/2, r/2 {
+		if l%2 == 1 {
+			res = c.best(res, c.tree[l])
+			l++
+		}
+		if r%2 == 1 {
+			r--
+			res = c.best(res, c.tree[r])
+		}
+	}
+	return res
+}
+
+// best returns more frequent word of two words, the first one in sorted order if they are equally frequent.
+// Missing word is -1
+func (c *Completer) best(a int32, b int32) int32 {
+	if a == -1 {
+		return b
+	}
+	if b == -1 {
+		return a
+	}
+	if c.frequencies[b] > c.frequencies[a] || c.frequencies[b] == c.frequencies[a] && b < a {
+		return b
+	}
+	return a
+}
+
+// completerRange is a range of sorted words with its most frequent word
+type completerRange struct {
+	start int
+	end   int
+	top   int32
+}
+
+// completerRanges is a heap of ranges of words with the most frequent word on top
+type completerRanges struct {
+	completer *Completer
+	ranges    []completerRange
+}
+
+func (h completerRanges) Len() int {
+	return len(h.ranges)
+}
+
+func (h completerRanges) Less(i, j int) bool {
+	a, b := h.ranges[i].top, h.ranges[j].top
+	return h.completer.best(a, b) == a
+}
+
+func (h completerRanges) Swap(i, j int) {
+	h.ranges[i], h.ranges[j] = h.ranges[j], h.ranges[i]
+}
+
+func (h *completerRanges) Push(x interface{}) {
+	h.ranges = append(h.ranges, x.(completerRange))
+}
+
+func (h *completerRanges) Pop() interface{} {
+	last := h.ranges[len(h.ranges)-1]
+	h.ranges = h.ranges[:len(h.ranges)-1]
+	return last
+}
@@ -0,0 +1,125 @@
+package revindex
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCompleter_Complete(t *testing.T) {
+	texts := []string{
+		"search engine",
+		"search of texts",
+		"searching index",
+		"seal",
+	}
+	index, err := Build(texts, []string{"1", "2", "3", "4"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	completer := index.Completer(2)
+
+	tests := []struct {
+		name   string
+		prefix string
+		exp    []string
+	}{
+		{name: "the most frequent first", prefix: "sea", exp: []string{"search", "seal"}},
+		{name: "limited completions", prefix: "se", exp: []string{"search", "seal"}},
+		{name: "longer prefix", prefix: "searchi", exp: []string{"searching"}},
+		{name: "whole word", prefix: "engine", exp: []string{"engine"}},
+		{name: "normalized prefix", prefix: "SEARCHI", exp: []string{"searching"}},
+		{name: "unknown prefix", prefix: "x", exp: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act := completer.Complete(test.prefix)
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
+
+func TestNewCompleter(t *testing.T) {
+	// words of all lengths up to 3 of letters a, b and c with pseudo-random frequencies
+	frequencies := make(map[string]int)
+	words := []string{""}
+	for length := 0; length < 3; length++ {
+		for _, word := range words {
+			for _, r := range "abc" {
+				frequencies[word+string(r)] = (len(frequencies)*7 + 3) % 5
+			}
+		}
+		words = words[:0]
+		for word := range frequencies {
+			words = append(words, word)
+		}
+	}
+	completer := NewCompleter(DefaultAnalyzer(), frequencies, 4)
+	for _, prefix := range []string{"", "a", "ab", "cba", "d"} {
+		t.Run(prefix, func(t *testing.T) {
+			exp := make([]string, 0)
+			for word := range frequencies {
+				if strings.HasPrefix(word, prefix) {
+					exp = append(exp, word)
+				}
+			}
+			sort.Slice(exp, func(i, j int) bool {
+				if frequencies[exp[i]] != frequencies[exp[j]] {
+					return frequencies[exp[i]] > frequencies[exp[j]]
+				}
+				return exp[i] < exp[j]
+			})
+			if len(exp) > 4 {
+				exp = exp[:4]
+			}
+			act := completer.Complete(prefix)
+			t.Log("exp=", exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
+
+func TestSearcher_Completer(t *testing.T) {
+	index, err := Build([]string{"search engine", "index"}, []string{"1", "2"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	searcher := index.Searcher()
+	completer, err := searcher.Completer(2)
+	if err != nil {
+		t.Fatal("Cannot create completer:", err)
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		act, err := searcher.Completer(2)
+		if err != nil {
+			t.Fatal("Cannot create completer:", err)
+		}
+		if act != completer {
+			t.Fatal("Completer must be cached")
+		}
+	})
+
+	t.Run("changed texts", func(t *testing.T) {
+		index.Update("2", "indexing")
+		act, err := searcher.Completer(2)
+		if err != nil {
+			t.Fatal("Cannot create completer:", err)
+		}
+		// words of deleted texts are kept until compaction
+		exp := []string{"index", "indexing"}
+		t.Log("exp=", exp)
+		t.Log("act=", act.Complete("ind"))
+		if !reflect.DeepEqual(act.Complete("ind"), exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package revindex
+
+import (
+	"fmt"
+	"math"
+)
+
+// Delete marks texts with title as deleted. They are not found by searches and are removed by Compact
+// or on saving. Returns number of deleted texts
+func (index *Index) Delete(title string) int {
+	n := 0
+	for i, t := range index.Titles {
+		if t == title && !index.deleted.Contains(i) {
+			index.deleted.Put(i)
+			n++
+		}
+	}
+	if n > 0 {
+		index.version++
+	}
+	return n
+}
+
+// Update deletes texts with title and adds text with it. Returns number of replaced texts
+func (index *Index) Update(title string, text string) int {
+	n := index.Delete(title)
+	index.addTerms(index.add(title, text))
+	index.version++
+	return n
+}
+
+// Compact removes deleted texts from index. Indices of texts following deleted ones are changed
+func (index *Index) Compact() {
+	if index.deleted.Len() > 0 {
+		version := index.version
+		*index = *index.compacted()
+		index.version = version + 1
+	}
+}
+
+// compacted returns index itself if there are no deleted texts or its copy without them
+func (index *Index) compacted() *Index {
+	if index.deleted.Len() == 0 {
+		return index
+	}
+	res := Index{Analyzer: index.Analyzer, Data: make(map[string]Postings, len(index.Data))}
+	// new indices of texts that are not deleted
+	indices := make(map[int]int, len(index.Titles))
+	for i, title := range index.Titles {
+		if index.deleted.Contains(i) {
+			continue
+		}
+		indices[i] = len(res.Titles)
+		res.Titles = append(res.Titles, title)
+		if i < len(index.Lengths) {
+			res.Lengths = append(res.Lengths, index.Lengths[i])
+		}
+		if i < len(index.Texts) {
+			res.Texts = append(res.Texts, index.Texts[i])
+		}
+	}
+	for word, postings := range index.Data {
+		compacted := make(Postings, len(postings))
+		for i, positions := range postings {
+			if j, ok := indices[i]; ok {
+				compacted[j] = positions
+			}
+		}
+		if len(compacted) > 0 {
+			res.Data[word] = compacted
+		}
+	}
+	res.sortTerms()
+	return &res
+}
+
+// UpdateInStore replaces texts with title in store by text analyzed with analyzer of store
+func UpdateInStore(store Store, title string, text string) error {
+	analyzer, err := (&storeSource{Store: store}).analyzer()
+	if err != nil {
+		return fmt.Errorf("cannot get analyzer of store: %w", err)
+	}
+	index, err := BuildWithAnalyzer([]string{text}, []string{title}, analyzer)
+	if err != nil {
+		return err
+	}
+	return Batch(store, func(store Store) error {
+		if _, err := store.DeleteTitle(title); err != nil {
+			return fmt.Errorf("cannot delete text '%s': %w", title, err)
+		}
+		return index.SaveToStore(store)
+	})
+}
+
+// deletedSource hides deleted texts of source. Words of deleted texts are kept in dictionary until compaction,
+// so suggestions and expansions of patterns may include words without texts
+type deletedSource struct {
+	source
+	deleted *Set
+}
+
+func (s deletedSource) postings(word string) (Postings, error) {
+	postings, err := s.source.postings(word)
+	if err != nil || s.deleted.Len() == 0 {
+		return postings, err
+	}
+	res := make(Postings, len(postings))
+	for i, positions := range postings {
+		if !s.deleted.Contains(i) {
+			res[i] = positions
+		}
+	}
+	return res, nil
+}
+
+func (s deletedSource) stats() (int, float64, error) {
+	docs, avgLength, err := s.source.stats()
+	if err != nil || s.deleted.Len() == 0 {
+		return docs, avgLength, err
+	}
+	// average length is rounded back to total length, so stats are the same as of compacted index
+	total := math.Round(avgLength * float64(docs))
+	for _, i := range s.deleted.SortedKeys() {
+		_, length, err := s.source.doc(i)
+		if err != nil {
+			return 0, 0, err
+		}
+		total -= float64(length)
+	}
+	docs -= s.deleted.Len()
+	if docs == 0 {
+		return 0, 0, nil
+	}
+	return docs, total / float64(docs), nil
+}
+
+func (s deletedSource) all() (*Set, error) {
+	all, err := s.source.all()
+	if err != nil {
+		return nil, err
+	}
+	return all.AndNot(s.deleted), nil
+}
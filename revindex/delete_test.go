@@ -0,0 +1,179 @@
+package revindex
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var deleteTexts = []string{
+	"search engine for texts",
+	"searching texts in index",
+	"database index",
+	"engine of a car",
+}
+
+var deleteTitles = []string{"se", "st", "db", "car"}
+
+var deleteQueries = []string{"index", "texts -engine", "engine", "search* OR db?", "NOT index", "car"}
+
+// checkFind compares results of queries in index and in expected index
+func checkFind(t *testing.T, exp *Index, act *Searcher) {
+	for _, q := range deleteQueries {
+		expRes, err := exp.Find(q, DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		actRes, err := act.Find(q, DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		if !reflect.DeepEqual(actRes, expRes) {
+			t.Log("query:", q)
+			t.Log("exp=", expRes)
+			t.Log("act=", actRes)
+			t.Fatal("Wrong result")
+		}
+	}
+}
+
+func TestIndex_Delete(t *testing.T) {
+	index, err := Build(deleteTexts, deleteTitles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	searcher := index.Searcher()
+	exp, err := Build([]string{deleteTexts[0], deleteTexts[1], deleteTexts[3]}, []string{"se", "st", "car"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+
+	if n := index.Delete("db"); n != 1 {
+		t.Fatal("Wrong number of deleted texts:", n)
+	}
+	if n := index.Delete("db"); n != 0 {
+		t.Fatal("Text is deleted twice")
+	}
+	t.Run("searcher created before delete", func(t *testing.T) {
+		checkFind(t, &exp, searcher)
+	})
+	t.Run("saved index", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := index.SaveBinary(&buf); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		saved, err := ReadBinary(&buf)
+		if err != nil {
+			t.Fatal("Cannot read index:", err)
+		}
+		checkFind(t, &exp, saved.Searcher())
+	})
+	t.Run("compacted index", func(t *testing.T) {
+		index.Compact()
+		checkFind(t, &exp, index.Searcher())
+		if !reflect.DeepEqual(index.Titles, exp.Titles) || !reflect.DeepEqual(index.Data, exp.Data) {
+			t.Log("exp=", exp.Titles, exp.Data)
+			t.Log("act=", index.Titles, index.Data)
+			t.Fatal("Wrong index")
+		}
+	})
+}
+
+func TestIndex_Update(t *testing.T) {
+	index, err := Build(deleteTexts, deleteTitles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	if n := index.Update("se", "engine of a database"); n != 1 {
+		t.Fatal("Wrong number of replaced texts:", n)
+	}
+	if n := index.Update("new", "new car"); n != 0 {
+		t.Fatal("Wrong number of replaced texts:", n)
+	}
+	exp, err := Build(
+		[]string{deleteTexts[1], deleteTexts[2], deleteTexts[3], "engine of a database", "new car"},
+		[]string{"st", "db", "car", "se", "new"},
+	)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	checkFind(t, &exp, index.Searcher())
+}
+
+func TestSegments_Delete(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	segments, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	defer segments.Close()
+	addTexts(t, segments, deleteTexts[:2], deleteTitles[:2])
+	addTexts(t, segments, deleteTexts[2:], deleteTitles[2:])
+
+	if n, err := segments.Delete("db"); err != nil || n != 1 {
+		t.Fatal("Wrong result of delete:", n, err)
+	}
+	version := segments.version
+	if err = segments.Update("st", "texts of new car"); err != nil {
+		t.Fatal("Cannot update text:", err)
+	}
+	// deleted text and new segment are saved in one update of manifest
+	if segments.version != version+1 {
+		t.Fatal("Wrong number of updates of manifest:", segments.version-version)
+	}
+	exp, err := Build(
+		[]string{deleteTexts[0], deleteTexts[3], "texts of new car"},
+		[]string{"se", "car", "st"},
+	)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	checkFind(t, &exp, segments.Searcher())
+
+	t.Run("reopened segments", func(t *testing.T) {
+		reopened, err := OpenSegments(dir)
+		if err != nil {
+			t.Fatal("Cannot open segments:", err)
+		}
+		defer reopened.Close()
+		checkFind(t, &exp, reopened.Searcher())
+	})
+}
+
+func TestSegments_DeleteMerged(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	segments, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	texts := make([]string, 0)
+	titles := make([]string, 0)
+	for i := 0; i < MergeFactor; i++ {
+		addTexts(t, segments, []string{fmt.Sprintf("text number%d", i)}, []string{fmt.Sprint(i)})
+		if i > 0 {
+			texts = append(texts, fmt.Sprintf("text number%d", i))
+			titles = append(titles, fmt.Sprint(i))
+		} else if _, err = segments.Delete("0"); err != nil {
+			t.Fatal("Cannot delete text:", err)
+		}
+	}
+	if err = segments.Close(); err != nil {
+		t.Fatal("Merge failed:", err)
+	}
+	exp, err := Build(texts, titles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	reopened, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	defer reopened.Close()
+	if len(reopened.segments) != 1 || reopened.segments[0].Docs != MergeFactor-1 {
+		t.Fatal("Segments are not merged")
+	}
+	checkFind(t, &exp, reopened.Searcher())
+}
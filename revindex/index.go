@@ -4,72 +4,175 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/polisgo2020/search-K1ta/database"
 	"io"
 	"io/ioutil"
 	"sort"
 	"strings"
-	"unicode"
 )
 
+// Postings maps index of text to sorted positions of word in it
+type Postings map[int][]int
+
+// Docs returns set of texts indices with word
+func (p Postings) Docs() *Set {
+	docs := make([]int, 0, len(p))
+	for i := range p {
+		docs = append(docs, i)
+	}
+	return SetFrom(docs)
+}
+
 type Index struct {
-	Titles []string
-	Data   map[string]Set
+	// analyzer of texts and queries. Default analyzer is used if it is nil
+	Analyzer Analyzer
+	Titles   []string
+	// contents of texts for snippets. Texts may be missing in indices read from older files
+	Texts []string
+	// number of words in each text
+	Lengths []int
+	Data    map[string]Postings
+	// sorted words of Data. They are kept by methods changing Data, so searches only read them
+	terms []string
+	// indices of deleted texts, they are removed by Compact
+	deleted Set
+	// number of changes of texts after build or read
+	version int64
+}
+
+// analyzer returns analyzer of index
+func (index *Index) analyzer() Analyzer {
+	if index.Analyzer == nil {
+		return DefaultAnalyzer()
+	}
+	return index.Analyzer
+}
+
+// sortedTerms returns sorted words of index. Words of index made without Build or Read are sorted on each call
+func (index *Index) sortedTerms() []string {
+	if len(index.terms) == len(index.Data) {
+		return index.terms
+	}
+	terms := make([]string, 0, len(index.Data))
+	for word := range index.Data {
+		terms = append(terms, word)
+	}
+	sort.Strings(terms)
+	return terms
 }
 
-func unifyWord(word string) string {
-	res := strings.TrimFunc(word, func(r rune) bool {
-		return !unicode.IsDigit(r) && !unicode.IsLetter(r)
-	})
-	res = strings.ToLower(res)
-	return res
+// sortTerms sorts words of index after Data is built
+func (index *Index) sortTerms() {
+	index.terms = nil
+	index.terms = index.sortedTerms()
 }
 
+// addTerms puts new words of Data to sorted words. Terms are copied, so copies of index keep their words
+func (index *Index) addTerms(words []string) {
+	if len(index.terms)+len(words) != len(index.Data) {
+		// words of index made without Build or Read are not sorted yet
+		index.sortTerms()
+		return
+	}
+	sort.Strings(words)
+	terms := make([]string, 0, len(index.terms)+len(words))
+	i := 0
+	for _, word := range words {
+		for i < len(index.terms) && index.terms[i] < word {
+			terms = append(terms, index.terms[i])
+			i++
+		}
+		terms = append(terms, word)
+	}
+	index.terms = append(terms, index.terms[i:]...)
+}
+
+// Build index with default analyzer
 func Build(texts []string, titles []string) (Index, error) {
+	return BuildWithAnalyzer(texts, titles, DefaultAnalyzer())
+}
+
+func BuildWithAnalyzer(texts []string, titles []string, analyzer Analyzer) (Index, error) {
 	if len(texts) != len(titles) {
 		return Index{}, errors.New("length of texts is not equal to length of titles")
 	}
-	index := make(map[string]Set)
+	index := Index{
+		Analyzer: analyzer,
+		Titles:   make([]string, 0, len(titles)),
+		Texts:    make([]string, 0, len(texts)),
+		Lengths:  make([]int, 0, len(texts)),
+		Data:     make(map[string]Postings),
+	}
 	for i, text := range texts {
-		// add all words to index
-		for _, word := range strings.Fields(text) {
-			word = unifyWord(word)
-			// add word to
-			if set, ok := index[word]; ok {
-				set.Put(i)
-			} else {
-				index[word] = Set{i: Void{}}
-			}
+		index.add(titles[i], text)
+	}
+	index.sortTerms()
+	return index, nil
+}
+
+// add analyzes text and appends it to index with all terms and their positions. It returns new words of index
+func (index *Index) add(title string, text string) []string {
+	i := len(index.Titles)
+	if index.Data == nil {
+		index.Data = make(map[string]Postings)
+	}
+	tokens := index.analyzer().Analyze(text)
+	words := make([]string, 0)
+	for _, token := range tokens {
+		postings, ok := index.Data[token.Term]
+		if !ok {
+			postings = make(Postings)
+			index.Data[token.Term] = postings
+			words = append(words, token.Term)
 		}
+		postings[i] = append(postings[i], token.Position)
+	}
+	index.Titles = append(index.Titles, title)
+	// texts missing in older indices are empty
+	for len(index.Texts) < i {
+		index.Texts = append(index.Texts, "")
+	}
+	index.Texts = append(index.Texts, text)
+	for len(index.Lengths) < i {
+		index.Lengths = append(index.Lengths, 0)
 	}
-	return Index{
-		Titles: titles,
-		Data:   index,
-	}, nil
+	index.Lengths = append(index.Lengths, len(tokens))
+	return words
 }
 
+// analyzerHeader starts first line of saved index with analyzer spec
+const analyzerHeader = "analyzer:"
+
+// stopwordsHeader starts line after analyzer spec with json array of stopwords referred by it
+const stopwordsHeader = "stopwords:"
+
 func (index *Index) Save(writer io.Writer) error {
+	index = index.compacted()
 	res := make([]byte, 0)
+	// save analyzer spec
+	res = append(res, []byte(fmt.Sprintf("%s%s\n", analyzerHeader, index.analyzer().Spec()))...)
+	if stopwords := analyzerStopwords(index.analyzer()); len(stopwords) > 0 {
+		marshaledStopwords, _ := json.Marshal(stopwords)
+		res = append(res, []byte(fmt.Sprintf("%s%s\n", stopwordsHeader, marshaledStopwords))...)
+	}
 	// save matching of title to index
 	for _, title := range index.Titles {
 		res = append(res, []byte(fmt.Sprintf("%s\n", title))...)
 	}
 	// save delimiter
 	res = append(res, []byte("-\n")...)
-	// save sorted index
-	// sort keys first
-	keys := make([]string, 0, len(index.Data))
-	for k := range index.Data {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	// iterate with sorted keys
-	for _, word := range keys {
-		indices := index.Data[word]
-		sortedIndices := indices.SortedKeys()
-		// marshal keys to json to simplify reading
-		marshaledIndices, _ := json.Marshal(sortedIndices)
-		res = append(res, []byte(fmt.Sprintf("%s:%s\n", word, marshaledIndices))...)
+	// save index sorted by words
+	for _, word := range index.sortedTerms() {
+		// marshal postings to json to simplify reading. Keys of map are sorted by json
+		marshaledPostings, _ := json.Marshal(index.Data[word])
+		res = append(res, []byte(fmt.Sprintf("%s:%s\n", word, marshaledPostings))...)
+	}
+	// save texts as json strings after delimiter, so they take one line each
+	if len(index.Texts) > 0 {
+		res = append(res, []byte("-\n")...)
+		for _, text := range index.Texts {
+			marshaledText, _ := json.Marshal(text)
+			res = append(res, append(marshaledText, '\n')...)
+		}
 	}
 	if _, err := writer.Write(res); err != nil {
 		return fmt.Errorf("cannot write index: %w", err)
@@ -77,13 +180,53 @@ func (index *Index) Save(writer io.Writer) error {
 	return nil
 }
 
-func (index *Index) SaveToDb(db *database.DB) error {
+// SaveToStore adds texts of index to store. Texts with the same titles must be deleted from store before it
+func (index *Index) SaveToStore(store Store) error {
+	return Batch(store, index.compacted().saveToStore)
+}
+
+func (index *Index) saveToStore(store Store) error {
+	// texts in store must be analyzed in the same way
+	spec := index.analyzer().Spec()
+	savedSpec, ok, err := store.GetSetting(analyzerSetting)
+	if err != nil {
+		return fmt.Errorf("cannot get analyzer of store: %w", err)
+	}
+	if !ok {
+		// spec refers to stopwords by hash, so they are saved in their own setting
+		if stopwords := analyzerStopwords(index.analyzer()); len(stopwords) > 0 {
+			marshaledStopwords, _ := json.Marshal(stopwords)
+			if err = store.SetSetting(stopwordsSetting, string(marshaledStopwords)); err != nil {
+				return fmt.Errorf("cannot save stopwords to store: %w", err)
+			}
+		}
+		if err = store.SetSetting(analyzerSetting, spec); err != nil {
+			return fmt.Errorf("cannot save analyzer to store: %w", err)
+		}
+	} else if savedSpec != spec {
+		return fmt.Errorf("store uses analyzer '%s', cannot add index with analyzer '%s'", savedSpec, spec)
+	}
+	if loader, ok := store.(bulkLoader); ok {
+		if err = loader.AddIndex(index); err != nil {
+			return fmt.Errorf("error on adding index to store: %w", err)
+		}
+		return nil
+	}
+
 	// add titles
 	indexMap := make(map[int]int64)
 	for i, title := range index.Titles {
-		id, err := db.AddTitle(title)
+		length := 0
+		if i < len(index.Lengths) {
+			length = index.Lengths[i]
+		}
+		text := ""
+		if i < len(index.Texts) {
+			text = index.Texts[i]
+		}
+		id, err := store.AddTitle(title, text, length)
 		if err != nil {
-			return fmt.Errorf("error on adding title '%s' to database: %w", title, err)
+			return fmt.Errorf("error on adding title '%s' to store: %w", title, err)
 		}
 		if id == -1 {
 			return fmt.Errorf("failed to add title '%s'", title)
@@ -91,24 +234,15 @@ func (index *Index) SaveToDb(db *database.DB) error {
 		indexMap[i] = id
 	}
 
-	// add words
-	for word, indices := range index.Data {
-		wordId, err := db.AddWord(word)
-		if err != nil {
-			return fmt.Errorf("error on adding word '%s' to database: %w", word, err)
-		}
-		if wordId == -1 {
-			return fmt.Errorf("failed to add word '%s'", word)
-		}
+	// add words with postings
+	for word, postings := range index.Data {
 		// map indices to id's
-		mappedIndices := make([]int64, 0)
-		for index := range indices {
-			mappedIndices = append(mappedIndices, indexMap[index])
+		mappedPostings := make(map[int64][]int, len(postings))
+		for index, positions := range postings {
+			mappedPostings[indexMap[index]] = positions
 		}
-		// add word indices
-		err = db.AddWordsIndices(wordId, mappedIndices)
-		if err != nil {
-			return fmt.Errorf("failed to add word '%s' with id '%d' indices: %w", word, wordId, err)
+		if err = store.AddPostings(word, mappedPostings); err != nil {
+			return fmt.Errorf("failed to add word '%s' postings: %w", word, err)
 		}
 	}
 	return nil
@@ -119,75 +253,97 @@ func Read(reader io.Reader) (Index, error) {
 	if err != nil {
 		return Index{}, fmt.Errorf("cannot read index: %w", err)
 	}
-	// split bytes into titles declarations and index
-	tokens := strings.Split(string(bytes), "-\n")
-	if len(tokens) != 2 {
+	content := string(bytes)
+	// declare index
+	index := Index{Data: make(map[string]Postings)}
+	// get analyzer. Index without it is analyzed by default analyzer
+	spec := DefaultAnalyzerSpec
+	if strings.HasPrefix(content, analyzerHeader) {
+		end := strings.Index(content, "\n")
+		if end == -1 {
+			return Index{}, fmt.Errorf("invalid format of index")
+		}
+		spec = content[len(analyzerHeader):end]
+		content = content[end+1:]
+	}
+	var stopwords []string
+	if strings.HasPrefix(content, stopwordsHeader) {
+		end := strings.Index(content, "\n")
+		if end == -1 {
+			return Index{}, fmt.Errorf("invalid format of index")
+		}
+		if err = json.Unmarshal([]byte(content[len(stopwordsHeader):end]), &stopwords); err != nil {
+			return Index{}, fmt.Errorf("cannot unmarshal stopwords: %w", err)
+		}
+		content = content[end+1:]
+	}
+	if index.Analyzer, err = ParseAnalyzerWithStopwords(spec, stopwords); err != nil {
+		return Index{}, fmt.Errorf("invalid analyzer of index: %w", err)
+	}
+	// split content into titles declarations, index and optional texts
+	tokens := strings.Split(content, "-\n")
+	if len(tokens) != 2 && len(tokens) != 3 {
 		return Index{}, fmt.Errorf("invalid format of index")
 	}
-	// declare index
-	index := Index{Data: make(map[string]Set)}
 	// get titles declarations
 	for _, line := range strings.Split(strings.Trim(tokens[0], "\n"), "\n") {
 		index.Titles = append(index.Titles, line)
 	}
 	// get index itself
 	for _, line := range strings.Split(strings.Trim(tokens[1], "\n"), "\n") {
-		// get word and indices of texts with it
-		lastColon := strings.LastIndex(line, ":")
-		if lastColon == -1 || lastColon == len(line)-1 {
+		// get word and postings of it. Word cannot end with colon, so last ':{' starts postings
+		lastColon := strings.LastIndex(line, ":{")
+		if lastColon == -1 {
 			return Index{}, fmt.Errorf("invalid format of words map in index. Line: %s", line)
 		}
-		title := line[:lastColon]
-		indices := line[lastColon+1:]
-		// unmarshal indices
-		var titleIndices []int
-		err = json.Unmarshal([]byte(indices), &titleIndices)
+		word := line[:lastColon]
+		// unmarshal postings
+		postings := make(Postings)
+		err = json.Unmarshal([]byte(line[lastColon+1:]), &postings)
 		if err != nil {
-			return Index{}, fmt.Errorf("cannot unmarshal list with indices: %w", err)
+			return Index{}, fmt.Errorf("cannot unmarshal postings: %w", err)
 		}
-		// put indices to set
-		set := Set{}
-		set.PutAll(titleIndices)
-		index.Data[title] = set
+		index.Data[word] = postings
 	}
-	return index, nil
-}
-
-func (index *Index) Find(phrase string) map[string]int {
-	entriesMap := make(map[string]int)
-	for _, word := range strings.Fields(phrase) {
-		word = unifyWord(word)
-		// get indexes of texts with this word
-		titleIndices, exist := index.Data[word]
-		if !exist {
-			continue
+	// get texts
+	if len(tokens) == 3 {
+		for _, line := range strings.Split(strings.Trim(tokens[2], "\n"), "\n") {
+			var text string
+			if err = json.Unmarshal([]byte(line), &text); err != nil {
+				return Index{}, fmt.Errorf("cannot unmarshal text: %w", err)
+			}
+			index.Texts = append(index.Texts, text)
 		}
-		// for each text titlesToIn add one entry
-		for titleIndex := range titleIndices {
-			title := index.Titles[titleIndex]
-			entriesMap[title]++
+		if len(index.Texts) != len(index.Titles) {
+			return Index{}, fmt.Errorf("number of texts is not equal to number of titles")
 		}
 	}
-	return entriesMap
-}
-
-func FindInDb(phrase string, db *database.DB) (map[string]int, error) {
-	entriesMap := make(map[string]int)
-	for _, word := range strings.Fields(phrase) {
-		word = unifyWord(word)
-		// get indexes of texts with this word
-		titleIndices, err := db.GetWordIndiced(word)
-		if err != nil {
-			return nil, fmt.Errorf("cannot get word '%s' indices: %w", word, err)
-		}
-		// for each text titlesToIn add one entry
-		for _, titleId := range titleIndices {
-			title, err := db.GetTitleById(titleId)
-			if err != nil {
-				return nil, fmt.Errorf("cannot get title by id %d: %w", titleId, err)
+	// lengths of texts are sums of words frequencies
+	index.Lengths = make([]int, len(index.Titles))
+	for _, postings := range index.Data {
+		for i, positions := range postings {
+			if i >= len(index.Lengths) {
+				return Index{}, fmt.Errorf("invalid index of text %d", i)
 			}
-			entriesMap[title]++
+			index.Lengths[i] += len(positions)
 		}
 	}
-	return entriesMap, nil
+	index.sortTerms()
+	return index, nil
+}
+
+// Find returns texts matching query sorted by relevance. See query language description in query.go.
+// Returns *ParseError if query is invalid
+func (index *Index) Find(q string, scorer BM25) ([]Result, error) {
+	return index.Searcher().Find(q, scorer)
+}
+
+// FindFuzzy is like Find, but searches all words of query with typos as if they had '~'
+func (index *Index) FindFuzzy(q string, scorer BM25) ([]Result, error) {
+	return index.Searcher().FindFuzzy(q, scorer)
+}
+
+// FindInStore returns texts saved in store matching query sorted by relevance
+func FindInStore(q string, store Store, scorer BM25) ([]Result, error) {
+	return NewStoreSearcher(store).Find(q, scorer)
 }
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -67,57 +68,52 @@ func BenchmarkBuild_SimpleTest(b *testing.B) {
 			}
 		}
 	}
+	// parallel build with worker for each of GOMAXPROCS, use -cpu flag to compare numbers of workers
+	goParallelTest := func(b *testing.B, texts []string, titles []string) {
+		for i := 0; i < b.N; i++ {
+			if _, err := BuildParallel(texts, titles, DefaultAnalyzer(), runtime.GOMAXPROCS(0)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
 
-	texts, titles := dict.generateTextsAndTitles(generatorSettings{
-		textsNumber: 10, wordsPerText: 50000, maxDictSize: 50,
-	})
-	b.Run("few texts, lot identical words", func(b *testing.B) { goTest(b, texts, titles) })
-
-	texts, titles = dict.generateTextsAndTitles(generatorSettings{
-		textsNumber: 10, wordsPerText: 50000,
-	})
-	b.Run("few texts, lot different words", func(b *testing.B) { goTest(b, texts, titles) })
-
-	texts, titles = dict.generateTextsAndTitles(generatorSettings{
-		textsNumber: 1000, wordsPerText: 1000, maxDictSize: 50,
-	})
-	b.Run("lot texts, lot identical words", func(b *testing.B) { goTest(b, texts, titles) })
-
-	texts, titles = dict.generateTextsAndTitles(generatorSettings{
-		textsNumber: 1000, wordsPerText: 1000,
-	})
-	b.Run("lot texts, lot different words", func(b *testing.B) { goTest(b, texts, titles) })
-
-	texts, titles = dict.generateTextsAndTitles(generatorSettings{
-		textsNumber: 50000, wordsPerText: 10, maxDictSize: 50,
-	})
-	b.Run("lot texts, few identical words", func(b *testing.B) { goTest(b, texts, titles) })
-
-	texts, titles = dict.generateTextsAndTitles(generatorSettings{
-		textsNumber: 50000, wordsPerText: 10,
-	})
-	b.Run("lot texts, few different words", func(b *testing.B) { goTest(b, texts, titles) })
+	tests := []struct {
+		name     string
+		settings generatorSettings
+	}{
+		{name: "few texts, lot identical words", settings: generatorSettings{textsNumber: 10, wordsPerText: 50000, maxDictSize: 50}},
+		{name: "few texts, lot different words", settings: generatorSettings{textsNumber: 10, wordsPerText: 50000}},
+		{name: "lot texts, lot identical words", settings: generatorSettings{textsNumber: 1000, wordsPerText: 1000, maxDictSize: 50}},
+		{name: "lot texts, lot different words", settings: generatorSettings{textsNumber: 1000, wordsPerText: 1000}},
+		{name: "lot texts, few identical words", settings: generatorSettings{textsNumber: 50000, wordsPerText: 10, maxDictSize: 50}},
+		{name: "lot texts, few different words", settings: generatorSettings{textsNumber: 50000, wordsPerText: 10}},
+	}
+	for _, test := range tests {
+		texts, titles := dict.generateTextsAndTitles(test.settings)
+		b.Run(test.name, func(b *testing.B) { goTest(b, texts, titles) })
+		b.Run(test.name+", parallel", func(b *testing.B) { goParallelTest(b, texts, titles) })
+	}
 }
 
 // Generates Index with this format:
-//title-with-number-1
-//...
-//<textsNumber>
-//-
-//w1:[0,1,...,<textsNumber>]
-//w2:[0,1,...,<textsNumber>]
+// title-with-number-1
+// ...
+// <textsNumber>
+// -
+// w1:{"0":[1],"1":[1],...,"<textsNumber>":[1]}
+// w2:{"0":[2],"1":[2],...,"<textsNumber>":[2]}
 func generateIndex(textsNumber int, wordsNumber int) *Index {
 	titles := make([]string, textsNumber)
-	entries := make(map[string]Set)
+	entries := make(map[string]Postings)
 	for i := 0; i < textsNumber; i++ {
 		titles[i] = fmt.Sprintf("title-with-number-%d", i)
 	}
 	for i := 0; i < wordsNumber; i++ {
-		set := Set{}
+		postings := Postings{}
 		for j := 0; j < textsNumber; j++ {
-			set.Put(j)
+			postings[j] = []int{i}
 		}
-		entries[fmt.Sprintf("w%d", i)] = set
+		entries[fmt.Sprintf("w%d", i)] = postings
 	}
 	return &Index{
 		Titles: titles,
@@ -134,7 +130,7 @@ func BenchmarkIndex_Find(b *testing.B) {
 		}
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
-			_ = index.Find(phrase)
+			_, _ = index.Find(phrase, DefaultBM25)
 		}
 	})
 
@@ -146,7 +142,7 @@ func BenchmarkIndex_Find(b *testing.B) {
 		}
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
-			_ = index.Find(phrase)
+			_, _ = index.Find(phrase, DefaultBM25)
 		}
 	})
 
@@ -158,7 +154,7 @@ func BenchmarkIndex_Find(b *testing.B) {
 		}
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
-			_ = index.Find(phrase)
+			_, _ = index.Find(phrase, DefaultBM25)
 		}
 	})
 
@@ -170,7 +166,7 @@ func BenchmarkIndex_Find(b *testing.B) {
 		}
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
-			_ = index.Find(phrase)
+			_, _ = index.Find(phrase, DefaultBM25)
 		}
 	})
 }
@@ -188,10 +184,13 @@ func TestBuild(t *testing.T) {
 		if !reflect.DeepEqual(act.Titles, titles) {
 			t.Fatal("Titles are not equals")
 		}
-		exp := map[string]Set{
-			"a": *SetFrom([]int{0}),
-			"b": *SetFrom([]int{0, 1}),
-			"c": *SetFrom([]int{1}),
+		if !reflect.DeepEqual(act.Lengths, []int{2, 2}) {
+			t.Fatal("Lengths are not equals")
+		}
+		exp := map[string]Postings{
+			"a": {0: {0}},
+			"b": {0: {1}, 1: {0}},
+			"c": {1: {1}},
 		}
 		t.Log("Expected:", exp)
 		if !reflect.DeepEqual(act.Data, exp) {
@@ -199,6 +198,22 @@ func TestBuild(t *testing.T) {
 		}
 	})
 
+	t.Run("positions of repeated words", func(t *testing.T) {
+		act, err := Build([]string{"a b -- a"}, []string{"1"})
+		if err != nil {
+			t.Fatal("Failed to build act:", err)
+		}
+		exp := map[string]Postings{
+			"a": {0: {0, 2}},
+			"b": {0: {1}},
+		}
+		t.Log("exp=", exp)
+		t.Log("act=", act.Data)
+		if !reflect.DeepEqual(act.Data, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
 	t.Run("two titles, one text", func(t *testing.T) {
 		titles := []string{"1", "2"}
 		texts := []string{"single text"}
@@ -210,102 +225,132 @@ func TestBuild(t *testing.T) {
 	})
 }
 
+// resultTitles returns titles of results keeping their order
+func resultTitles(res []Result) []string {
+	titles := make([]string, 0, len(res))
+	for _, r := range res {
+		titles = append(titles, r.Title)
+	}
+	return titles
+}
+
 func TestIndex_Find(t *testing.T) {
 	// index:
 	// 0: a b
 	// 1: b c
 	index := Index{
-		Titles: []string{"0", "1"},
-		Data: map[string]Set{
-			"a": *SetFrom([]int{0}),
-			"b": *SetFrom([]int{0, 1}),
-			"c": *SetFrom([]int{1}),
+		Titles:  []string{"0", "1"},
+		Lengths: []int{2, 2},
+		Data: map[string]Postings{
+			"a": {0: {0}},
+			"b": {0: {1}, 1: {0}},
+			"c": {1: {1}},
 		},
 	}
 
-	t.Run("two words", func(t *testing.T) {
-		act := index.Find("a b")
-		exp := map[string]int{
-			"0": 2,
-			"1": 1,
-		}
-		t.Log("exp=", exp)
-		t.Log("act=", act)
-		if !reflect.DeepEqual(act, exp) {
-			t.Fatal("Wrong result")
-		}
-	})
+	tests := []struct {
+		name   string
+		phrase string
+		exp    []string
+	}{
+		{name: "two words", phrase: "a b", exp: []string{"0", "1"}},
+		{name: "two identical words", phrase: "a a", exp: []string{"0"}},
+		{name: "all words duplicated", phrase: "A: a. B, b.\n C! c?", exp: []string{"0", "1"}},
+		{name: "one word from index, one odd word", phrase: "a d", exp: []string{"0"}},
+		{name: "exact phrase", phrase: "\"a b\" c", exp: []string{"0", "1"}},
+		{name: "phrase with reversed words", phrase: "\"c b\"", exp: []string{}},
+		{name: "unclosed quote", phrase: "a \"b c", exp: []string{"0", "1"}},
+		{name: "words not from index", phrase: "d e", exp: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := index.Find(test.phrase, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(resultTitles(act), test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
+
+func TestIndex_Find_BM25(t *testing.T) {
+	texts := []string{
+		"cat dog",
+		"cat dog bird fish cow",
+		"cat cat cat dog bird",
+		"dog bird",
+	}
+	index, err := Build(texts, []string{"short", "long", "frequent", "without"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
 
-	t.Run("two identical words", func(t *testing.T) {
-		act := index.Find("a a")
-		exp := map[string]int{
-			"0": 2,
+	t.Run("frequent term ranks first", func(t *testing.T) {
+		act, err := index.Find("cat", DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
 		}
-		t.Log("exp=", exp)
 		t.Log("act=", act)
-		if !reflect.DeepEqual(act, exp) {
+		exp := []string{"frequent", "short", "long"}
+		if !reflect.DeepEqual(resultTitles(act), exp) {
 			t.Fatal("Wrong result")
 		}
 	})
 
-	t.Run("all words duplicated", func(t *testing.T) {
-		act := index.Find("A: a. B, b.\n C! c?")
-		exp := map[string]int{
-			"0": 4,
-			"1": 4,
+	t.Run("short text ranks higher", func(t *testing.T) {
+		act, err := index.Find("cat", BM25{K1: 1.2, B: 1})
+		if err != nil {
+			t.Fatal("Find failed:", err)
 		}
-		t.Log("exp=", exp)
 		t.Log("act=", act)
-		if !reflect.DeepEqual(act, exp) {
-			t.Fatal("Wrong result")
+		if act[len(act)-1].Title != "long" {
+			t.Fatal("Long text must be the last one")
 		}
 	})
 
-	t.Run("one word from index, one odd word", func(t *testing.T) {
-		act := index.Find("a d")
-		exp := map[string]int{
-			"0": 1,
+	t.Run("no length normalization", func(t *testing.T) {
+		act, err := index.Find("dog", BM25{K1: 1.2, B: 0})
+		if err != nil {
+			t.Fatal("Find failed:", err)
 		}
-		t.Log("exp=", exp)
 		t.Log("act=", act)
-		if !reflect.DeepEqual(act, exp) {
-			t.Fatal("Wrong result")
+		for _, r := range act {
+			if r.Score != act[0].Score {
+				t.Fatal("All texts must have equal scores")
+			}
 		}
 	})
 
-	t.Run("words not from index", func(t *testing.T) {
-		act := index.Find("d e")
-		t.Log("exp=", []int{})
+	t.Run("rare term has bigger weight", func(t *testing.T) {
+		act, err := index.Find("cow dog", DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
 		t.Log("act=", act)
-		if len(act) != 0 {
-			t.Fatal("Wrong result")
+		if act[0].Title != "long" {
+			t.Fatal("Text with rare term must be the first one")
 		}
 	})
 }
 
-func TestUnifyWord(t *testing.T) {
-	word := "1GgФф.,:!?\"'[]{}()`-_+=*/#$"
-	w := unifyWord(word)
-	t.Log("Unified word:", w)
-	if w != "1ggфф" {
-		t.Fatal("Unified word is invalid")
-	}
-}
-
 func TestIndex_Save(t *testing.T) {
 	index := Index{
 		Titles: []string{"1", "2"},
-		Data: map[string]Set{
-			"a": *SetFrom([]int{0}),
-			"b": *SetFrom([]int{0, 1}),
-			"c": *SetFrom([]int{1}),
+		Data: map[string]Postings{
+			"a": {0: {0}},
+			"b": {0: {1}, 1: {0, 2}},
+			"c": {1: {1}},
 		},
 	}
 	writer := bytes.NewBufferString("")
 	if err := index.Save(writer); err != nil {
 		t.Fatal("Cannot save index:", err)
 	}
-	exp := "1\n2\n-\na:[0]\nb:[0,1]\nc:[1]\n"
+	exp := "analyzer:words|lowercase\n1\n2\n-\na:{\"0\":[0]}\nb:{\"0\":[1],\"1\":[0,2]}\nc:{\"1\":[1]}\n"
 	act := writer.String()
 	t.Log("exp:", exp)
 	t.Log("act:", act)
@@ -330,13 +375,17 @@ func TestRead(t *testing.T) {
 	}
 	t.Run("simple test", func(t *testing.T) {
 		exp := Index{
-			Titles: []string{"1", "2"},
-			Data: map[string]Set{
-				"a": *SetFrom([]int{0}),
-				"b": *SetFrom([]int{0, 1}),
-				"c": *SetFrom([]int{1}),
+			Analyzer: DefaultAnalyzer(),
+			Titles:   []string{"1", "2"},
+			Lengths:  []int{2, 2},
+			Data: map[string]Postings{
+				"a": {0: {0}},
+				"b": {0: {1}, 1: {0}},
+				"c": {1: {1}},
 			},
 		}
+		// read index has sorted words
+		exp.sortTerms()
 		act := saveAndRead(exp)
 		t.Log("act:", act)
 		t.Log("exp:", exp)
@@ -347,13 +396,58 @@ func TestRead(t *testing.T) {
 
 	t.Run("title with colon", func(t *testing.T) {
 		exp := Index{
-			Titles: []string{"1:2:3:", "2"},
-			Data: map[string]Set{
-				"a": *SetFrom([]int{0}),
-				"b": *SetFrom([]int{0, 1}),
-				"c": *SetFrom([]int{1}),
+			Analyzer: DefaultAnalyzer(),
+			Titles:   []string{"1:2:3:", "2"},
+			Lengths:  []int{2, 2},
+			Data: map[string]Postings{
+				"a": {0: {0}},
+				"b": {0: {1}, 1: {0}},
+				"c": {1: {1}},
 			},
 		}
+		// read index has sorted words
+		exp.sortTerms()
+		act := saveAndRead(exp)
+		t.Log("act:", act)
+		t.Log("exp:", exp)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("index without analyzer", func(t *testing.T) {
+		act, err := Read(strings.NewReader("1\n-\na:{\"0\":[0]}\n"))
+		if err != nil {
+			t.Fatal("Read failed:", err)
+		}
+		t.Log("act:", act)
+		if act.Analyzer.Spec() != DefaultAnalyzerSpec {
+			t.Fatal("Index must have default analyzer")
+		}
+	})
+
+	t.Run("custom analyzer", func(t *testing.T) {
+		analyzer, err := ParseAnalyzer("letters|lowercase")
+		if err != nil {
+			t.Fatal("Cannot parse analyzer:", err)
+		}
+		exp, err := BuildWithAnalyzer([]string{"a-b"}, []string{"1"}, analyzer)
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		act := saveAndRead(exp)
+		t.Log("act:", act)
+		t.Log("exp:", exp)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("texts with new lines", func(t *testing.T) {
+		exp, err := Build([]string{"a\n-\nb", "\"c\"-"}, []string{"1", "2"})
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
 		act := saveAndRead(exp)
 		t.Log("act:", act)
 		t.Log("exp:", exp)
@@ -0,0 +1,112 @@
+package revindex
+
+import (
+	"sort"
+	"strings"
+)
+
+// levenshteinAutomaton accepts words within max edits (insertions, deletions, substitutions) of word.
+// State of automaton is a row of edit distances between prefixes of word and characters read so far.
+// Distances above max are capped, so number of states is finite
+type levenshteinAutomaton struct {
+	word []rune
+	max  int
+}
+
+func newLevenshteinAutomaton(word string, max int) levenshteinAutomaton {
+	return levenshteinAutomaton{word: []rune(word), max: max}
+}
+
+func (a levenshteinAutomaton) start() []int {
+	state := make([]int, len(a.word)+1)
+	for i := range state {
+		state[i] = a.cap(i)
+	}
+	return state
+}
+
+// step returns state after reading r
+func (a levenshteinAutomaton) step(state []int, r rune) []int {
+	next := make([]int, len(state))
+	next[0] = a.cap(state[0] + 1)
+	for i := 1; i < len(state); i++ {
+		cost := 1
+		if a.word[i-1] == r {
+			cost = 0
+		}
+		next[i] = a.cap(minInt(state[i-1]+cost, minInt(state[i]+1, next[i-1]+1)))
+	}
+	return next
+}
+
+func (a levenshteinAutomaton) cap(distance int) int {
+	return minInt(distance, a.max+1)
+}
+
+// distance returns distance between word and characters read so far or max+1 if it is too far
+func (a levenshteinAutomaton) distance(state []int) int {
+	return state[len(state)-1]
+}
+
+// canMatch checks that some continuation of characters read so far is within max edits
+func (a levenshteinAutomaton) canMatch(state []int) bool {
+	for _, distance := range state {
+		if distance <= a.max {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyMatch is a word of dictionary with its distance to searched word
+type fuzzyMatch struct {
+	word     string
+	distance int
+}
+
+// intersect returns words of sorted dictionary accepted by automaton. States of common prefixes
+// of neighbour words are reused, and words with prefix that cannot match are skipped
+func (a levenshteinAutomaton) intersect(words []string) []fuzzyMatch {
+	res := make([]fuzzyMatch, 0)
+	// states[i] is a state after reading i characters of prev
+	states := [][]int{a.start()}
+	var prev []rune
+	for i := 0; i < len(words); {
+		w := []rune(words[i])
+		depth := 0
+		for depth < len(states)-1 && depth < len(w) && prev[depth] == w[depth] {
+			depth++
+		}
+		states = states[:depth+1]
+		dead := false
+		for ; depth < len(w); depth++ {
+			state := a.step(states[depth], w[depth])
+			states = append(states, state)
+			if !a.canMatch(state) {
+				dead = true
+				break
+			}
+		}
+		prev = w
+		if dead {
+			// skip words with the same prefix
+			prefix := string(w[:depth+1])
+			i += sort.Search(len(words)-i, func(j int) bool {
+				return !strings.HasPrefix(words[i+j], prefix)
+			})
+			continue
+		}
+		if distance := a.distance(states[len(states)-1]); distance <= a.max {
+			res = append(res, fuzzyMatch{word: words[i], distance: distance})
+		}
+		i++
+	}
+	return res
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,49 @@
+package revindex
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// distance computes edit distance of words by definition
+func distance(a string, b string) int {
+	x, y := []rune(a), []rune(b)
+	row := make([]int, len(y)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			prev, row[j] = row[j], minInt(prev+cost, minInt(row[j]+1, row[j-1]+1))
+		}
+	}
+	return row[len(y)]
+}
+
+func TestLevenshteinAutomaton_Intersect(t *testing.T) {
+	words := []string{"", "a", "ab", "abc", "abcd", "acb", "b", "ba", "bcd", "cab", "кот", "код", "кто", "ток", "xyz"}
+	sort.Strings(words)
+	for _, word := range []string{"abc", "ab", "кот", ""} {
+		for max := 0; max <= 2; max++ {
+			exp := make([]fuzzyMatch, 0)
+			for _, w := range words {
+				if d := distance(word, w); d <= max {
+					exp = append(exp, fuzzyMatch{word: w, distance: d})
+				}
+			}
+			act := newLevenshteinAutomaton(word, max).intersect(words)
+			if !reflect.DeepEqual(act, exp) {
+				t.Log("exp=", exp)
+				t.Log("act=", act)
+				t.Fatalf("Wrong result for '%s' within %d edits", word, max)
+			}
+		}
+	}
+}
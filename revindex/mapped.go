@@ -0,0 +1,233 @@
+package revindex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// MappedIndex is a binary index file mapped to memory. Postings are decoded only when query needs them,
+// so index opens in constant time and uses memory for pages of file read by queries
+type MappedIndex struct {
+	data     []byte
+	unmap    func() error
+	analyzer Analyzer
+	sections [][]byte
+	docs     binaryTable
+	texts    binaryTable
+	terms    binaryTable
+	// total length of texts in words
+	total uint64
+
+	// sorted words for fuzzy queries, decoded by the first such query
+	wordsOnce sync.Once
+	words     []string
+}
+
+// OpenMapped maps binary index file written by SaveBinary. Checksum is not checked, because it requires
+// reading the whole file, use Verify for it. Index must be closed after use
+func OpenMapped(path string) (*MappedIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open index: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get size of index: %w", err)
+	}
+	data, unmap, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot map index: %w", err)
+	}
+	m := MappedIndex{data: data, unmap: unmap}
+	if err = m.init(); err != nil {
+		_ = unmap()
+		return nil, err
+	}
+	return &m, nil
+}
+
+// init reads header and tables of index
+func (m *MappedIndex) init() error {
+	var err error
+	if m.sections, err = binarySections(m.data); err != nil {
+		return err
+	}
+	if m.analyzer, err = decodeAnalyzer(m.sections); err != nil {
+		return err
+	}
+	if m.docs, err = newBinaryTable(m.sections[sectionDocs], true); err != nil {
+		return fmt.Errorf("invalid docs: %w", err)
+	}
+	m.total = m.docs.value(m.docs.len())
+	if m.texts, err = newBinaryTable(m.sections[sectionTexts], false); err != nil {
+		return fmt.Errorf("invalid texts: %w", err)
+	}
+	if m.terms, err = newBinaryTable(m.sections[sectionTerms], true); err != nil {
+		return fmt.Errorf("invalid terms: %w", err)
+	}
+	return nil
+}
+
+// Close unmaps index file
+func (m *MappedIndex) Close() error {
+	return m.unmap()
+}
+
+// Verify checks checksum of index file
+func (m *MappedIndex) Verify() error {
+	return verifyChecksum(m.data)
+}
+
+// Searcher returns searcher of index
+func (m *MappedIndex) Searcher() *Searcher {
+	return &Searcher{src: mappedSource{m}}
+}
+
+// docCount returns number of texts
+func (m *MappedIndex) docCount() int {
+	return m.docs.len()
+}
+
+// title returns title and length of i-th text
+func (m *MappedIndex) title(i int) (string, int) {
+	return string(m.docs.entry(i)), int(m.docs.value(i))
+}
+
+// termPostings returns encoded postings of i-th term
+func (m *MappedIndex) termPostings(i int) ([]byte, error) {
+	start, end := m.terms.postingsRange(i)
+	if start > end || end > uint64(len(m.sections[sectionPostings])) {
+		return nil, fmt.Errorf("invalid postings of term %d", i)
+	}
+	return m.sections[sectionPostings][start:end], nil
+}
+
+// termFrequency returns number of texts with i-th term without decoding its postings
+func (m *MappedIndex) termFrequency(i int) (int, error) {
+	data, err := m.termPostings(i)
+	if err != nil {
+		return 0, err
+	}
+	df, size := binary.Uvarint(data)
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid postings of term %d", i)
+	}
+	return int(df), nil
+}
+
+// mappedSource searches in mapped index
+type mappedSource struct {
+	*MappedIndex
+}
+
+func (s mappedSource) postings(word string) (Postings, error) {
+	i := s.terms.find(word)
+	if i == -1 {
+		return Postings{}, nil
+	}
+	data, err := s.termPostings(i)
+	if err != nil {
+		return nil, err
+	}
+	return decodePostings(data)
+}
+
+func (s mappedSource) stats() (int, float64, error) {
+	count := s.docCount()
+	if count == 0 {
+		return 0, 0, nil
+	}
+	return count, float64(s.total) / float64(count), nil
+}
+
+func (s mappedSource) doc(index int) (string, int, error) {
+	if index < 0 || index >= s.docCount() {
+		return "", 0, fmt.Errorf("invalid index of text %d", index)
+	}
+	title, length := s.title(index)
+	return title, length, nil
+}
+
+func (s mappedSource) text(index int) (string, error) {
+	if index < s.texts.len() {
+		return string(s.texts.entry(index)), nil
+	}
+	return "", nil
+}
+
+func (s mappedSource) all() (*Set, error) {
+	res := Set{}
+	for i := 0; i < s.docCount(); i++ {
+		res.Put(i)
+	}
+	return &res, nil
+}
+
+func (s mappedSource) analyzer() (Analyzer, error) {
+	return s.MappedIndex.analyzer, nil
+}
+
+// expand looks for words with literal prefix of pattern in sorted terms table
+func (s mappedSource) expand(pattern string, limit int) ([]string, error) {
+	prefix := wildcardPrefix(pattern)
+	like := wildcardToLike(pattern)
+	res := make([]string, 0)
+	frequencies := make(map[string]int)
+	for i := s.terms.search(prefix); i < s.terms.len(); i++ {
+		word := string(s.terms.entry(i))
+		if !strings.HasPrefix(word, prefix) {
+			break
+		}
+		if !MatchLike(like, word) {
+			continue
+		}
+		df, err := s.termFrequency(i)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, word)
+		frequencies[word] = df
+	}
+	return mostFrequent(res, func(word string) int {
+		return frequencies[word]
+	}, limit), nil
+}
+
+// words returns all sorted words decoded once, they are filtered by length
+func (s mappedSource) words(min int, max int) ([]string, error) {
+	s.wordsOnce.Do(func() {
+		s.MappedIndex.words = make([]string, s.terms.len())
+		for i := range s.MappedIndex.words {
+			s.MappedIndex.words[i] = string(s.terms.entry(i))
+		}
+	})
+	res := make([]string, 0)
+	for _, word := range s.MappedIndex.words {
+		if length := utf8.RuneCountInString(word); length >= min && length <= max {
+			res = append(res, word)
+		}
+	}
+	return res, nil
+}
+
+// version is always the same, because mapped index is immutable
+func (s mappedSource) version() (int64, error) {
+	return 0, nil
+}
+
+func (s mappedSource) frequencies() (map[string]int, error) {
+	res := make(map[string]int, s.terms.len())
+	for i := 0; i < s.terms.len(); i++ {
+		df, err := s.termFrequency(i)
+		if err != nil {
+			return nil, err
+		}
+		res[string(s.terms.entry(i))] = df
+	}
+	return res, nil
+}
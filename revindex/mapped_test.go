@@ -0,0 +1,99 @@
+package revindex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// saveBinaryFile saves index to temporary file and returns its path and function removing it
+func saveBinaryFile(t *testing.T, index *Index) (string, func()) {
+	dir, err := ioutil.TempDir("", "revindex")
+	if err != nil {
+		t.Fatal("Cannot create dir:", err)
+	}
+	path := filepath.Join(dir, "index.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("Cannot create file:", err)
+	}
+	if err = index.SaveBinary(f); err != nil {
+		t.Fatal("Cannot save index:", err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal("Cannot close file:", err)
+	}
+	return path, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestOpenMapped(t *testing.T) {
+	texts := []string{
+		"search engine for texts",
+		"searching texts in index",
+		"database index",
+		"engine of a car",
+	}
+	index, err := Build(texts, []string{"se", "st", "db", "car"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	path, remove := saveBinaryFile(t, &index)
+	defer remove()
+	mapped, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal("Cannot open index:", err)
+	}
+	defer mapped.Close()
+	if err = mapped.Verify(); err != nil {
+		t.Fatal("Verify failed:", err)
+	}
+
+	for _, q := range []string{"index", "texts -engine", "\"search engine\"", "search* OR db?", "enigne~", "NOT index", "unknown"} {
+		t.Run(q, func(t *testing.T) {
+			exp, err := index.Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			act, err := mapped.Searcher().Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("exp=", exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("suggestions", func(t *testing.T) {
+		suggester, err := mapped.Searcher().Suggester()
+		if err != nil {
+			t.Fatal("Cannot create suggester:", err)
+		}
+		if act, _ := suggester.Suggest("serch"); act != "search" {
+			t.Fatal("Wrong suggestion:", act)
+		}
+	})
+}
+
+func TestOpenMapped_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revindex")
+	if err != nil {
+		t.Fatal("Cannot create dir:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index.txt")
+	if err = ioutil.WriteFile(path, []byte("1\n-\na:{\"0\":[0]}\n"), 0644); err != nil {
+		t.Fatal("Cannot write file:", err)
+	}
+	_, err = OpenMapped(path)
+	t.Log("err:", err)
+	if err == nil {
+		t.Fatal("Open must return an error")
+	}
+}
@@ -0,0 +1,86 @@
+package revindex
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// BuildParallel builds index like BuildWithAnalyzer using workers. Each worker analyzes a slice of texts
+// into its own postings with indices of texts in the whole index, then postings of shards are moved to the largest
+// shard, so only words found in several shards are merged. Number of CPUs is used if workers is zero
+func BuildParallel(texts []string, titles []string, analyzer Analyzer, workers int) (Index, error) {
+	if len(texts) != len(titles) {
+		return Index{}, errors.New("length of texts is not equal to length of titles")
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(texts) {
+		workers = len(texts)
+	}
+	if workers <= 1 {
+		return BuildWithAnalyzer(texts, titles, analyzer)
+	}
+
+	index := Index{
+		Analyzer: analyzer,
+		Titles:   append(make([]string, 0, len(titles)), titles...),
+		Texts:    append(make([]string, 0, len(texts)), texts...),
+		Lengths:  make([]int, len(texts)),
+	}
+	analyzer = index.analyzer()
+	shards := make([]map[string]Postings, workers)
+	var wg sync.WaitGroup
+	for i := range shards {
+		start, end := i*len(texts)/workers, (i+1)*len(texts)/workers
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := make(map[string]Postings)
+			for doc := start; doc < end; doc++ {
+				tokens := analyzer.Analyze(texts[doc])
+				for _, token := range tokens {
+					postings, ok := data[token.Term]
+					if !ok {
+						postings = make(Postings)
+						data[token.Term] = postings
+					}
+					postings[doc] = append(postings[doc], token.Position)
+				}
+				index.Lengths[doc] = len(tokens)
+			}
+			shards[i] = data
+		}(i)
+	}
+	wg.Wait()
+
+	largest := 0
+	for i, shard := range shards {
+		if len(shard) > len(shards[largest]) {
+			largest = i
+		}
+	}
+	index.Data = shards[largest]
+	for i, shard := range shards {
+		if i == largest {
+			continue
+		}
+		for word, postings := range shard {
+			merged, ok := index.Data[word]
+			if !ok {
+				index.Data[word] = postings
+				continue
+			}
+			// the smaller postings are copied to the larger ones
+			if len(postings) > len(merged) {
+				index.Data[word], merged, postings = postings, postings, merged
+			}
+			for doc, positions := range postings {
+				merged[doc] = positions
+			}
+		}
+	}
+	index.sortTerms()
+	return index, nil
+}
@@ -0,0 +1,78 @@
+package revindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildParallel(t *testing.T) {
+	texts, titles := streamTexts(100)
+	exp, err := Build(texts, titles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	for _, test := range []struct {
+		name    string
+		texts   int
+		workers int
+	}{
+		{name: "one worker", texts: 100, workers: 1},
+		{name: "several workers", texts: 100, workers: 7},
+		{name: "more workers than texts", texts: 3, workers: 5},
+		{name: "no texts", texts: 0, workers: 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			exp, err := Build(texts[:test.texts], titles[:test.texts])
+			if err != nil {
+				t.Fatal("Failed to build index:", err)
+			}
+			act, err := BuildParallel(texts[:test.texts], titles[:test.texts], DefaultAnalyzer(), test.workers)
+			if err != nil {
+				t.Fatal("Failed to build index:", err)
+			}
+			if !reflect.DeepEqual(act.Titles, exp.Titles) || !reflect.DeepEqual(act.Texts, exp.Texts) ||
+				!reflect.DeepEqual(act.Lengths, exp.Lengths) {
+				t.Log("exp=", exp.Titles, exp.Lengths)
+				t.Log("act=", act.Titles, act.Lengths)
+				t.Fatal("Wrong texts")
+			}
+			if !reflect.DeepEqual(act.Data, exp.Data) {
+				t.Fatal("Wrong postings")
+			}
+			if !reflect.DeepEqual(act.sortedTerms(), exp.sortedTerms()) {
+				t.Log("exp=", exp.sortedTerms())
+				t.Log("act=", act.sortedTerms())
+				t.Fatal("Wrong sorted words")
+			}
+		})
+	}
+
+	t.Run("found texts", func(t *testing.T) {
+		act, err := BuildParallel(texts, titles, DefaultAnalyzer(), 3)
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		for _, q := range []string{"word3", "text -word5", "\"text number 42\""} {
+			expRes, err := exp.Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			actRes, err := act.Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			if !reflect.DeepEqual(actRes, expRes) {
+				t.Log("query:", q)
+				t.Log("exp=", expRes)
+				t.Log("act=", actRes)
+				t.Fatal("Wrong result")
+			}
+		}
+	})
+
+	t.Run("two titles, one text", func(t *testing.T) {
+		if _, err := BuildParallel([]string{"text"}, []string{"1", "2"}, nil, 2); err == nil {
+			t.Fatal("Build must return an error")
+		}
+	})
+}
@@ -0,0 +1,434 @@
+package revindex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Query language:
+//
+//	word "exact phrase"   texts with any of terms
+//	+word                 texts must contain word
+//	-word, NOT word       texts must not contain word
+//	a AND b               texts with both terms
+//	a OR b                texts with any of terms
+//	(a OR b) AND c        grouping
+//	databas*, inde?       words matching pattern: '*' is any sequence of characters, '?' is any character
+//	word~, word~1         words with typos: within 1 or 2 edits, '~' alone allows more edits for longer words
+//
+// NOT binds tighter than AND, AND binds tighter than OR. Terms without operators are joined with OR.
+// Excluding terms removes texts from the group they are in, so 'a OR NOT b' is the same as 'a -b'.
+
+// ParseError describes invalid query
+type ParseError struct {
+	// position of invalid token in query, starting from 1
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Pos, e.Msg)
+}
+
+type query interface{}
+
+// termQuery matches texts with word or exact phrase
+type termQuery struct {
+	words []string
+	// positions of words in phrase relatively to the first one
+	offsets []int
+}
+
+// wildcardQuery matches texts with any word matching pattern
+type wildcardQuery struct {
+	pattern string
+}
+
+// fuzzyQuery matches texts with words within distance edits of term
+type fuzzyQuery struct {
+	term     string
+	distance int
+}
+
+// boolQuery matches texts with all of must clauses (or any of should clauses if there is no must)
+// and without any of mustNot clauses
+type boolQuery struct {
+	must    []query
+	should  []query
+	mustNot []query
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// '+', '-' or 0
+	modifier rune
+	pos      int
+}
+
+// lexQuery splits query into tokens. Unclosed quote makes phrase till the end of query
+func lexQuery(q string) []token {
+	runes := []rune(q)
+	tokens := make([]token, 0)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		tok := token{pos: i + 1}
+		// modifier is a prefix of term or group
+		if (runes[i] == '+' || runes[i] == '-') && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			tok.modifier = runes[i]
+			i++
+		}
+		switch runes[i] {
+		case '(':
+			tok.kind = tokenOpen
+			tok.text = "("
+			i++
+		case ')':
+			tok.kind = tokenClose
+			tok.text = ")"
+			i++
+		case '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			tok.kind = tokenPhrase
+			tok.text = string(runes[i+1 : end])
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("()\"", runes[end]) {
+				end++
+			}
+			tok.kind = tokenWord
+			tok.text = string(runes[i:end])
+			i = end
+			if tok.modifier == 0 {
+				switch tok.text {
+				case "AND":
+					tok.kind = tokenAnd
+				case "OR":
+					tok.kind = tokenOr
+				case "NOT":
+					tok.kind = tokenNot
+				}
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1})
+}
+
+type parser struct {
+	tokens   []token
+	i        int
+	analyzer Analyzer
+	// words without operators are searched with typos
+	fuzzy bool
+}
+
+// parseQuery builds query tree with terms made by analyzer. Returns nil query if there are no terms in it.
+// If fuzzy is true, words are parsed as if they had '~'
+func parseQuery(q string, analyzer Analyzer, fuzzy bool) (query, error) {
+	p := parser{tokens: lexQuery(q), analyzer: analyzer, fuzzy: fuzzy}
+	res, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, &ParseError{Pos: tok.pos, Msg: "unexpected ')'"}
+	}
+	return res, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+// expectOperand returns error if next token cannot start an operand of operator
+func (p *parser) expectOperand(operator token) error {
+	switch p.peek().kind {
+	case tokenEOF, tokenClose, tokenAnd, tokenOr:
+		return &ParseError{Pos: operator.pos, Msg: fmt.Sprintf("missing operand of %s", operator.text)}
+	}
+	return nil
+}
+
+// parseOr parses clauses joined with OR or nothing
+func (p *parser) parseOr() (query, error) {
+	res := &boolQuery{}
+	// clauses may have no terms, e.g. if they are stopwords
+	empty, hasOperand := true, false
+	for {
+		tok := p.peek()
+		switch tok.kind {
+		case tokenEOF, tokenClose:
+			if empty {
+				return nil, nil
+			}
+			// single clause does not need a group
+			if len(res.must) == 0 && len(res.mustNot) == 0 && len(res.should) == 1 {
+				return res.should[0], nil
+			}
+			return res, nil
+		case tokenAnd:
+			return nil, &ParseError{Pos: tok.pos, Msg: "missing left operand of AND"}
+		case tokenOr:
+			if !hasOperand {
+				return nil, &ParseError{Pos: tok.pos, Msg: "missing left operand of OR"}
+			}
+			p.next()
+			if err := p.expectOperand(tok); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		clause, modifier, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		hasOperand = true
+		if clause == nil {
+			continue
+		}
+		empty = false
+		switch modifier {
+		case '+':
+			res.must = append(res.must, clause)
+		case '-':
+			res.mustNot = append(res.mustNot, clause)
+		default:
+			res.should = append(res.should, clause)
+		}
+	}
+}
+
+// parseAnd parses unary clauses joined with AND. Modifier is returned for single clause only
+func (p *parser) parseAnd() (query, rune, error) {
+	clause, modifier, err := p.parseUnary()
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.peek().kind != tokenAnd {
+		return clause, modifier, nil
+	}
+	res := &boolQuery{}
+	add := func(clause query, modifier rune) {
+		if clause == nil {
+			return
+		}
+		if modifier == '-' {
+			res.mustNot = append(res.mustNot, clause)
+		} else {
+			res.must = append(res.must, clause)
+		}
+	}
+	add(clause, modifier)
+	for p.peek().kind == tokenAnd {
+		operator := p.next()
+		if err := p.expectOperand(operator); err != nil {
+			return nil, 0, err
+		}
+		clause, modifier, err := p.parseUnary()
+		if err != nil {
+			return nil, 0, err
+		}
+		add(clause, modifier)
+	}
+	if len(res.must) == 0 && len(res.mustNot) == 0 {
+		return nil, 0, nil
+	}
+	return res, 0, nil
+}
+
+// parseUnary parses term or group with modifier. NOT is the same as '-' modifier
+func (p *parser) parseUnary() (query, rune, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNot:
+		if err := p.expectOperand(tok); err != nil {
+			return nil, 0, err
+		}
+		clause, modifier, err := p.parseUnary()
+		// excluded clause is negated with a group
+		if clause != nil && modifier == '-' {
+			clause = &boolQuery{mustNot: []query{clause}}
+		}
+		return clause, '-', err
+	case tokenOpen:
+		clause, err := p.parseOr()
+		if err != nil {
+			return nil, 0, err
+		}
+		if p.next().kind != tokenClose {
+			return nil, 0, &ParseError{Pos: tok.pos, Msg: "missing ')'"}
+		}
+		return clause, tok.modifier, nil
+	case tokenWord, tokenPhrase:
+		if tok.kind == tokenWord && strings.ContainsAny(tok.text, "*?") {
+			return p.parseWildcard(tok)
+		}
+		if tok.kind == tokenWord && (p.fuzzy || strings.Contains(tok.text, "~")) {
+			return p.parseFuzzy(tok)
+		}
+		tokens := p.analyzer.Analyze(tok.text)
+		if len(tokens) == 0 {
+			return nil, 0, nil
+		}
+		term := termQuery{}
+		for _, t := range tokens {
+			term.words = append(term.words, t.Term)
+			term.offsets = append(term.offsets, t.Position-tokens[0].Position)
+		}
+		return &term, tok.modifier, nil
+	}
+	if tok.kind == tokenEOF {
+		return nil, 0, &ParseError{Pos: tok.pos, Msg: "unexpected end of query"}
+	}
+	return nil, 0, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected '%s'", tok.text)}
+}
+
+// parseWildcard creates query with pattern trimmed of punctuation and normalized by analyzer
+func (p *parser) parseWildcard(tok token) (query, rune, error) {
+	pattern := strings.TrimFunc(tok.text, func(r rune) bool {
+		return isNotWordRune(r) && !isWildcard(r)
+	})
+	if strings.TrimFunc(pattern, isWildcard) == "" {
+		return nil, 0, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("pattern '%s' must contain letters or digits", tok.text)}
+	}
+	return &wildcardQuery{pattern: p.analyzer.Normalize(pattern)}, tok.modifier, nil
+}
+
+// parseFuzzy creates query with word and distance after '~'. Word without distance gets one by its length
+func (p *parser) parseFuzzy(tok token) (query, rune, error) {
+	text, distance := tok.text, -1
+	if i := strings.LastIndex(text, "~"); i != -1 {
+		switch text[i+1:] {
+		case "":
+		case "0", "1", "2":
+			distance = int(text[i+1] - '0')
+		default:
+			return nil, 0, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("distance of '%s' must be 0, 1 or 2", tok.text)}
+		}
+		text = text[:i]
+	}
+	tokens := p.analyzer.Analyze(text)
+	if len(tokens) == 0 {
+		return nil, 0, nil
+	}
+	// word may be split by tokenizer, each part is searched separately
+	res := &boolQuery{}
+	for _, t := range tokens {
+		d := distance
+		if d == -1 {
+			d = autoDistance(t.Term)
+		}
+		res.should = append(res.should, &fuzzyQuery{term: t.Term, distance: d})
+	}
+	if len(res.should) == 1 {
+		return res.should[0], tok.modifier, nil
+	}
+	return res, tok.modifier, nil
+}
+
+// autoDistance returns number of typos allowed in term: none for short terms and 2 for long ones
+func autoDistance(term string) int {
+	switch length := utf8.RuneCountInString(term); {
+	case length <= 2:
+		return 0
+	case length <= 5:
+		return 1
+	}
+	return 2
+}
+
+func isWildcard(r rune) bool {
+	return r == '*' || r == '?'
+}
+
+// wildcardPrefix returns part of pattern before the first wildcard
+func wildcardPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?"); i != -1 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// wildcardToLike converts pattern to SQL LIKE pattern escaped with '\'
+func wildcardToLike(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '\\', '%', '_':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case '*':
+			b.WriteRune('%')
+		case '?':
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// phraseFrequencies returns number of phrase occurrences in each text, where words of phrase have given postings
+// and are placed with given offsets
+func phraseFrequencies(postings []Postings, offsets []int) map[int]int {
+	res := make(map[int]int)
+	if len(postings) == 0 {
+		return res
+	}
+	for index, positions := range postings[0] {
+		for _, start := range positions {
+			if phraseAt(postings[1:], offsets[1:], index, start) {
+				res[index]++
+			}
+		}
+	}
+	return res
+}
+
+// phraseAt checks that i-th postings contains position start+offsets[i] in text with given index
+func phraseAt(postings []Postings, offsets []int, index int, start int) bool {
+	for i, p := range postings {
+		positions, ok := p[index]
+		if !ok {
+			return false
+		}
+		position := start + offsets[i]
+		j := sort.SearchInts(positions, position)
+		if j == len(positions) || positions[j] != position {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+package revindex
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestIndex_Find_Boolean(t *testing.T) {
+	texts := []string{
+		"apple banana",
+		"banana cherry",
+		"cherry apple",
+		"date",
+	}
+	index, err := Build(texts, []string{"ab", "bc", "ca", "d"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		exp   []string
+	}{
+		{name: "implicit or", query: "apple date", exp: []string{"d", "ab", "ca"}},
+		{name: "and", query: "apple AND banana", exp: []string{"ab"}},
+		{name: "or", query: "banana OR date", exp: []string{"d", "ab", "bc"}},
+		{name: "not", query: "NOT apple", exp: []string{"bc", "d"}},
+		{name: "double not", query: "NOT NOT date", exp: []string{"d"}},
+		{name: "and not", query: "apple AND NOT banana", exp: []string{"ca"}},
+		{name: "must", query: "+apple banana", exp: []string{"ab", "ca"}},
+		{name: "exclude", query: "apple -banana", exp: []string{"ca"}},
+		{name: "or not excludes from group", query: "apple OR NOT banana", exp: []string{"ca"}},
+		{name: "grouping", query: "(apple OR banana) AND cherry", exp: []string{"bc", "ca"}},
+		{name: "excluded group", query: "cherry -(apple OR date)", exp: []string{"bc"}},
+		{name: "negated group", query: "date OR (NOT cherry)", exp: []string{"d", "ab"}},
+		{name: "and binds tighter than or", query: "date OR apple AND banana", exp: []string{"d", "ab"}},
+		{name: "phrase with modifier", query: "+\"banana cherry\"", exp: []string{"bc"}},
+		{name: "lowercase operators are words", query: "and", exp: []string{}},
+		{name: "hyphen inside word", query: "apple-banana", exp: []string{}},
+		{name: "empty query", query: "  ", exp: []string{}},
+		{name: "only punctuation", query: "!!! AND ()", exp: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := index.Find(test.query, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(resultTitles(act), test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("must clauses score optional ones", func(t *testing.T) {
+		act, err := index.Find("+cherry apple", DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		t.Log("act=", act)
+		if !reflect.DeepEqual(resultTitles(act), []string{"ca", "bc"}) || act[0].Score <= act[1].Score {
+			t.Fatal("Wrong result")
+		}
+	})
+}
+
+func TestParseQuery_Errors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{query: "(a b", pos: 1},
+		{query: "a b)", pos: 4},
+		{query: "a AND", pos: 3},
+		{query: "AND a", pos: 1},
+		{query: "a OR", pos: 3},
+		{query: "OR a", pos: 1},
+		{query: "a OR AND b", pos: 3},
+		{query: "NOT", pos: 1},
+		{query: "a (b AND) c", pos: 6},
+		{query: "a *?", pos: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			_, err := parseQuery(test.query, DefaultAnalyzer(), false)
+			t.Log("err=", err)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatal("Parse must return ParseError")
+			}
+			if parseErr.Pos != test.pos {
+				t.Fatalf("Wrong position %d, expected %d", parseErr.Pos, test.pos)
+			}
+		})
+	}
+}
+
+func TestIndex_Find_Wildcard(t *testing.T) {
+	texts := []string{
+		"database databases",
+		"index indexes",
+		"indexing of data",
+		"Data bank",
+	}
+	index, err := Build(texts, []string{"db", "idx", "idxing", "bank"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		exp   []string
+	}{
+		{name: "prefix", query: "databas*", exp: []string{"db"}},
+		{name: "any character", query: "inde?", exp: []string{"idx"}},
+		{name: "prefix matches word itself", query: "index*", exp: []string{"idx", "idxing"}},
+		{name: "wildcard inside word", query: "d*a", exp: []string{"bank", "idxing"}},
+		{name: "leading wildcard", query: "*ing", exp: []string{"idxing"}},
+		{name: "pattern is lowercased", query: "DATA*", exp: []string{"db", "bank", "idxing"}},
+		{name: "trimmed punctuation", query: "(bank*),", exp: []string{"bank"}},
+		{name: "excluded pattern", query: "data* -databas*", exp: []string{"bank", "idxing"}},
+		{name: "no matches", query: "xyz*", exp: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := index.Find(test.query, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(resultTitles(act), test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("expansion is limited to the most frequent words", func(t *testing.T) {
+		act, err := indexSource{&index}.expand("*", 2)
+		if err != nil {
+			t.Fatal("Expand failed:", err)
+		}
+		t.Log("act=", act)
+		if !reflect.DeepEqual(act, []string{"data", "bank"}) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("concurrent searches", func(t *testing.T) {
+		// searches only read index, so race detector finds nothing
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := index.Find("ind*", DefaultBM25); err != nil {
+					t.Error("Find failed:", err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("new words of updated text", func(t *testing.T) {
+		index.Update("bank", "indexed banks")
+		act, err := index.Find("index*", DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		titles := resultTitles(act)
+		sort.Strings(titles)
+		t.Log("act=", titles)
+		if !reflect.DeepEqual(titles, []string{"bank", "idx", "idxing"}) {
+			t.Fatal("Wrong result")
+		}
+	})
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		word    string
+		exp     bool
+	}{
+		{pattern: "abc", word: "abc", exp: true},
+		{pattern: "abc", word: "abd", exp: false},
+		{pattern: "a*", word: "a", exp: true},
+		{pattern: "a*c", word: "abbbc", exp: true},
+		{pattern: "a*c", word: "abcb", exp: false},
+		{pattern: "a?c", word: "abc", exp: true},
+		{pattern: "a?c", word: "ac", exp: false},
+		{pattern: "*b*b", word: "abab", exp: true},
+		{pattern: "п?иск*", word: "поиска", exp: true},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.word, func(t *testing.T) {
+			if act := MatchLike(wildcardToLike(test.pattern), test.word); act != test.exp {
+				t.Fatalf("Wrong result %v, expected %v", act, test.exp)
+			}
+		})
+	}
+
+	t.Run("like pattern", func(t *testing.T) {
+		act := wildcardToLike("5%_a*b?")
+		t.Log("act=", act)
+		if act != `5\%\_a%b_` {
+			t.Fatal("Wrong result")
+		}
+	})
+}
+
+func TestIndex_Find_Fuzzy(t *testing.T) {
+	texts := []string{
+		"search engine",
+		"research",
+		"searching",
+		"serch",
+	}
+	index, err := Build(texts, []string{"engine", "research", "searching", "typo"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		exp   []string
+	}{
+		{name: "one edit", query: "serch~1", exp: []string{"typo", "engine"}},
+		{name: "two edits", query: "reserch~2", exp: []string{"research", "typo"}},
+		{name: "zero edits", query: "serch~0", exp: []string{"typo"}},
+		{name: "auto distance", query: "enigne~", exp: []string{"engine"}},
+		{name: "short words are exact", query: "se~", exp: []string{}},
+		{name: "excluded fuzzy word", query: "search~2 -serch", exp: []string{"engine", "research"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := index.Find(test.query, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("exp=", test.exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(resultTitles(act), test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("fallback", func(t *testing.T) {
+		act, err := index.FindFuzzy("enigne \"search engine\"", DefaultBM25)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		t.Log("act=", act)
+		if !reflect.DeepEqual(resultTitles(act), []string{"engine"}) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("invalid distance", func(t *testing.T) {
+		_, err := index.Find("search~3", DefaultBM25)
+		t.Log("err=", err)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatal("Find must return ParseError")
+		}
+	})
+}
@@ -0,0 +1,767 @@
+package revindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// source provides postings and statistics of texts for searching
+type source interface {
+	// postings returns positions of word in each text
+	postings(word string) (Postings, error)
+	// stats returns number of texts and their average length
+	stats() (int, float64, error)
+	// doc returns title and length of text
+	doc(index int) (string, int, error)
+	// text returns content of text or empty string if it is not saved
+	text(index int) (string, error)
+	// all returns indices of all texts
+	all() (*Set, error)
+	// analyzer returns analyzer of texts
+	analyzer() (Analyzer, error)
+	// expand returns at most limit words matching wildcard pattern, the most frequent first
+	expand(pattern string, limit int) ([]string, error)
+	// words returns sorted words of texts. It may skip words with length in runes out of [min, max]
+	words(min int, max int) ([]string, error)
+	// frequencies returns number of texts with each word
+	frequencies() (map[string]int, error)
+	// version returns version of texts. It is changed when texts are changed, so words do not change with it
+	version() (int64, error)
+}
+
+// batchSource is a source getting postings of many words and titles of many texts at once,
+// so store is queried once instead of once for each word and text
+type batchSource interface {
+	// prefetch gets postings of words for calls of postings
+	prefetch(words []string) error
+	// docs returns titles and lengths of texts
+	docs(indices []int) ([]TextInfo, error)
+	// texts returns contents of texts
+	texts(indices []int) ([]string, error)
+}
+
+// Searcher searches texts of in-memory index, mapped index file, segments or store
+type Searcher struct {
+	src source
+	// snapshot returns source for one search and function releasing it. It is used instead of src if it is set
+	snapshot func() (source, func(), error)
+
+	// suggester and completer are created by words of texts once for each version of source
+	mu               sync.Mutex
+	suggester        *Suggester
+	suggesterVersion int64
+	completer        *Completer
+	completerVersion int64
+	completerSize    int
+}
+
+// Searcher returns searcher of index. Texts deleted after creation of searcher are not found too
+func (index *Index) Searcher() *Searcher {
+	return &Searcher{snapshot: func() (source, func(), error) {
+		return deletedSource{indexSource{index}, &index.deleted}, func() {}, nil
+	}}
+}
+
+// NewStoreSearcher creates searcher of texts saved in store. Titles of found texts are cached by searcher
+func NewStoreSearcher(store Store) *Searcher {
+	titles := &titleCache{}
+	return &Searcher{snapshot: func() (source, func(), error) {
+		// cache is validated once for each search by version of texts at its start
+		version, err := store.GetVersion()
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get version of texts: %w", err)
+		}
+		titles.validate(version)
+		return &storeSource{Store: store, titles: titles, textsVersion: version}, func() {}, nil
+	}}
+}
+
+// Find returns texts matching query sorted by relevance. See query language description in query.go.
+// Returns *ParseError if query is invalid
+func (s *Searcher) Find(q string, scorer BM25) ([]Result, error) {
+	src, release, err := s.source()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return search(src, q, scorer, false)
+}
+
+// FindFuzzy is like Find, but searches all words of query with typos as if they had '~'.
+// It may be used when there are no results for exact words
+func (s *Searcher) FindFuzzy(q string, scorer BM25) ([]Result, error) {
+	src, release, err := s.source()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return search(src, q, scorer, true)
+}
+
+// Suggester returns suggester by words of texts. It is cached by searcher until texts are changed
+func (s *Searcher) Suggester() (*Suggester, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, release, err := s.source()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	version, err := src.version()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get version of texts: %w", err)
+	}
+	if s.suggester != nil && s.suggesterVersion == version {
+		return s.suggester, nil
+	}
+	analyzer, frequencies, err := dictionary(src)
+	if err != nil {
+		return nil, err
+	}
+	s.suggester, s.suggesterVersion = NewSuggester(analyzer, frequencies), version
+	return s.suggester, nil
+}
+
+// Completer returns completer by words of texts with at most size completions of prefix.
+// It is cached by searcher until texts are changed
+func (s *Searcher) Completer(size int) (*Completer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, release, err := s.source()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	version, err := src.version()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get version of texts: %w", err)
+	}
+	if s.completer != nil && s.completerVersion == version && s.completerSize == size {
+		return s.completer, nil
+	}
+	analyzer, frequencies, err := dictionary(src)
+	if err != nil {
+		return nil, err
+	}
+	s.completer, s.completerVersion, s.completerSize = NewCompleter(analyzer, frequencies, size), version, size
+	return s.completer, nil
+}
+
+// dictionary returns analyzer and words of texts of source with their document frequencies
+func dictionary(src source) (Analyzer, map[string]int, error) {
+	analyzer, err := src.analyzer()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get analyzer: %w", err)
+	}
+	frequencies, err := src.frequencies()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get words: %w", err)
+	}
+	return analyzer, frequencies, nil
+}
+
+// source returns source for one search and function releasing it
+func (s *Searcher) source() (source, func(), error) {
+	if s.snapshot != nil {
+		return s.snapshot()
+	}
+	return s.src, func() {}, nil
+}
+
+// MaxExpansions limits number of words a wildcard pattern is expanded to
+const MaxExpansions = 128
+
+// search finds texts matching query and sorts them by relevance. If fuzzy is true, words of query
+// are searched with typos
+func search(src source, q string, scorer BM25, fuzzy bool) ([]Result, error) {
+	analyzer, err := src.analyzer()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get analyzer: %w", err)
+	}
+	parsed, err := parseQuery(q, analyzer, fuzzy)
+	if err != nil {
+		return nil, err
+	}
+	if parsed == nil {
+		return []Result{}, nil
+	}
+	e := evaluator{src: src}
+	if err = e.prefetch(termWords(parsed, nil)); err != nil {
+		return nil, err
+	}
+	matched, err := e.eval(parsed, true)
+	if err != nil {
+		return nil, err
+	}
+	docs, avgLength, err := src.stats()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get stats: %w", err)
+	}
+	// titles and lengths of texts are cached to get each of them once
+	titles := make(map[int]string)
+	lengths := make(map[int]int)
+	indices := matched.SortedKeys()
+	batch, isBatch := src.(batchSource)
+	if isBatch {
+		infos, err := batch.docs(indices)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get texts: %w", err)
+		}
+		for i, index := range indices {
+			titles[index], lengths[index] = infos[i].Title, infos[i].Length
+		}
+	} else {
+		for _, index := range indices {
+			if titles[index], lengths[index], err = src.doc(index); err != nil {
+				return nil, fmt.Errorf("cannot get text %d: %w", index, err)
+			}
+		}
+	}
+	// score matched texts by terms that are not excluded
+	scores := make(map[int]float64)
+	for _, term := range e.scored {
+		idf := scorer.idf(len(term.frequencies), docs)
+		for index, tf := range term.frequencies {
+			// lengths have only matched texts, it is faster than lookup in set for each text of term
+			if length, ok := lengths[index]; ok {
+				scores[index] += term.boost * scorer.score(idf, tf, length, avgLength)
+			}
+		}
+	}
+	// collect results with snippets highlighting scored words
+	words := make(map[string]bool)
+	for _, term := range e.scored {
+		for _, word := range term.words {
+			words[word] = true
+		}
+	}
+	var texts []string
+	if isBatch {
+		if texts, err = batch.texts(indices); err != nil {
+			return nil, fmt.Errorf("cannot get texts: %w", err)
+		}
+	}
+	res := make([]Result, 0, len(indices))
+	for i, index := range indices {
+		var text string
+		if isBatch {
+			text = texts[i]
+		} else if text, err = src.text(index); err != nil {
+			return nil, fmt.Errorf("cannot get text %d: %w", index, err)
+		}
+		res = append(res, Result{
+			Title:   titles[index],
+			Score:   scores[index],
+			Snippet: makeSnippet(text, analyzer, words),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Title < res[j].Title
+	})
+	return res, nil
+}
+
+// scoredTerm is a term that is not excluded from query
+type scoredTerm struct {
+	// words of term
+	words []string
+	// number of term occurrences in each text
+	frequencies map[int]int
+	// multiplier of term score, less than 1 for words with typos
+	boost float64
+}
+
+// evaluator finds texts matching query
+type evaluator struct {
+	src    source
+	scored []scoredTerm
+	// all texts, loaded once on demand
+	all *Set
+}
+
+// eval returns texts matching query. Frequencies of terms are saved for scoring if query is positive
+func (e *evaluator) eval(q query, positive bool) (*Set, error) {
+	switch q := q.(type) {
+	case *termQuery:
+		// get postings of each word of term
+		postings := make([]Postings, 0, len(q.words))
+		for _, word := range q.words {
+			p, err := e.src.postings(word)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get word '%s' postings: %w", word, err)
+			}
+			postings = append(postings, p)
+		}
+		frequencies := phraseFrequencies(postings, q.offsets)
+		if positive {
+			e.scored = append(e.scored, scoredTerm{words: q.words, frequencies: frequencies, boost: 1})
+		}
+		res := Set{}
+		for index := range frequencies {
+			res.Put(index)
+		}
+		return &res, nil
+	case *wildcardQuery:
+		words, err := e.src.expand(q.pattern, MaxExpansions)
+		if err != nil {
+			return nil, fmt.Errorf("cannot expand pattern '%s': %w", q.pattern, err)
+		}
+		boosts := make([]float64, len(words))
+		for i := range words {
+			boosts[i] = 1
+		}
+		return e.evalWords(words, boosts, positive)
+	case *fuzzyQuery:
+		length := utf8.RuneCountInString(q.term)
+		words, err := e.src.words(length-q.distance, length+q.distance)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get words: %w", err)
+		}
+		matches := newLevenshteinAutomaton(q.term, q.distance).intersect(words)
+		// keep the closest words
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].distance < matches[j].distance
+		})
+		if len(matches) > MaxExpansions {
+			matches = matches[:MaxExpansions]
+		}
+		words, boosts := make([]string, len(matches)), make([]float64, len(matches))
+		for i, match := range matches {
+			words[i], boosts[i] = match.word, fuzzyBoost(match.distance)
+		}
+		return e.evalWords(words, boosts, positive)
+	case *boolQuery:
+		var res *Set
+		var err error
+		switch {
+		case len(q.must) > 0:
+			res, err = e.evalAll(q.must, positive, (*Set).And)
+		case len(q.should) > 0:
+			res, err = e.evalAll(q.should, positive, (*Set).Or)
+		default:
+			if e.all == nil {
+				if e.all, err = e.src.all(); err != nil {
+					return nil, fmt.Errorf("cannot get all texts: %w", err)
+				}
+			}
+			res = e.all
+		}
+		if err != nil {
+			return nil, err
+		}
+		// should clauses are evaluated for scoring even if they do not filter texts
+		if len(q.must) > 0 && len(q.should) > 0 {
+			if _, err = e.evalAll(q.should, positive, (*Set).Or); err != nil {
+				return nil, err
+			}
+		}
+		if len(q.mustNot) == 0 {
+			return res, nil
+		}
+		excluded, err := e.evalAll(q.mustNot, false, (*Set).Or)
+		if err != nil {
+			return nil, err
+		}
+		return res.AndNot(excluded), nil
+	}
+	return nil, fmt.Errorf("unknown query %T", q)
+}
+
+// prefetch gets postings of words at once if source supports it
+func (e *evaluator) prefetch(words []string) error {
+	if batch, ok := e.src.(batchSource); ok && len(words) > 0 {
+		if err := batch.prefetch(words); err != nil {
+			return fmt.Errorf("cannot get postings: %w", err)
+		}
+	}
+	return nil
+}
+
+// termWords appends words of term queries in query to words
+func termWords(q query, words []string) []string {
+	switch q := q.(type) {
+	case *termQuery:
+		return append(words, q.words...)
+	case *boolQuery:
+		for _, clauses := range [][]query{q.must, q.should, q.mustNot} {
+			for _, clause := range clauses {
+				words = termWords(clause, words)
+			}
+		}
+	}
+	return words
+}
+
+// evalWords returns texts with any of words. Each word is scored as a separate term with its boost
+func (e *evaluator) evalWords(words []string, boosts []float64, positive bool) (*Set, error) {
+	if err := e.prefetch(words); err != nil {
+		return nil, err
+	}
+	res := Set{}
+	for i, word := range words {
+		postings, err := e.src.postings(word)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get word '%s' postings: %w", word, err)
+		}
+		frequencies := make(map[int]int, len(postings))
+		for index, positions := range postings {
+			frequencies[index] = len(positions)
+			res.Put(index)
+		}
+		if positive {
+			e.scored = append(e.scored, scoredTerm{words: []string{word}, frequencies: frequencies, boost: boosts[i]})
+		}
+	}
+	return &res, nil
+}
+
+// fuzzyBoost returns multiplier of score of word with typos, so exact words are ranked higher
+func fuzzyBoost(distance int) float64 {
+	return 1 / float64(1+distance)
+}
+
+// mostFrequent returns at most limit words with the biggest document frequency keeping order of equal ones
+func mostFrequent(words []string, df func(word string) int, limit int) []string {
+	frequencies := make(map[string]int, len(words))
+	for _, word := range words {
+		frequencies[word] = df(word)
+	}
+	sort.SliceStable(words, func(i, j int) bool {
+		return frequencies[words[i]] > frequencies[words[j]]
+	})
+	if len(words) > limit {
+		words = words[:limit]
+	}
+	return words
+}
+
+// evalAll evaluates queries and combines their results with operation
+func (e *evaluator) evalAll(queries []query, positive bool, op func(*Set, *Set) *Set) (*Set, error) {
+	var res *Set
+	for _, q := range queries {
+		set, err := e.eval(q, positive)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			res = set
+		} else {
+			res = op(res, set)
+		}
+	}
+	return res, nil
+}
+
+// indexSource searches in index in memory
+type indexSource struct {
+	*Index
+}
+
+func (s indexSource) postings(word string) (Postings, error) {
+	return s.Data[word], nil
+}
+
+func (s indexSource) stats() (int, float64, error) {
+	total := 0
+	for _, length := range s.Lengths {
+		total += length
+	}
+	if len(s.Lengths) == 0 {
+		return len(s.Titles), 0, nil
+	}
+	return len(s.Titles), float64(total) / float64(len(s.Lengths)), nil
+}
+
+func (s indexSource) text(index int) (string, error) {
+	if index < len(s.Texts) {
+		return s.Texts[index], nil
+	}
+	return "", nil
+}
+
+func (s indexSource) analyzer() (Analyzer, error) {
+	return s.Index.analyzer(), nil
+}
+
+// expand looks for words with literal prefix of pattern in sorted terms of index
+func (s indexSource) expand(pattern string, limit int) ([]string, error) {
+	terms := s.sortedTerms()
+	prefix := wildcardPrefix(pattern)
+	like := wildcardToLike(pattern)
+	res := make([]string, 0)
+	for i := sort.SearchStrings(terms, prefix); i < len(terms) && strings.HasPrefix(terms[i], prefix); i++ {
+		if MatchLike(like, terms[i]) {
+			res = append(res, terms[i])
+		}
+	}
+	return mostFrequent(res, func(word string) int {
+		return len(s.Data[word])
+	}, limit), nil
+}
+
+func (s indexSource) frequencies() (map[string]int, error) {
+	res := make(map[string]int, len(s.Data))
+	for word, postings := range s.Data {
+		res[word] = len(postings)
+	}
+	return res, nil
+}
+
+func (s indexSource) version() (int64, error) {
+	return s.Index.version, nil
+}
+
+func (s indexSource) words(int, int) ([]string, error) {
+	return s.sortedTerms(), nil
+}
+
+func (s indexSource) all() (*Set, error) {
+	res := Set{}
+	for i := range s.Titles {
+		res.Put(i)
+	}
+	return &res, nil
+}
+
+func (s indexSource) doc(index int) (string, int, error) {
+	length := 0
+	if index < len(s.Lengths) {
+		length = s.Lengths[index]
+	}
+	return s.Titles[index], length, nil
+}
+
+// storeSource searches in index saved in store. Index of text is its id in store. It is created for one search,
+// postings of its words are got at once and titles are cached by searcher
+type storeSource struct {
+	Store
+	// postings of words got for search
+	prefetched map[string]Postings
+	// titles cached by searcher, they are validated by version of texts when source is created
+	titles       *titleCache
+	textsVersion int64
+}
+
+func (s *storeSource) postings(word string) (Postings, error) {
+	if err := s.prefetch([]string{word}); err != nil {
+		return nil, err
+	}
+	return s.prefetched[word], nil
+}
+
+// prefetch gets postings of words that are not got yet by one query
+func (s *storeSource) prefetch(words []string) error {
+	if s.prefetched == nil {
+		s.prefetched = make(map[string]Postings)
+	}
+	missing := make([]string, 0, len(words))
+	for _, word := range words {
+		if _, ok := s.prefetched[word]; !ok {
+			// duplicates are skipped
+			s.prefetched[word] = nil
+			missing = append(missing, word)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	res, err := s.GetWordsPostings(missing)
+	if err != nil {
+		for _, word := range missing {
+			delete(s.prefetched, word)
+		}
+		return err
+	}
+	for _, word := range missing {
+		p := make(Postings, len(res[word]))
+		for titleId, positions := range res[word] {
+			p[int(titleId)] = positions
+		}
+		s.prefetched[word] = p
+	}
+	return nil
+}
+
+func (s *storeSource) stats() (int, float64, error) {
+	return s.GetStats()
+}
+
+func (s *storeSource) doc(index int) (string, int, error) {
+	docs, err := s.docs([]int{index})
+	if err != nil {
+		return "", 0, err
+	}
+	return docs[0].Title, docs[0].Length, nil
+}
+
+// docs returns titles and lengths of texts. Titles that are not cached are got by one query
+func (s *storeSource) docs(indices []int) ([]TextInfo, error) {
+	res := make([]TextInfo, len(indices))
+	cached := make(map[int64]TextInfo)
+	if s.titles != nil {
+		cached = s.titles.get(indices)
+	}
+	missing := make([]int64, 0)
+	for _, index := range indices {
+		if _, ok := cached[int64(index)]; !ok {
+			missing = append(missing, int64(index))
+		}
+	}
+	if len(missing) > 0 {
+		titles, err := s.GetTitles(missing)
+		if err != nil {
+			return nil, err
+		}
+		if s.titles != nil {
+			s.titles.put(titles)
+		}
+		for id, title := range titles {
+			cached[id] = title
+		}
+	}
+	for i, index := range indices {
+		title, ok := cached[int64(index)]
+		if !ok {
+			return nil, fmt.Errorf("no text with id %d", index)
+		}
+		res[i] = title
+	}
+	return res, nil
+}
+
+func (s *storeSource) text(index int) (string, error) {
+	texts, err := s.texts([]int{index})
+	if err != nil {
+		return "", err
+	}
+	return texts[0], nil
+}
+
+// texts returns contents of texts by one query
+func (s *storeSource) texts(indices []int) ([]string, error) {
+	ids := make([]int64, len(indices))
+	for i, index := range indices {
+		ids[i] = int64(index)
+	}
+	texts, err := s.GetTexts(ids)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(indices))
+	for i, id := range ids {
+		text, ok := texts[id]
+		if !ok {
+			return nil, fmt.Errorf("no text with id %d", id)
+		}
+		res[i] = text
+	}
+	return res, nil
+}
+
+// titleCacheSize limits number of titles cached by searcher of store
+const titleCacheSize = 100000
+
+// titleCache keeps titles of texts in store by ids. Texts may be replaced in place and ids may be reused
+// after store is cleared, so cache is cleared when version of store changes
+type titleCache struct {
+	mu      sync.Mutex
+	titles  map[int64]TextInfo
+	version int64
+}
+
+// validate clears cache if version of store is changed
+func (c *titleCache) validate(version int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		c.titles, c.version = nil, version
+	}
+}
+
+// get returns cached titles of texts
+func (c *titleCache) get(indices []int) map[int64]TextInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[int64]TextInfo)
+	for _, index := range indices {
+		if title, ok := c.titles[int64(index)]; ok {
+			res[int64(index)] = title
+		}
+	}
+	return res
+}
+
+// put caches titles. Cache is cleared if it is full
+func (c *titleCache) put(titles map[int64]TextInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.titles == nil || len(c.titles)+len(titles) > titleCacheSize {
+		c.titles = make(map[int64]TextInfo)
+	}
+	for id, title := range titles {
+		c.titles[id] = title
+	}
+}
+
+// analyzer returns analyzer saved in store or default one for databases created before analyzers
+func (s *storeSource) analyzer() (Analyzer, error) {
+	spec, ok, err := s.GetSetting(analyzerSetting)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return DefaultAnalyzer(), nil
+	}
+	var stopwords []string
+	if hasStopwords(spec) {
+		data, ok, err := s.GetSetting(stopwordsSetting)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if err = json.Unmarshal([]byte(data), &stopwords); err != nil {
+				return nil, fmt.Errorf("invalid stopwords of store: %w", err)
+			}
+		}
+	}
+	return ParseAnalyzerWithStopwords(spec, stopwords)
+}
+
+func (s *storeSource) expand(pattern string, limit int) ([]string, error) {
+	return s.GetWordsLike(wildcardToLike(pattern), limit)
+}
+
+func (s *storeSource) words(min int, max int) ([]string, error) {
+	return s.GetWordsByLength(min, max)
+}
+
+func (s *storeSource) frequencies() (map[string]int, error) {
+	return s.GetWordFrequencies()
+}
+
+// version returns version of texts at creation of source by searcher or current version of store
+func (s *storeSource) version() (int64, error) {
+	if s.titles != nil {
+		return s.textsVersion, nil
+	}
+	return s.GetVersion()
+}
+
+func (s *storeSource) all() (*Set, error) {
+	ids, err := s.GetTitleIds()
+	if err != nil {
+		return nil, err
+	}
+	res := Set{}
+	for _, id := range ids {
+		res.Put(int(id))
+	}
+	return &res, nil
+}
@@ -0,0 +1,779 @@
+package revindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// manifestFile lists segments of directory. It is replaced on each change, so readers see old or new list
+	manifestFile = "segments.json"
+	// extension of segment files
+	segmentExt = ".seg"
+)
+
+// MergeFactor is the number of adjacent segments of the same level merged into one.
+// Level of segment is the integer part of logarithm of its number of texts by MergeFactor
+const MergeFactor = 10
+
+// manifest is the list of segments saved in directory
+type manifest struct {
+	// number of the last created segment, it is used in names of new segments
+	Generation int `json:"generation"`
+	// analyzer spec of all segments
+	Analyzer string `json:"analyzer,omitempty"`
+	// stopwords referred by hash in analyzer spec
+	Stopwords []string          `json:"stopwords,omitempty"`
+	Segments  []manifestSegment `json:"segments"`
+	// sources of texts by their titles
+	Documents map[string]Document `json:"documents,omitempty"`
+}
+
+// Document is a source of text: hash of its content and modification time in unix nanoseconds.
+// It is used to skip unchanged files on repeated builds
+type Document struct {
+	Hash    string `json:"hash"`
+	ModTime int64  `json:"mtime"`
+}
+
+type manifestSegment struct {
+	Name string `json:"name"`
+	Docs int    `json:"docs"`
+	// indices of deleted texts, they are removed on merge. Slice is replaced on change, so it may be shared
+	Deleted []int `json:"deleted,omitempty"`
+}
+
+// segment is an opened segment file. It is closed when it is neither in the list of segments nor used by searches
+type segment struct {
+	manifestSegment
+	index *MappedIndex
+	// set of Deleted
+	deleted *Set
+	// number of users of segment: list of segments and running searches
+	refs int
+	// file of segment is removed after closing, if segment was merged into another one
+	remove bool
+}
+
+// Segments is a directory of immutable index segments. Each Add writes texts to a new small segment,
+// searches fan out across all segments and adjacent segments of similar size are merged in background.
+// Only one process may add texts to directory at a time, other processes may search in it
+type Segments struct {
+	dir string
+
+	mu       sync.Mutex
+	manifest manifest
+	segments []*segment
+	// manifest read last time, it is reread by searches when it is changed by other process
+	raw []byte
+	// number of changes of manifest, it is version of texts of searches
+	version int64
+	// only one merge runs at a time
+	merging  bool
+	merges   sync.WaitGroup
+	mergeErr error
+	closed   bool
+}
+
+// OpenSegments opens directory of segments, it is created if it does not exist. Segments must be closed after use
+func OpenSegments(dir string) (*Segments, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create dir of segments: %w", err)
+	}
+	s := Segments{dir: dir}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Add writes index to a new segment and starts merge of segments if there are enough small ones.
+// Analyzer of index must be the same as analyzer of other segments
+func (s *Segments) Add(index *Index) error {
+	if len(index.Titles) == 0 {
+		return nil
+	}
+	_, err := s.addSegment(index.analyzer(), len(index.Titles), index.SaveBinary, nil)
+	return err
+}
+
+// Replace deletes texts with titles and finishes builder to a new segment. Both changes are saved in one update
+// of list of segments, so searches see either old or new texts. Builder is closed. Returns number of deleted texts
+func (s *Segments) Replace(titles []string, builder *StreamBuilder) (int, error) {
+	if builder.added == 0 {
+		if err := builder.Close(); err != nil {
+			return 0, fmt.Errorf("cannot close builder: %w", err)
+		}
+		return s.addSegment(nil, 0, nil, titles)
+	}
+	n, err := s.addSegment(builder.analyzer, builder.added, builder.Finish, titles)
+	// builder is not finished if segment is not written
+	_ = builder.Close()
+	return n, err
+}
+
+// Delete marks texts with title in all segments as deleted. Returns number of deleted texts
+func (s *Segments) Delete(title string) (int, error) {
+	return s.addSegment(nil, 0, nil, []string{title})
+}
+
+// addSegment writes segment of docs texts analyzed by analyzer if there are texts and marks texts
+// with titles in other segments as deleted. Segment and deleted texts are saved in one update of manifest.
+// Returns number of deleted texts
+func (s *Segments) addSegment(analyzer Analyzer, docs int, write func(io.Writer) error, titles []string) (int, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, errors.New("segments are closed")
+	}
+	if err := s.refresh(); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	var seg *segment
+	spec := ""
+	if docs > 0 {
+		spec = analyzer.Spec()
+		if s.manifest.Analyzer != "" && s.manifest.Analyzer != spec {
+			s.mu.Unlock()
+			return 0, fmt.Errorf("analyzer '%s' differs from analyzer of segments '%s'", spec, s.manifest.Analyzer)
+		}
+		s.manifest.Generation++
+		name := segmentName(s.manifest.Generation)
+		s.mu.Unlock()
+
+		// segment is written without lock, so searches are not blocked
+		var err error
+		if seg, err = s.writeSegment(name, docs, write); err != nil {
+			return 0, err
+		}
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+	deleted := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		deleted[title] = true
+	}
+	old := make([][]int, len(s.segments))
+	n := 0
+	for i, seg := range s.segments {
+		old[i] = seg.Deleted
+		docs := make([]int, 0)
+		for doc := 0; len(deleted) > 0 && doc < seg.index.docCount(); doc++ {
+			if title, _ := seg.index.title(doc); deleted[title] && !seg.deleted.Contains(doc) {
+				docs = append(docs, doc)
+			}
+		}
+		if len(docs) > 0 {
+			seg.setDeleted(append(append([]int{}, seg.Deleted...), docs...))
+			n += len(docs)
+		}
+	}
+	if seg == nil && n == 0 {
+		return 0, nil
+	}
+	oldDocuments := s.manifest.Documents
+	if n > 0 && len(s.manifest.Documents) > 0 {
+		s.manifest.Documents = make(map[string]Document, len(oldDocuments))
+		for title, doc := range oldDocuments {
+			if !deleted[title] {
+				s.manifest.Documents[title] = doc
+			}
+		}
+	}
+	oldAnalyzer, oldStopwords := s.manifest.Analyzer, s.manifest.Stopwords
+	if seg != nil {
+		s.manifest.Analyzer, s.manifest.Stopwords = spec, analyzerStopwords(analyzer)
+		s.segments = append(s.segments, seg)
+	}
+	if err := s.saveManifest(); err != nil {
+		if seg != nil {
+			s.segments = s.segments[:len(s.segments)-1]
+			seg.remove = true
+			s.release(seg)
+		}
+		for i, seg := range s.segments {
+			seg.setDeleted(old[i])
+		}
+		s.manifest.Documents, s.manifest.Analyzer, s.manifest.Stopwords = oldDocuments, oldAnalyzer, oldStopwords
+		return 0, err
+	}
+	if seg != nil {
+		s.maybeMerge()
+	}
+	return n, nil
+}
+
+// Documents returns sources of texts by their titles
+func (s *Segments) Documents() (map[string]Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	res := make(map[string]Document, len(s.manifest.Documents))
+	for title, doc := range s.manifest.Documents {
+		res[title] = doc
+	}
+	return res, nil
+}
+
+// SetDocuments replaces sources of texts
+func (s *Segments) SetDocuments(docs map[string]Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.manifest.Documents
+	s.manifest.Documents = make(map[string]Document, len(docs))
+	for title, doc := range docs {
+		s.manifest.Documents[title] = doc
+	}
+	if err := s.saveManifest(); err != nil {
+		s.manifest.Documents = old
+		return err
+	}
+	return nil
+}
+
+// Update replaces texts with title by text in a new segment in one update of manifest
+func (s *Segments) Update(title string, text string) error {
+	s.mu.Lock()
+	spec, stopwords := s.manifest.Analyzer, s.manifest.Stopwords
+	s.mu.Unlock()
+	analyzer := DefaultAnalyzer()
+	if spec != "" {
+		var err error
+		if analyzer, err = ParseAnalyzerWithStopwords(spec, stopwords); err != nil {
+			return fmt.Errorf("invalid analyzer of segments: %w", err)
+		}
+	}
+	index, err := BuildWithAnalyzer([]string{text}, []string{title}, analyzer)
+	if err != nil {
+		return err
+	}
+	_, err = s.addSegment(index.analyzer(), 1, index.SaveBinary, []string{title})
+	return err
+}
+
+// Close waits for running merge and closes segments. Segments used by running searches are closed after them.
+// It returns error of the last failed merge
+func (s *Segments) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.merges.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seg := range s.segments {
+		s.release(seg)
+	}
+	s.segments = nil
+	return s.mergeErr
+}
+
+// Searcher returns searcher of segments. Each search uses segments existing at its start
+func (s *Segments) Searcher() *Searcher {
+	return &Searcher{snapshot: s.snapshot}
+}
+
+// snapshot returns source of current segments. They are kept open until returned function is called
+func (s *Segments) snapshot() (source, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, errors.New("segments are closed")
+	}
+	if err := s.refresh(); err != nil {
+		return nil, nil, err
+	}
+	segments := make([]*segment, len(s.segments))
+	copy(segments, s.segments)
+	sources := make([]source, len(segments))
+	docs := make([]int, len(segments))
+	for i, seg := range segments {
+		seg.refs++
+		sources[i] = deletedSource{mappedSource{seg.index}, seg.deleted}
+		docs[i] = seg.Docs
+	}
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, seg := range segments {
+			s.release(seg)
+		}
+	}
+	src := newMultiSource(sources, docs)
+	src.textsVersion = s.version
+	return src, release, nil
+}
+
+// refresh reads manifest if it was changed by other process, opens new segments and releases removed ones
+func (s *Segments) refresh() error {
+	raw, err := ioutil.ReadFile(filepath.Join(s.dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read manifest of segments: %w", err)
+	}
+	if bytes.Equal(raw, s.raw) {
+		return nil
+	}
+	var m manifest
+	if err = json.Unmarshal(raw, &m); err != nil {
+		return fmt.Errorf("invalid manifest of segments: %w", err)
+	}
+	opened := make(map[string]*segment, len(s.segments))
+	for _, seg := range s.segments {
+		opened[seg.Name] = seg
+	}
+	segments := make([]*segment, 0, len(m.Segments))
+	added := make([]*segment, 0)
+	for _, ms := range m.Segments {
+		if seg, ok := opened[ms.Name]; ok {
+			seg.setDeleted(ms.Deleted)
+			segments = append(segments, seg)
+			delete(opened, ms.Name)
+			continue
+		}
+		index, err := OpenMapped(filepath.Join(s.dir, ms.Name))
+		if err != nil {
+			for _, seg := range added {
+				_ = seg.index.Close()
+			}
+			return fmt.Errorf("cannot open segment %s: %w", ms.Name, err)
+		}
+		seg := &segment{manifestSegment: ms, index: index, refs: 1}
+		seg.setDeleted(ms.Deleted)
+		segments = append(segments, seg)
+		added = append(added, seg)
+	}
+	// segments left in map were merged by other process
+	for _, seg := range opened {
+		s.release(seg)
+	}
+	s.manifest, s.segments, s.raw = m, segments, raw
+	s.version++
+	return nil
+}
+
+// saveManifest writes list of segments to temporary file and renames it to manifest
+func (s *Segments) saveManifest() error {
+	s.manifest.Segments = make([]manifestSegment, len(s.segments))
+	for i, seg := range s.segments {
+		s.manifest.Segments[i] = seg.manifestSegment
+	}
+	raw, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal manifest of segments: %w", err)
+	}
+	path := filepath.Join(s.dir, manifestFile)
+	if err = ioutil.WriteFile(path+".tmp", raw, 0644); err != nil {
+		return fmt.Errorf("cannot write manifest of segments: %w", err)
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("cannot write manifest of segments: %w", err)
+	}
+	s.raw = raw
+	s.version++
+	return nil
+}
+
+// release decrements number of users of segment and closes it if it is not used
+func (s *Segments) release(seg *segment) {
+	seg.refs--
+	if seg.refs > 0 {
+		return
+	}
+	// segment is already out of manifest, so errors do not break index and are ignored
+	_ = seg.index.Close()
+	if seg.remove {
+		_ = os.Remove(filepath.Join(s.dir, seg.Name))
+	}
+}
+
+// writeSegment saves index of docs texts written by write to segment file and opens it
+func (s *Segments) writeSegment(name string, docs int, write func(io.Writer) error) (*segment, error) {
+	path := filepath.Join(s.dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create segment: %w", err)
+	}
+	writer := bufio.NewWriter(f)
+	err = write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("cannot write segment: %w", err)
+	}
+	mapped, err := OpenMapped(path)
+	if err != nil {
+		return nil, err
+	}
+	return &segment{manifestSegment: manifestSegment{Name: name, Docs: docs}, index: mapped, deleted: &Set{}, refs: 1}, nil
+}
+
+// setDeleted replaces indices of deleted texts of segment
+func (seg *segment) setDeleted(deleted []int) {
+	seg.Deleted = deleted
+	seg.deleted = SetFrom(deleted)
+}
+
+// maybeMerge starts merge of adjacent segments in background if there are enough segments of the same level
+func (s *Segments) maybeMerge() {
+	if s.merging || s.closed {
+		return
+	}
+	docs := make([]int, len(s.segments))
+	for i, seg := range s.segments {
+		docs[i] = seg.Docs
+	}
+	start, ok := mergeWindow(docs, MergeFactor)
+	if !ok {
+		return
+	}
+	merged := make([]*segment, MergeFactor)
+	copy(merged, s.segments[start:])
+	// texts deleted during merge are deleted in merged segment after it
+	deleted := make([][]int, len(merged))
+	for i, seg := range merged {
+		seg.refs++
+		deleted[i] = seg.Deleted
+	}
+	s.manifest.Generation++
+	name := segmentName(s.manifest.Generation)
+	s.merging = true
+	s.merges.Add(1)
+	go func() {
+		defer s.merges.Done()
+		seg, err := s.merge(name, merged, deleted)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.merging = false
+		if err == nil {
+			err = s.replace(merged, deleted, seg)
+		}
+		for _, m := range merged {
+			s.release(m)
+		}
+		if err != nil {
+			s.mergeErr = err
+			return
+		}
+		s.maybeMerge()
+	}()
+}
+
+// merge writes texts of segments except deleted ones to a new segment
+func (s *Segments) merge(name string, segments []*segment, deleted [][]int) (*segment, error) {
+	indices := make([]*Index, len(segments))
+	for i, seg := range segments {
+		index, err := ReadBinary(bytes.NewReader(seg.index.data))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read segment %s: %w", seg.Name, err)
+		}
+		index.deleted.PutAll(deleted[i])
+		indices[i] = index.compacted()
+	}
+	index := concatIndices(indices)
+	return s.writeSegment(name, len(index.Titles), index.SaveBinary)
+}
+
+// replace puts merged segment instead of adjacent segments it was made of. Texts deleted in them
+// after start of merge are deleted in merged segment
+func (s *Segments) replace(merged []*segment, deleted [][]int, seg *segment) error {
+	start := 0
+	for start < len(s.segments) && s.segments[start] != merged[0] {
+		start++
+	}
+	// segments may be changed by refresh of manifest, then merged segment is dropped
+	contiguous := start+len(merged) <= len(s.segments)
+	for i := 0; contiguous && i < len(merged); i++ {
+		contiguous = s.segments[start+i] == merged[i]
+	}
+	if !contiguous {
+		seg.remove = true
+		s.release(seg)
+		return fmt.Errorf("merged segments %s are not in list of segments anymore", merged[0].Name)
+	}
+	res := make([]int, 0)
+	// index of the first text of each segment in merged one
+	offset := 0
+	for i, m := range merged {
+		before := SetFrom(deleted[i]).SortedKeys()
+		for _, doc := range m.Deleted[len(deleted[i]):] {
+			res = append(res, offset+doc-sort.SearchInts(before, doc))
+		}
+		offset += m.Docs - len(deleted[i])
+	}
+	seg.setDeleted(res)
+	old := s.segments
+	s.segments = make([]*segment, 0, len(old)-len(merged)+1)
+	s.segments = append(append(append(s.segments, old[:start]...), seg), old[start+len(merged):]...)
+	if err := s.saveManifest(); err != nil {
+		s.segments = old
+		seg.remove = true
+		s.release(seg)
+		return err
+	}
+	for _, m := range merged {
+		m.remove = true
+		s.release(m)
+	}
+	return nil
+}
+
+// segmentName returns name of file of segment with generation
+func segmentName(generation int) string {
+	return fmt.Sprintf("%08d%s", generation, segmentExt)
+}
+
+// segmentLevel returns integer part of logarithm of number of texts by factor
+func segmentLevel(docs int, factor int) int {
+	level := 0
+	for docs >= factor {
+		docs /= factor
+		level++
+	}
+	return level
+}
+
+// mergeWindow returns start of run of factor adjacent segments with the same level, the lowest level first
+func mergeWindow(docs []int, factor int) (int, bool) {
+	best, bestLevel := -1, 0
+	for start := 0; start+factor <= len(docs); start++ {
+		level := segmentLevel(docs[start], factor)
+		same := true
+		for _, n := range docs[start+1 : start+factor] {
+			if segmentLevel(n, factor) != level {
+				same = false
+				break
+			}
+		}
+		if same && (best == -1 || level < bestLevel) {
+			best, bestLevel = start, level
+		}
+	}
+	return best, best != -1
+}
+
+// concatIndices joins indices in one, texts of each index follow texts of the previous one
+func concatIndices(indices []*Index) *Index {
+	res := Index{Analyzer: indices[0].Analyzer, Data: make(map[string]Postings)}
+	hasTexts := false
+	for _, index := range indices {
+		hasTexts = hasTexts || len(index.Texts) > 0
+	}
+	for _, index := range indices {
+		offset := len(res.Titles)
+		for word, postings := range index.Data {
+			merged, ok := res.Data[word]
+			if !ok {
+				merged = make(Postings, len(postings))
+				res.Data[word] = merged
+			}
+			for doc, positions := range postings {
+				merged[doc+offset] = positions
+			}
+		}
+		res.Titles = append(res.Titles, index.Titles...)
+		res.Lengths = append(res.Lengths, index.Lengths...)
+		if hasTexts {
+			// texts missing in older segments are empty
+			texts := make([]string, len(index.Titles))
+			copy(texts, index.Texts)
+			res.Texts = append(res.Texts, texts...)
+		}
+	}
+	res.sortTerms()
+	return &res
+}
+
+// multiSource searches in several sources as in one. Indices of texts of each source follow the previous source
+type multiSource struct {
+	sources []source
+	// index of the first text of each source
+	offsets []int
+	docs    int
+	// version of segments the source is created from
+	textsVersion int64
+}
+
+// newMultiSource creates source of sources with numbers of texts docs
+func newMultiSource(sources []source, docs []int) multiSource {
+	s := multiSource{sources: sources, offsets: make([]int, len(sources))}
+	for i, n := range docs {
+		s.offsets[i] = s.docs
+		s.docs += n
+	}
+	return s
+}
+
+// locate returns source of text and index of text in it
+func (s multiSource) locate(index int) (source, int, error) {
+	i := sort.Search(len(s.offsets), func(i int) bool {
+		return s.offsets[i] > index
+	}) - 1
+	if i < 0 || index >= s.docs {
+		return nil, 0, fmt.Errorf("invalid index of text %d", index)
+	}
+	return s.sources[i], index - s.offsets[i], nil
+}
+
+func (s multiSource) postings(word string) (Postings, error) {
+	res := Postings{}
+	for i, src := range s.sources {
+		postings, err := src.postings(word)
+		if err != nil {
+			return nil, err
+		}
+		for doc, positions := range postings {
+			res[doc+s.offsets[i]] = positions
+		}
+	}
+	return res, nil
+}
+
+func (s multiSource) stats() (int, float64, error) {
+	// number of texts may be less than s.docs if some of them are deleted
+	n, total := 0, 0.0
+	for i, src := range s.sources {
+		docs, avgLength, err := src.stats()
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot get stats of segment %d: %w", i, err)
+		}
+		// average length is rounded back to total length, so stats are the same as of one index
+		total += math.Round(avgLength * float64(docs))
+		n += docs
+	}
+	if n == 0 {
+		return 0, 0, nil
+	}
+	return n, total / float64(n), nil
+}
+
+func (s multiSource) doc(index int) (string, int, error) {
+	src, i, err := s.locate(index)
+	if err != nil {
+		return "", 0, err
+	}
+	return src.doc(i)
+}
+
+func (s multiSource) text(index int) (string, error) {
+	src, i, err := s.locate(index)
+	if err != nil {
+		return "", err
+	}
+	return src.text(i)
+}
+
+func (s multiSource) all() (*Set, error) {
+	res := Set{}
+	for i, src := range s.sources {
+		docs, err := src.all()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs.SortedKeys() {
+			res.Put(doc + s.offsets[i])
+		}
+	}
+	return &res, nil
+}
+
+func (s multiSource) analyzer() (Analyzer, error) {
+	if len(s.sources) == 0 {
+		return DefaultAnalyzer(), nil
+	}
+	return s.sources[0].analyzer()
+}
+
+// expand joins the most frequent words of each source and ranks them by number of texts in all sources
+func (s multiSource) expand(pattern string, limit int) ([]string, error) {
+	found := make(map[string]bool)
+	res := make([]string, 0)
+	for _, src := range s.sources {
+		words, err := src.expand(pattern, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, word := range words {
+			if !found[word] {
+				found[word] = true
+				res = append(res, word)
+			}
+		}
+	}
+	if len(s.sources) < 2 {
+		return res, nil
+	}
+	frequencies := make(map[string]int, len(res))
+	for _, word := range res {
+		postings, err := s.postings(word)
+		if err != nil {
+			return nil, err
+		}
+		frequencies[word] = len(postings)
+	}
+	return mostFrequent(res, func(word string) int {
+		return frequencies[word]
+	}, limit), nil
+}
+
+// words merges sorted words of sources
+func (s multiSource) words(min int, max int) ([]string, error) {
+	res := make([]string, 0)
+	for _, src := range s.sources {
+		words, err := src.words(min, max)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, words...)
+	}
+	sort.Strings(res)
+	unique := res[:0]
+	for _, word := range res {
+		if len(unique) == 0 || word != unique[len(unique)-1] {
+			unique = append(unique, word)
+		}
+	}
+	return unique, nil
+}
+
+func (s multiSource) version() (int64, error) {
+	return s.textsVersion, nil
+}
+
+func (s multiSource) frequencies() (map[string]int, error) {
+	res := make(map[string]int)
+	for _, src := range s.sources {
+		frequencies, err := src.frequencies()
+		if err != nil {
+			return nil, err
+		}
+		for word, df := range frequencies {
+			res[word] += df
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,267 @@
+package revindex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tempDir creates temporary dir and returns its path and function removing it
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "revindex")
+	if err != nil {
+		t.Fatal("Cannot create dir:", err)
+	}
+	return dir, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// addTexts builds index of texts and adds it to segments as a new segment
+func addTexts(t *testing.T, segments *Segments, texts []string, titles []string) {
+	index, err := Build(texts, titles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	if err = segments.Add(&index); err != nil {
+		t.Fatal("Cannot add segment:", err)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	texts := []string{
+		"search engine for texts",
+		"searching texts in index",
+		"database index",
+		"engine of a car",
+		"index of a database",
+	}
+	titles := []string{"se", "st", "db", "car", "idx"}
+	index, err := Build(texts, titles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	dir, remove := tempDir(t)
+	defer remove()
+	segments, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	defer segments.Close()
+	addTexts(t, segments, texts[:2], titles[:2])
+	addTexts(t, segments, texts[2:3], titles[2:3])
+	addTexts(t, segments, texts[3:], titles[3:])
+
+	for _, q := range []string{"index", "texts -engine", "\"search engine\"", "search* OR db?", "enigne~", "NOT index", "unknown"} {
+		t.Run(q, func(t *testing.T) {
+			exp, err := index.Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			act, err := segments.Searcher().Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("exp=", exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("suggestions", func(t *testing.T) {
+		suggester, err := segments.Searcher().Suggester()
+		if err != nil {
+			t.Fatal("Cannot create suggester:", err)
+		}
+		if act, _ := suggester.Suggest("serch"); act != "search" {
+			t.Fatal("Wrong suggestion:", act)
+		}
+	})
+
+	t.Run("other analyzer", func(t *testing.T) {
+		other, err := BuildWithAnalyzer([]string{"text"}, []string{"t"}, mustParseAnalyzer(t, "letters"))
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		err = segments.Add(&other)
+		t.Log("err:", err)
+		if err == nil {
+			t.Fatal("Add must return an error")
+		}
+	})
+}
+
+func TestSegments_Merge(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	segments, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	// reader opened before texts are added must see them
+	reader, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	defer reader.Close()
+	for i := 0; i <= MergeFactor; i++ {
+		addTexts(t, segments, []string{fmt.Sprintf("text number%d", i)}, []string{fmt.Sprint(i)})
+	}
+	// close waits for merge
+	if err = segments.Close(); err != nil {
+		t.Fatal("Merge failed:", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal("Cannot list segments:", err)
+	}
+	t.Log("files:", files)
+	if len(files) != 2 {
+		t.Fatal("Wrong number of segment files")
+	}
+	res, err := reader.Searcher().Find("text", DefaultBM25)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	if len(res) != MergeFactor+1 {
+		t.Fatal("Wrong number of results:", len(res))
+	}
+	res, err = reader.Searcher().Find(fmt.Sprintf("number%d", MergeFactor), DefaultBM25)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	if len(res) != 1 || res[0].Title != fmt.Sprint(MergeFactor) {
+		t.Fatal("Wrong result:", res)
+	}
+}
+
+func TestSegments_Replace(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	segments, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	defer segments.Close()
+	addTexts(t, segments, []string{"old text", "other text"}, []string{"a", "b"})
+
+	builder, err := NewStreamBuilder(DefaultAnalyzer(), 0, 1, "")
+	if err != nil {
+		t.Fatal("Cannot create builder:", err)
+	}
+	if err = builder.Add("a", "new text"); err != nil {
+		t.Fatal("Cannot add text:", err)
+	}
+	version := segments.version
+	n, err := segments.Replace([]string{"a", "missing"}, builder)
+	if err != nil {
+		t.Fatal("Cannot replace texts:", err)
+	}
+	// deleted text and new segment are saved in one update of manifest
+	if n != 1 || segments.version != version+1 || len(segments.segments) != 2 {
+		t.Fatal("Wrong replace:", n, segments.version-version, len(segments.segments))
+	}
+	for q, exp := range map[string][]string{"old": {}, "new": {"a"}, "text": {"a", "b"}} {
+		t.Run(q, func(t *testing.T) {
+			res, err := segments.Searcher().Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			act := make([]string, 0)
+			for _, r := range res {
+				act = append(act, r.Title)
+			}
+			t.Log("exp=", exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("empty builder", func(t *testing.T) {
+		builder, err := NewStreamBuilder(DefaultAnalyzer(), 0, 1, "")
+		if err != nil {
+			t.Fatal("Cannot create builder:", err)
+		}
+		n, err := segments.Replace([]string{"b"}, builder)
+		if err != nil || n != 1 || len(segments.segments) != 2 {
+			t.Fatal("Wrong replace:", n, err, len(segments.segments))
+		}
+	})
+}
+
+func TestSegments_replace(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	segments, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal("Cannot open segments:", err)
+	}
+	defer segments.Close()
+	addTexts(t, segments, []string{"first text"}, []string{"1"})
+	addTexts(t, segments, []string{"second text"}, []string{"2"})
+	index, err := Build([]string{"first text", "second text"}, []string{"1", "2"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	list := segments.segments
+	gone := &segment{manifestSegment: manifestSegment{Name: "gone"}}
+	tests := map[string][]*segment{
+		"missing segment":       {gone, list[1]},
+		"segments not in order": {list[1], list[0]},
+		"too many segments":     {list[1], gone},
+	}
+	for name, merged := range tests {
+		t.Run(name, func(t *testing.T) {
+			segments.mu.Lock()
+			defer segments.mu.Unlock()
+			seg, err := segments.writeSegment("merged"+segmentExt, len(index.Titles), index.SaveBinary)
+			if err != nil {
+				t.Fatal("Cannot write segment:", err)
+			}
+			err = segments.replace(merged, make([][]int, len(merged)), seg)
+			t.Log("err:", err)
+			if err == nil {
+				t.Fatal("Replace must return an error")
+			}
+			if !reflect.DeepEqual(segments.segments, list) {
+				t.Fatal("Segments are changed")
+			}
+			if _, err = os.Stat(filepath.Join(dir, "merged"+segmentExt)); !os.IsNotExist(err) {
+				t.Fatal("Merged segment is not removed:", err)
+			}
+		})
+	}
+}
+
+func TestMergeWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		docs  []int
+		start int
+		ok    bool
+	}{
+		{name: "too few segments", docs: []int{1, 1}, ok: false},
+		{name: "small segments", docs: []int{1, 2, 1}, start: 0, ok: true},
+		{name: "different levels", docs: []int{1, 5, 2}, ok: false},
+		{name: "lowest level first", docs: []int{3, 4, 5, 1, 2, 1}, start: 3, ok: true},
+		{name: "the first run", docs: []int{9, 1, 1, 2, 1}, start: 1, ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, ok := mergeWindow(test.docs, 3)
+			t.Log("exp=", test.start, test.ok)
+			t.Log("act=", start, ok)
+			if ok != test.ok || (ok && start != test.start) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
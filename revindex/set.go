@@ -1,36 +1,403 @@
 package revindex
 
-import "sort"
+import (
+	"math/bits"
+	"sort"
+)
 
-// Empty struct needs zero bytes
-type Void struct{}
+const (
+	// values of container share all bits except the low ones
+	containerBits = 16
+	// array container with more values is converted to bitmap
+	arrayMaxSize = 4096
+	// number of words in bitmap container
+	bitmapWords = 1 << containerBits / 64
+)
 
-// Set to store file indexes
-type Set map[int]Void
+// Set to store file indexes. It is a roaring bitmap: values are split into containers by high bits,
+// sparse containers keep sorted low bits, dense containers keep bitmap of them.
+// Zero value is an empty set
+type Set struct {
+	// sorted high bits of values
+	keys       []int
+	containers []container
+}
 
 func (s *Set) Put(val int) {
-	(*s)[val] = Void{}
+	key, low := val>>containerBits, uint16(val)
+	// values are usually added in ascending order, so the last container is checked first
+	i := len(s.keys) - 1
+	if i < 0 || s.keys[i] != key {
+		i = sort.SearchInts(s.keys, key)
+		if i == len(s.keys) || s.keys[i] != key {
+			s.keys = append(s.keys, 0)
+			copy(s.keys[i+1:], s.keys[i:])
+			s.keys[i] = key
+			s.containers = append(s.containers, container{})
+			copy(s.containers[i+1:], s.containers[i:])
+			s.containers[i] = container{}
+		}
+	}
+	s.containers[i].add(low)
 }
 
 func (s *Set) PutAll(values []int) {
-	for _, val := range values {
+	sorted := make([]int, len(values))
+	copy(sorted, values)
+	sort.Ints(sorted)
+	for _, val := range sorted {
 		s.Put(val)
 	}
 }
 
 func (s *Set) SortedKeys() []int {
-	keys := make([]int, 0, len(*s))
-	for key := range *s {
-		keys = append(keys, key)
+	keys := make([]int, 0, s.Len())
+	for i, key := range s.keys {
+		keys = s.containers[i].appendValues(keys, key<<containerBits)
 	}
-	sort.Ints(keys)
 	return keys
 }
 
+func (s *Set) Contains(val int) bool {
+	key := val >> containerBits
+	i := sort.SearchInts(s.keys, key)
+	return i < len(s.keys) && s.keys[i] == key && s.containers[i].contains(uint16(val))
+}
+
+func (s *Set) Len() int {
+	n := 0
+	for i := range s.containers {
+		n += s.containers[i].len()
+	}
+	return n
+}
+
+// And returns intersection of sets
+func (s *Set) And(other *Set) *Set {
+	res := Set{}
+	for i, j := 0, 0; i < len(s.keys) && j < len(other.keys); {
+		switch {
+		case s.keys[i] < other.keys[j]:
+			i++
+		case s.keys[i] > other.keys[j]:
+			j++
+		default:
+			res.append(s.keys[i], s.containers[i].and(&other.containers[j]))
+			i++
+			j++
+		}
+	}
+	return &res
+}
+
+// Or returns union of sets
+func (s *Set) Or(other *Set) *Set {
+	res := Set{
+		keys:       make([]int, 0, len(s.keys)+len(other.keys)),
+		containers: make([]container, 0, len(s.keys)+len(other.keys)),
+	}
+	i, j := 0, 0
+	for i < len(s.keys) && j < len(other.keys) {
+		switch {
+		case s.keys[i] < other.keys[j]:
+			res.append(s.keys[i], s.containers[i].clone())
+			i++
+		case s.keys[i] > other.keys[j]:
+			res.append(other.keys[j], other.containers[j].clone())
+			j++
+		default:
+			res.append(s.keys[i], s.containers[i].or(&other.containers[j]))
+			i++
+			j++
+		}
+	}
+	for ; i < len(s.keys); i++ {
+		res.append(s.keys[i], s.containers[i].clone())
+	}
+	for ; j < len(other.keys); j++ {
+		res.append(other.keys[j], other.containers[j].clone())
+	}
+	return &res
+}
+
+// AndNot returns values of set that are not in other set
+func (s *Set) AndNot(other *Set) *Set {
+	res := Set{}
+	j := 0
+	for i, key := range s.keys {
+		for j < len(other.keys) && other.keys[j] < key {
+			j++
+		}
+		if j < len(other.keys) && other.keys[j] == key {
+			res.append(key, s.containers[i].andNot(&other.containers[j]))
+		} else {
+			res.append(key, s.containers[i].clone())
+		}
+	}
+	return &res
+}
+
+// append adds container with keys greater than keys of set, empty containers are skipped
+func (s *Set) append(key int, c container) {
+	if c.len() > 0 {
+		s.keys = append(s.keys, key)
+		s.containers = append(s.containers, c)
+	}
+}
+
 func SetFrom(values []int) *Set {
 	set := Set{}
-	for _, v := range values {
-		set.Put(v)
-	}
+	set.PutAll(values)
 	return &set
 }
+
+// container keeps low bits of values with the same high bits
+type container struct {
+	// sorted values of sparse container
+	array []uint16
+	// bits of values of dense container, nil for sparse container
+	bitmap []uint64
+	// number of values in bitmap
+	n int
+}
+
+// newBitmapContainer creates container by bitmap, sparse bitmap is converted to array
+func newBitmapContainer(bitmap []uint64) container {
+	n := 0
+	for _, word := range bitmap {
+		n += bits.OnesCount64(word)
+	}
+	c := container{bitmap: bitmap, n: n}
+	if n <= arrayMaxSize {
+		return container{array: c.appendLows(make([]uint16, 0, n))}
+	}
+	return c
+}
+
+func (c *container) len() int {
+	if c.bitmap != nil {
+		return c.n
+	}
+	return len(c.array)
+}
+
+func (c *container) contains(val uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[val/64]&(1<<(val%64)) != 0
+	}
+	i := searchUint16(c.array, val)
+	return i < len(c.array) && c.array[i] == val
+}
+
+func (c *container) add(val uint16) {
+	if c.bitmap != nil {
+		if c.bitmap[val/64]&(1<<(val%64)) == 0 {
+			c.bitmap[val/64] |= 1 << (val % 64)
+			c.n++
+		}
+		return
+	}
+	if n := len(c.array); n == 0 || c.array[n-1] < val {
+		c.array = append(c.array, val)
+	} else {
+		i := searchUint16(c.array, val)
+		if c.array[i] == val {
+			return
+		}
+		c.array = append(c.array, 0)
+		copy(c.array[i+1:], c.array[i:])
+		c.array[i] = val
+	}
+	if len(c.array) > arrayMaxSize {
+		c.bitmap, c.n = c.toBitmap(), len(c.array)
+		c.array = nil
+	}
+}
+
+// toBitmap returns bitmap of values of container
+func (c *container) toBitmap() []uint64 {
+	if c.bitmap != nil {
+		bitmap := make([]uint64, bitmapWords)
+		copy(bitmap, c.bitmap)
+		return bitmap
+	}
+	bitmap := make([]uint64, bitmapWords)
+	for _, val := range c.array {
+		bitmap[val/64] |= 1 << (val % 64)
+	}
+	return bitmap
+}
+
+func (c *container) clone() container {
+	if c.bitmap != nil {
+		return container{bitmap: c.toBitmap(), n: c.n}
+	}
+	array := make([]uint16, len(c.array))
+	copy(array, c.array)
+	return container{array: array}
+}
+
+// appendLows appends sorted low bits of values to res
+func (c *container) appendLows(res []uint16) []uint16 {
+	if c.bitmap == nil {
+		return append(res, c.array...)
+	}
+	for i, word := range c.bitmap {
+		for word != 0 {
+			res = append(res, uint16(i*64+bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	return res
+}
+
+// appendValues appends sorted values of container with high bits to res
+func (c *container) appendValues(res []int, high int) []int {
+	if c.bitmap == nil {
+		for _, val := range c.array {
+			res = append(res, high|int(val))
+		}
+		return res
+	}
+	for i, word := range c.bitmap {
+		for word != 0 {
+			res = append(res, high|(i*64+bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	return res
+}
+
+func (c *container) and(other *container) container {
+	switch {
+	case c.bitmap == nil && other.bitmap == nil:
+		return container{array: andArrays(c.array, other.array)}
+	case c.bitmap == nil:
+		return container{array: filterArray(c.array, other, true)}
+	case other.bitmap == nil:
+		return container{array: filterArray(other.array, c, true)}
+	}
+	bitmap := make([]uint64, bitmapWords)
+	for i := range bitmap {
+		bitmap[i] = c.bitmap[i] & other.bitmap[i]
+	}
+	return newBitmapContainer(bitmap)
+}
+
+func (c *container) or(other *container) container {
+	if c.bitmap == nil && other.bitmap == nil && len(c.array)+len(other.array) <= arrayMaxSize {
+		return container{array: orArrays(c.array, other.array)}
+	}
+	// bitmap of the larger container is copied, values of the smaller one are added to it
+	large, small := c, other
+	if large.bitmap == nil || (small.bitmap != nil && small.n > large.n) {
+		large, small = small, large
+	}
+	bitmap := large.toBitmap()
+	if small.bitmap != nil {
+		for i, word := range small.bitmap {
+			bitmap[i] |= word
+		}
+	} else {
+		for _, val := range small.array {
+			bitmap[val/64] |= 1 << (val % 64)
+		}
+	}
+	return newBitmapContainer(bitmap)
+}
+
+func (c *container) andNot(other *container) container {
+	if c.bitmap == nil {
+		return container{array: filterArray(c.array, other, false)}
+	}
+	bitmap := c.toBitmap()
+	if other.bitmap != nil {
+		for i, word := range other.bitmap {
+			bitmap[i] &^= word
+		}
+	} else {
+		for _, val := range other.array {
+			bitmap[val/64] &^= 1 << (val % 64)
+		}
+	}
+	return newBitmapContainer(bitmap)
+}
+
+// andArrays returns intersection of sorted arrays
+func andArrays(a []uint16, b []uint16) []uint16 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	res := make([]uint16, 0, len(a))
+	// values of much smaller array are searched in the larger one
+	if len(a)*32 < len(b) {
+		for _, val := range a {
+			i := searchUint16(b, val)
+			if i < len(b) && b[i] == val {
+				res = append(res, val)
+			}
+			b = b[i:]
+		}
+		return res
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+// orArrays returns union of sorted arrays
+func orArrays(a []uint16, b []uint16) []uint16 {
+	res := make([]uint16, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			res = append(res, a[i])
+			i++
+		case a[i] > b[j]:
+			res = append(res, b[j])
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	res = append(res, a[i:]...)
+	return append(res, b[j:]...)
+}
+
+// filterArray returns values of array that are contained in container if keep is true, or are not otherwise
+func filterArray(array []uint16, c *container, keep bool) []uint16 {
+	res := make([]uint16, 0, len(array))
+	for _, val := range array {
+		if c.contains(val) == keep {
+			res = append(res, val)
+		}
+	}
+	return res
+}
+
+// searchUint16 returns index of the first value of sorted array not less than val
+func searchUint16(array []uint16, val uint16) int {
+	lo, hi := 0, len(array)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if array[mid] < val {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
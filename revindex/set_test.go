@@ -0,0 +1,136 @@
+package revindex
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// randomValues returns n random values less than max
+func randomValues(r *rand.Rand, n int, max int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = r.Intn(max)
+	}
+	return values
+}
+
+// sortedUnique returns sorted values without duplicates
+func sortedUnique(values []int) []int {
+	seen := make(map[int]bool)
+	res := make([]int, 0)
+	for _, val := range values {
+		if !seen[val] {
+			seen[val] = true
+			res = append(res, val)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+func TestSet(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name string
+		a    []int
+		b    []int
+	}{
+		{name: "empty sets", a: []int{}, b: []int{}},
+		{name: "empty and not empty", a: []int{}, b: []int{1, 2, 3}},
+		{name: "small sets", a: []int{5, 1, 3, 70000}, b: []int{3, 4, 5, 1 << 20}},
+		{name: "sparse sets", a: randomValues(r, 1000, 1<<24), b: randomValues(r, 1000, 1<<24)},
+		{name: "dense sets", a: randomValues(r, 50000, 1<<17), b: randomValues(r, 50000, 1<<17)},
+		{name: "dense and sparse", a: randomValues(r, 50000, 1<<17), b: randomValues(r, 100, 1<<17)},
+		{name: "bitmaps becoming arrays", a: randomValues(r, 6000, 1<<16), b: randomValues(r, 6000, 1<<16)},
+		{name: "negative values", a: []int{-1, -70000, 0, 5}, b: []int{-70000, 5, 6}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// expected results are computed with map
+			inA := make(map[int]bool)
+			for _, val := range test.a {
+				inA[val] = true
+			}
+			inB := make(map[int]bool)
+			for _, val := range test.b {
+				inB[val] = true
+			}
+			and, or, andNot := make([]int, 0), make([]int, 0), make([]int, 0)
+			for _, val := range sortedUnique(append(append([]int{}, test.a...), test.b...)) {
+				if inA[val] && inB[val] {
+					and = append(and, val)
+				}
+				if inA[val] && !inB[val] {
+					andNot = append(andNot, val)
+				}
+				or = append(or, val)
+			}
+
+			a := Set{}
+			for _, val := range test.a {
+				a.Put(val)
+			}
+			b := SetFrom(test.b)
+			check := func(t *testing.T, name string, set *Set, exp []int) {
+				act := set.SortedKeys()
+				if !reflect.DeepEqual(act, exp) || set.Len() != len(exp) {
+					t.Log("exp=", len(exp), exp)
+					t.Log("act=", set.Len(), act)
+					t.Fatal("Wrong result of", name)
+				}
+			}
+			check(t, "Put", &a, sortedUnique(test.a))
+			check(t, "PutAll", b, sortedUnique(test.b))
+			check(t, "And", a.And(b), and)
+			check(t, "Or", a.Or(b), or)
+			check(t, "AndNot", a.AndNot(b), andNot)
+			for val := range inB {
+				if a.Contains(val) != inA[val] {
+					t.Fatal("Wrong result of Contains for", val)
+				}
+			}
+		})
+	}
+
+	t.Run("results do not share containers", func(t *testing.T) {
+		a := SetFrom([]int{1, 2})
+		b := SetFrom([]int{3})
+		res := a.Or(b)
+		res.Put(4)
+		if a.Contains(4) || b.Contains(4) {
+			t.Fatal("Sets are changed by result")
+		}
+	})
+}
+
+func BenchmarkSet(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	sets := []struct {
+		name string
+		a    *Set
+		b    *Set
+	}{
+		{name: "sparse", a: SetFrom(randomValues(r, 10000, 1<<24)), b: SetFrom(randomValues(r, 10000, 1<<24))},
+		{name: "dense", a: SetFrom(randomValues(r, 500000, 1<<20)), b: SetFrom(randomValues(r, 500000, 1<<20))},
+		{name: "dense and sparse", a: SetFrom(randomValues(r, 500000, 1<<20)), b: SetFrom(randomValues(r, 1000, 1<<20))},
+	}
+	for _, sets := range sets {
+		b.Run("And, "+sets.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sets.a.And(sets.b)
+			}
+		})
+		b.Run("Or, "+sets.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sets.a.Or(sets.b)
+			}
+		})
+		b.Run("AndNot, "+sets.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sets.a.AndNot(sets.b)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package revindex
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Fragment is a part of snippet. Match is true for words of query
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// Snippet is a part of text around the best matching words of query
+type Snippet []Fragment
+
+// Highlight returns text of snippet with matching words wrapped into open and close
+func (s Snippet) Highlight(open string, close string) string {
+	var b strings.Builder
+	for _, f := range s {
+		if f.Match {
+			b.WriteString(open + f.Text + close)
+		} else {
+			b.WriteString(f.Text)
+		}
+	}
+	return b.String()
+}
+
+const (
+	// number of words in snippet
+	snippetWords = 30
+	// number of words before the first match in snippet
+	snippetContext = 5
+	// marks text cut from snippet
+	ellipsis = "…"
+)
+
+// makeSnippet returns part of text with the most different terms in window of snippetWords words.
+// Text without terms is cut from the start
+func makeSnippet(text string, analyzer Analyzer, terms map[string]bool) Snippet {
+	tokens := analyzer.Analyze(text)
+	if len(tokens) == 0 {
+		return nil
+	}
+	// find window with the most different terms, then with the most matches
+	windowStart := tokens[0].Position
+	bestTerms, bestMatches := 0, 0
+	for i, token := range tokens {
+		if !terms[token.Term] {
+			continue
+		}
+		start := token.Position - snippetContext
+		found := make(map[string]bool)
+		matches := 0
+		for _, t := range tokens[i:] {
+			if t.Position >= start+snippetWords {
+				break
+			}
+			if terms[t.Term] {
+				found[t.Term] = true
+				matches++
+			}
+		}
+		if len(found) > bestTerms || (len(found) == bestTerms && matches > bestMatches) {
+			windowStart, bestTerms, bestMatches = start, len(found), matches
+		}
+	}
+	// split text of window into fragments
+	res := make(Snippet, 0)
+	add := func(text string, match bool) {
+		text = collapseSpaces(text)
+		if text == "" {
+			return
+		}
+		if n := len(res); n > 0 && !res[n-1].Match && !match {
+			res[n-1].Text += text
+			return
+		}
+		res = append(res, Fragment{Text: text, Match: match})
+	}
+	// indices of the first and the last tokens of window
+	first, last := -1, 0
+	for i, token := range tokens {
+		if token.Position >= windowStart+snippetWords {
+			break
+		}
+		if token.Position >= windowStart {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	// punctuation around text is kept, cut words are replaced with ellipsis
+	begin, end := 0, len(text)
+	if first > 0 {
+		begin = tokens[first].Start
+		add(ellipsis+" ", false)
+	}
+	if last < len(tokens)-1 {
+		end = tokens[last].End
+	}
+	for _, token := range tokens[first : last+1] {
+		if terms[token.Term] {
+			add(text[begin:token.Start], false)
+			add(text[token.Start:token.End], true)
+			begin = token.End
+		}
+	}
+	add(text[begin:end], false)
+	if end < len(text) {
+		add(" "+ellipsis, false)
+	}
+	return res
+}
+
+// collapseSpaces replaces sequences of spaces and new lines with single space
+func collapseSpaces(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteRune(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteRune(' ')
+	}
+	return b.String()
+}
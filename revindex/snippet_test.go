@@ -0,0 +1,71 @@
+package revindex
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMakeSnippet(t *testing.T) {
+	t.Run("whole text", func(t *testing.T) {
+		act := makeSnippet("Search\n engines  index texts.", DefaultAnalyzer(), map[string]bool{"search": true, "texts": true})
+		exp := Snippet{{Text: "Search", Match: true}, {Text: " engines index "}, {Text: "texts", Match: true}, {Text: "."}}
+		t.Log("exp:", exp)
+		t.Log("act:", act)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("window with the most different terms", func(t *testing.T) {
+		text := "a a " + strings.Repeat("x ", 40) + "b " + strings.Repeat("y ", 10) + "a " + strings.Repeat("z ", 40)
+		act := makeSnippet(text, DefaultAnalyzer(), map[string]bool{"a": true, "b": true})
+		exp := "… x x x x x [b] y y y y y y y y y y [a] z z z z z z z z z z z z z …"
+		t.Log("exp:", exp)
+		t.Log("act:", act.Highlight("[", "]"))
+		if act.Highlight("[", "]") != exp {
+			t.Fatal("Wrong result")
+		}
+	})
+
+	t.Run("text without terms", func(t *testing.T) {
+		act := makeSnippet(strings.Repeat("x ", 40), DefaultAnalyzer(), map[string]bool{"a": true})
+		exp := strings.TrimSpace(strings.Repeat("x ", snippetWords)) + " …"
+		t.Log("exp:", exp)
+		t.Log("act:", act.Highlight("[", "]"))
+		if act.Highlight("[", "]") != exp {
+			t.Fatal("Wrong result")
+		}
+	})
+}
+
+func TestIndex_Find_Snippets(t *testing.T) {
+	index, err := BuildWithAnalyzer([]string{"Running dogs, running cats", "A cat"}, []string{"1", "2"}, mustParseAnalyzer(t, "words|lowercase|stem=en"))
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	act, err := index.Find("run* cat -dog~1", DefaultBM25)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	t.Log("act:", act)
+	if len(act) != 1 || act[0].Snippet.Highlight("<", ">") != "A <cat>" {
+		t.Fatal("Wrong result")
+	}
+	act, err = index.Find("run* cat", DefaultBM25)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	t.Log("act:", act)
+	if act[0].Snippet.Highlight("<", ">") != "<Running> dogs, <running> <cats>" {
+		t.Fatal("Wrong result")
+	}
+}
+
+func mustParseAnalyzer(t *testing.T, spec string) Analyzer {
+	analyzer, err := ParseAnalyzer(spec)
+	if err != nil {
+		t.Fatal("Cannot parse analyzer:", err)
+	}
+	return analyzer
+}
@@ -0,0 +1,281 @@
+package stemmer
+
+import "strings"
+
+// English stems word with Porter2 (Snowball English) algorithm. Word must be in lower case
+func English(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	if stem, ok := englishExceptions[word]; ok {
+		return stem
+	}
+	w := []rune(strings.TrimPrefix(word, "'"))
+	markEnglishY(w)
+	r1, r2 := englishRegions(w)
+
+	w = englishStep0(w)
+	w = englishStep1a(w)
+	if englishInvariantsAfter1a[string(w)] {
+		return unmarkEnglishY(w)
+	}
+	w = englishStep1b(w, r1)
+	w = englishStep1c(w)
+	w = englishStep2(w, r1)
+	w = englishStep3(w, r1, r2)
+	w = englishStep4(w, r2)
+	w = englishStep5(w, r1, r2)
+	return unmarkEnglishY(w)
+}
+
+// words with irregular stems
+var englishExceptions = map[string]string{
+	"skis": "ski", "skies": "sky", "dying": "die", "lying": "lie", "tying": "tie",
+	"idly": "idl", "gently": "gentl", "ugly": "ugli", "early": "earli", "only": "onli", "singly": "singl",
+	"sky": "sky", "news": "news", "howe": "howe", "atlas": "atlas", "cosmos": "cosmos", "bias": "bias",
+	"andes": "andes",
+}
+
+// words that are not changed after step 1a
+var englishInvariantsAfter1a = map[string]bool{
+	"inning": true, "outing": true, "canning": true, "herring": true, "earring": true,
+	"proceed": true, "exceed": true, "succeed": true,
+}
+
+// 'Y' is a consonant 'y'
+func isEnglishVowel(r rune) bool {
+	switch r {
+	case 'a', 'e', 'i', 'o', 'u', 'y':
+		return true
+	}
+	return false
+}
+
+// markEnglishY marks initial 'y' and 'y' after vowel as consonant
+func markEnglishY(w []rune) {
+	for i, r := range w {
+		if r == 'y' && (i == 0 || isEnglishVowel(w[i-1])) {
+			w[i] = 'Y'
+		}
+	}
+}
+
+func unmarkEnglishY(w []rune) string {
+	return strings.Replace(string(w), "Y", "y", -1)
+}
+
+// englishRegions returns starts of R1 and R2 regions
+func englishRegions(w []rune) (int, int) {
+	r1 := -1
+	for _, prefix := range []string{"gener", "commun", "arsen"} {
+		if strings.HasPrefix(string(w), prefix) {
+			r1 = len([]rune(prefix))
+		}
+	}
+	if r1 == -1 {
+		r1 = regionAfter(w, 0, isEnglishVowel)
+	}
+	return r1, regionAfter(w, r1, isEnglishVowel)
+}
+
+// regionAfter returns position after first non-vowel following a vowel starting from start
+func regionAfter(w []rune, start int, isVowel func(rune) bool) int {
+	for i := start + 1; i < len(w); i++ {
+		if !isVowel(w[i]) && isVowel(w[i-1]) {
+			return i + 1
+		}
+	}
+	return len(w)
+}
+
+// longestSuffix returns the longest of suffixes that word ends with
+func longestSuffix(w []rune, suffixes ...string) string {
+	res := ""
+	s := string(w)
+	for _, suffix := range suffixes {
+		if len(suffix) > len(res) && strings.HasSuffix(s, suffix) {
+			res = suffix
+		}
+	}
+	return res
+}
+
+func runeLen(s string) int {
+	return len([]rune(s))
+}
+
+// replaceSuffix replaces suffix of word with replacement
+func replaceSuffix(w []rune, suffix string, replacement string) []rune {
+	return append(w[:len(w)-runeLen(suffix):len(w)-runeLen(suffix)], []rune(replacement)...)
+}
+
+// inRegion checks that suffix starts inside region
+func inRegion(w []rune, suffix string, region int) bool {
+	return len(w)-runeLen(suffix) >= region
+}
+
+func hasVowel(w []rune) bool {
+	for _, r := range w {
+		if isEnglishVowel(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// isShortSyllableAt checks that syllable ends at i, e.g. 'rap', 'trap', 'ow', 'on', 'at'
+func isShortSyllableAt(w []rune, i int) bool {
+	if i == 1 {
+		return isEnglishVowel(w[0]) && !isEnglishVowel(w[1])
+	}
+	if i < 2 || i >= len(w) {
+		return false
+	}
+	c := w[i]
+	return !isEnglishVowel(w[i-2]) && isEnglishVowel(w[i-1]) && !isEnglishVowel(c) &&
+		c != 'w' && c != 'x' && c != 'Y'
+}
+
+// isShortWord checks that word ends with short syllable and R1 is empty
+func isShortWord(w []rune, r1 int) bool {
+	return r1 >= len(w) && isShortSyllableAt(w, len(w)-1)
+}
+
+func englishStep0(w []rune) []rune {
+	if suffix := longestSuffix(w, "'", "'s", "'s'"); suffix != "" {
+		return replaceSuffix(w, suffix, "")
+	}
+	return w
+}
+
+func englishStep1a(w []rune) []rune {
+	switch suffix := longestSuffix(w, "sses", "ied", "ies", "s", "us", "ss"); suffix {
+	case "sses":
+		return replaceSuffix(w, suffix, "ss")
+	case "ied", "ies":
+		if len(w) > 4 {
+			return replaceSuffix(w, suffix, "i")
+		}
+		return replaceSuffix(w, suffix, "ie")
+	case "s":
+		// vowel must not be right before 's'
+		if len(w) > 2 && hasVowel(w[:len(w)-2]) {
+			return replaceSuffix(w, suffix, "")
+		}
+	}
+	return w
+}
+
+func englishStep1b(w []rune, r1 int) []rune {
+	switch suffix := longestSuffix(w, "eed", "eedly", "ed", "edly", "ing", "ingly"); suffix {
+	case "eed", "eedly":
+		if inRegion(w, suffix, r1) {
+			return replaceSuffix(w, suffix, "ee")
+		}
+	case "ed", "edly", "ing", "ingly":
+		stem := w[:len(w)-runeLen(suffix)]
+		if !hasVowel(stem) {
+			return w
+		}
+		if s := longestSuffix(stem, "at", "bl", "iz"); s != "" {
+			return append(stem, 'e')
+		}
+		if d := longestSuffix(stem, "bb", "dd", "ff", "gg", "mm", "nn", "pp", "rr", "tt"); d != "" {
+			return stem[:len(stem)-1]
+		}
+		if isShortWord(stem, r1) {
+			return append(stem, 'e')
+		}
+		return stem
+	}
+	return w
+}
+
+func englishStep1c(w []rune) []rune {
+	n := len(w)
+	if n > 2 && (w[n-1] == 'y' || w[n-1] == 'Y') && !isEnglishVowel(w[n-2]) {
+		w[n-1] = 'i'
+	}
+	return w
+}
+
+var englishStep2Suffixes = map[string]string{
+	"tional": "tion", "enci": "ence", "anci": "ance", "abli": "able", "entli": "ent",
+	"izer": "ize", "ization": "ize", "ational": "ate", "ation": "ate", "ator": "ate",
+	"alism": "al", "aliti": "al", "alli": "al", "fulness": "ful", "ousli": "ous", "ousness": "ous",
+	"iveness": "ive", "iviti": "ive", "biliti": "ble", "bli": "ble", "ogi": "og", "fulli": "ful",
+	"lessli": "less", "li": "",
+}
+
+func englishStep2(w []rune, r1 int) []rune {
+	suffix := longestSuffix(w, keys(englishStep2Suffixes)...)
+	if suffix == "" || !inRegion(w, suffix, r1) {
+		return w
+	}
+	stem := w[:len(w)-runeLen(suffix)]
+	switch suffix {
+	case "ogi":
+		if len(stem) == 0 || stem[len(stem)-1] != 'l' {
+			return w
+		}
+	case "li":
+		if len(stem) == 0 || !strings.ContainsRune("cdeghkmnrt", stem[len(stem)-1]) {
+			return w
+		}
+	}
+	return replaceSuffix(w, suffix, englishStep2Suffixes[suffix])
+}
+
+var englishStep3Suffixes = map[string]string{
+	"tional": "tion", "ational": "ate", "alize": "al", "icate": "ic", "iciti": "ic", "ical": "ic",
+	"ful": "", "ness": "", "ative": "",
+}
+
+func englishStep3(w []rune, r1 int, r2 int) []rune {
+	suffix := longestSuffix(w, keys(englishStep3Suffixes)...)
+	if suffix == "" || !inRegion(w, suffix, r1) {
+		return w
+	}
+	if suffix == "ative" && !inRegion(w, suffix, r2) {
+		return w
+	}
+	return replaceSuffix(w, suffix, englishStep3Suffixes[suffix])
+}
+
+func englishStep4(w []rune, r2 int) []rune {
+	suffix := longestSuffix(w, "al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+		"ent", "ism", "ate", "iti", "ous", "ive", "ize", "ion")
+	if suffix == "" || !inRegion(w, suffix, r2) {
+		return w
+	}
+	if suffix == "ion" {
+		n := len(w) - 3
+		if n == 0 || (w[n-1] != 's' && w[n-1] != 't') {
+			return w
+		}
+	}
+	return replaceSuffix(w, suffix, "")
+}
+
+func englishStep5(w []rune, r1 int, r2 int) []rune {
+	n := len(w)
+	switch {
+	case n > 0 && w[n-1] == 'e':
+		if inRegion(w, "e", r2) || (inRegion(w, "e", r1) && !isShortSyllableAt(w[:n-1], n-2)) {
+			return w[:n-1]
+		}
+	case n > 1 && w[n-1] == 'l' && w[n-2] == 'l':
+		if inRegion(w, "l", r2) {
+			return w[:n-1]
+		}
+	}
+	return w
+}
+
+func keys(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	return res
+}
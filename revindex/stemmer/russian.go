@@ -0,0 +1,130 @@
+package stemmer
+
+import "strings"
+
+// Russian stems word with Snowball Russian algorithm. Word must be in lower case
+func Russian(word string) string {
+	w := []rune(strings.Replace(word, "ё", "е", -1))
+	rv := russianRV(w)
+	_, r2 := russianRegions(w)
+
+	// all changes are made in RV region
+	stem := russianStep1(w, rv)
+	// step 2: remove 'и'
+	if len(stem) > rv && stem[len(stem)-1] == 'и' {
+		stem = stem[:len(stem)-1]
+	}
+	// step 3: remove derivational suffix in R2
+	if suffix := longestSuffix(stem, "ост", "ость"); suffix != "" && inRegion(stem, suffix, r2) && inRegion(stem, suffix, rv) {
+		stem = replaceSuffix(stem, suffix, "")
+	}
+	return string(russianStep4(stem, rv))
+}
+
+func isRussianVowel(r rune) bool {
+	return strings.ContainsRune("аеиоуыэюя", r)
+}
+
+// russianRV returns start of region after the first vowel
+func russianRV(w []rune) int {
+	for i, r := range w {
+		if isRussianVowel(r) {
+			return i + 1
+		}
+	}
+	return len(w)
+}
+
+// russianRegions returns starts of R1 and R2 regions
+func russianRegions(w []rune) (int, int) {
+	r1 := regionAfter(w, 0, isRussianVowel)
+	return r1, regionAfter(w, r1, isRussianVowel)
+}
+
+// suffix group. Suffixes of first group must follow 'а' or 'я'
+type russianGroup struct {
+	afterA []string
+	other  []string
+}
+
+var (
+	russianPerfectiveGerund = russianGroup{
+		afterA: []string{"в", "вши", "вшись"},
+		other:  []string{"ив", "ивши", "ившись", "ыв", "ывши", "ывшись"},
+	}
+	russianAdjective = russianGroup{
+		other: []string{"ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом",
+			"его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею"},
+	}
+	russianParticiple = russianGroup{
+		afterA: []string{"ем", "нн", "вш", "ющ", "щ"},
+		other:  []string{"ивш", "ывш", "ующ"},
+	}
+	russianReflexive = russianGroup{
+		other: []string{"ся", "сь"},
+	}
+	russianVerb = russianGroup{
+		afterA: []string{"ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны",
+			"ть", "ешь", "нно"},
+		other: []string{"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им",
+			"ым", "ен", "ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю"},
+	}
+	russianNoun = russianGroup{
+		other: []string{"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей",
+			"ой", "ий", "й", "иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию",
+			"ью", "ю", "ия", "ья", "я"},
+	}
+)
+
+// remove removes the longest suffix of group in RV region. Returns false if there is no such suffix
+func (g russianGroup) remove(w []rune, rv int) ([]rune, bool) {
+	region := w[rv:]
+	afterA := longestSuffix(region, g.afterA...)
+	other := longestSuffix(region, g.other...)
+	if runeLen(other) >= runeLen(afterA) {
+		if other == "" {
+			return w, false
+		}
+		return replaceSuffix(w, other, ""), true
+	}
+	// the longest suffix must follow 'а' or 'я' in RV
+	i := len(w) - runeLen(afterA) - 1
+	if i < rv || (w[i] != 'а' && w[i] != 'я') {
+		return w, false
+	}
+	return replaceSuffix(w, afterA, ""), true
+}
+
+func russianStep1(w []rune, rv int) []rune {
+	if stem, ok := russianPerfectiveGerund.remove(w, rv); ok {
+		return stem
+	}
+	w, _ = russianReflexive.remove(w, rv)
+	// adjectival is adjective with optional participle before it
+	if stem, ok := russianAdjective.remove(w, rv); ok {
+		stem, _ = russianParticiple.remove(stem, rv)
+		return stem
+	}
+	if stem, ok := russianVerb.remove(w, rv); ok {
+		return stem
+	}
+	stem, _ := russianNoun.remove(w, rv)
+	return stem
+}
+
+func russianStep4(w []rune, rv int) []rune {
+	region := w[rv:]
+	if strings.HasSuffix(string(region), "нн") {
+		return w[:len(w)-1]
+	}
+	if stem, ok := (russianGroup{other: []string{"ейше", "ейш"}}).remove(w, rv); ok {
+		if strings.HasSuffix(string(stem[rv:]), "нн") {
+			stem = stem[:len(stem)-1]
+		}
+		return stem
+	}
+	if strings.HasSuffix(string(region), "ь") {
+		return w[:len(w)-1]
+	}
+	return w
+}
@@ -0,0 +1,38 @@
+package stemmer
+
+import "testing"
+
+func TestEnglish(t *testing.T) {
+	tests := map[string]string{
+		"consign": "consign", "consigned": "consign", "consigning": "consign", "consignment": "consign",
+		"consistency": "consist", "consistently": "consist", "generously": "generous", "generation": "generat",
+		"running": "run", "runs": "run", "happiness": "happi", "caresses": "caress", "ponies": "poni",
+		"ties": "tie", "cries": "cri", "gas": "gas", "gaps": "gap", "kiwis": "kiwi", "agreed": "agre",
+		"feed": "feed", "hopping": "hop", "hoping": "hope", "relational": "relat", "conditional": "condit",
+		"rational": "ration", "triplicate": "triplic", "formative": "format", "hopeful": "hope",
+		"goodness": "good", "fluently": "fluentli", "says": "say", "playing": "play", "abilities": "abil",
+		"communism": "communism", "generosity": "generos", "dying": "die", "news": "news", "exceed": "exceed",
+		"filing": "file", "fizzing": "fizz", "by": "by", "'quoted": "quot", "databases": "databas",
+	}
+	for word, exp := range tests {
+		if act := English(word); act != exp {
+			t.Errorf("English(%s) = %s, expected %s", word, act, exp)
+		}
+	}
+}
+
+func TestRussian(t *testing.T) {
+	tests := map[string]string{
+		"книги": "книг", "книга": "книг", "книгой": "книг", "вагонов": "вагон", "вагоне": "вагон",
+		"важный": "важн", "важнейшие": "важн", "красивая": "красив", "красивые": "красив", "делать": "дела",
+		"делались": "дела", "сделавшись": "сдела", "читающий": "чита", "прочитанный": "прочита",
+		"организации": "организац", "нравственность": "нравствен", "нравственный": "нравствен",
+		"ёлки": "елк", "елка": "елк", "страннейшая": "стран", "мыла": "мыл", "я": "я", "и": "и",
+		"поиска": "поиск", "индексы": "индекс",
+	}
+	for word, exp := range tests {
+		if act := Russian(word); act != exp {
+			t.Errorf("Russian(%s) = %s, expected %s", word, act, exp)
+		}
+	}
+}
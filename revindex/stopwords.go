@@ -0,0 +1,159 @@
+package revindex
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// built-in stopwords of languages, based on Snowball lists
+var languageStopwords = map[string][]string{
+	"en": strings.Fields(`
+		i me my myself we our ours ourselves you your yours yourself yourselves he him his himself she her hers
+		herself it its itself they them their theirs themselves what which who whom this that these those am is are
+		was were be been being have has had having do does did doing would should could ought i'm you're he's
+		she's it's we're they're i've you've we've they've i'd you'd he'd she'd we'd they'd i'll you'll he'll
+		she'll we'll they'll isn't aren't wasn't weren't hasn't haven't hadn't doesn't don't didn't won't wouldn't
+		shan't shouldn't can't cannot couldn't mustn't let's that's who's what's here's there's when's where's
+		why's how's a an the and but if or because as until while of at by for with about against between into
+		through during before after above below to from up down in out on off over under again further then once
+		here there when where why how all any both each few more most other some such no nor not only own same so
+		than too very`),
+	"ru": strings.Fields(`
+		и в во не что он на я с со как а то все она так его но да ты к у же вы за бы по только ее её мне было вот
+		от меня еще ещё нет о из ему теперь когда даже ну вдруг ли если уже или ни быть был него до вас нибудь
+		опять уж вам ведь там потом себя ничего ей может они тут где есть надо ней для мы тебя их чем была сам
+		чтоб без будто чего раз тоже себе под будет ж тогда кто этот того потому этого какой совсем ним здесь
+		этом один почти мой тем чтобы нее неё сейчас были куда зачем всех никогда можно при наконец два об другой
+		хоть после над больше тот через эти нас про всего них какая много разве три эту моя впрочем хорошо свою
+		этой перед иногда лучше чуть том нельзя такой им более всегда конечно всю между`),
+}
+
+// StopFilter removes frequent words that do not help searching, e.g. 'the', 'и', 'в'.
+// Removed words keep their positions, so phrases with stopwords still match. Terms must be in lower case
+type StopFilter struct {
+	Words map[string]bool
+}
+
+// parseStopFilter creates filter with built-in stopwords of comma separated languages
+func parseStopFilter(arg string) (TokenFilter, error) {
+	filter := StopFilter{Words: make(map[string]bool)}
+	for _, language := range strings.Split(arg, ",") {
+		words, ok := languageStopwords[language]
+		if !ok {
+			return nil, fmt.Errorf("unknown language '%s'", language)
+		}
+		for _, word := range words {
+			filter.Words[word] = true
+		}
+	}
+	return filter, nil
+}
+
+// newStopwordsFilter creates filter with stopwords
+func newStopwordsFilter(words []string) StopFilter {
+	filter := StopFilter{Words: make(map[string]bool, len(words))}
+	for _, word := range words {
+		filter.Words[word] = true
+	}
+	return filter
+}
+
+func (f StopFilter) Filter(tokens []Token) []Token {
+	res := tokens[:0]
+	for _, token := range tokens {
+		if !f.Words[token.Term] {
+			res = append(res, token)
+		}
+	}
+	return res
+}
+
+// ReadStopwords reads words separated by spaces or new lines. Lines starting with '#' are comments.
+// Words are lowercased and trimmed of punctuation
+func ReadStopwords(reader io.Reader) ([]string, error) {
+	analyzer := Pipeline{Tokenizer: WordsTokenizer{}, Filters: []TokenFilter{LowercaseFilter{}}}
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, token := range analyzer.Analyze(line) {
+			words = append(words, token.Term)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read stopwords: %w", err)
+	}
+	return words, nil
+}
+
+// WithStopwords adds filter of stopwords to analyzer spec before stemming, so stopwords are compared
+// with words as they are written in text. Spec refers to stopwords by their hash, so analyzer must be created
+// by ParseAnalyzerWithStopwords with the same words
+func WithStopwords(spec string, words []string) (string, error) {
+	if len(words) == 0 {
+		return spec, nil
+	}
+	if hasStopwords(spec) {
+		return "", errors.New("analyzer already has stopwords")
+	}
+	parts := strings.Split(spec, "|")
+	filter := "stopwords=" + stopwordsHash(words)
+	for i, part := range parts {
+		if name, _ := splitSpecPart(part); name == "stem" {
+			parts = append(parts[:i], append([]string{filter}, parts[i:]...)...)
+			return strings.Join(parts, "|"), nil
+		}
+	}
+	return spec + "|" + filter, nil
+}
+
+// hasStopwords checks that analyzer spec refers to stopwords
+func hasStopwords(spec string) bool {
+	for _, part := range strings.Split(spec, "|") {
+		if name, _ := splitSpecPart(part); name == "stopwords" {
+			return true
+		}
+	}
+	return false
+}
+
+// stopwordsHash returns hash of unique stopwords, it refers to them in analyzer spec
+func stopwordsHash(words []string) string {
+	hash := sha256.New()
+	for _, word := range uniqueStopwords(words) {
+		hash.Write([]byte(word))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// uniqueStopwords returns sorted stopwords without duplicates
+func uniqueStopwords(words []string) []string {
+	unique := make(map[string]bool, len(words))
+	res := make([]string, 0, len(words))
+	for _, word := range words {
+		if !unique[word] {
+			unique[word] = true
+			res = append(res, word)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// analyzerStopwords returns stopwords referred by spec of analyzer, they are saved apart from spec
+func analyzerStopwords(analyzer Analyzer) []string {
+	if a, ok := analyzer.(interface{ Stopwords() []string }); ok {
+		return a.Stopwords()
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package revindex
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStopFilter(t *testing.T) {
+	spec, err := WithStopwords("words|lowercase|stop=en,ru", []string{"foo"})
+	if err != nil {
+		t.Fatal("Cannot add stopwords:", err)
+	}
+	analyzer, err := ParseAnalyzerWithStopwords(spec, []string{"foo"})
+	if err != nil {
+		t.Fatal("Cannot parse analyzer:", err)
+	}
+	act := analyzer.Analyze("The bank of America и Foo bar")
+	exp := []Token{
+		{Term: "bank", Position: 1, Start: 4, End: 8},
+		{Term: "america", Position: 3, Start: 12, End: 19},
+		{Term: "bar", Position: 6, Start: 27, End: 30},
+	}
+	t.Log("exp:", exp)
+	t.Log("act:", act)
+	if !reflect.DeepEqual(act, exp) {
+		t.Fatal("Wrong result")
+	}
+}
+
+func TestIndex_Find_Stopwords(t *testing.T) {
+	spec, err := LanguageAnalyzerSpec("en")
+	if err != nil {
+		t.Fatal("Cannot get spec:", err)
+	}
+	analyzer, err := ParseAnalyzer(spec)
+	if err != nil {
+		t.Fatal("Cannot parse analyzer:", err)
+	}
+	texts := []string{"the bank of america", "america has a bank", "the the the"}
+	index, err := BuildWithAnalyzer(texts, []string{"1", "2", "3"}, analyzer)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	if _, ok := index.Data["the"]; ok {
+		t.Fatal("Stopwords must not be indexed")
+	}
+	if !reflect.DeepEqual(index.Lengths, []int{2, 2, 0}) {
+		t.Fatal("Lengths must not include stopwords:", index.Lengths)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		exp   []string
+	}{
+		{name: "phrase with stopword", query: "\"bank of america\"", exp: []string{"1"}},
+		{name: "phrase with other stopword in the same place", query: "\"banks for america\"", exp: []string{"1"}},
+		{name: "phrase without stopword", query: "\"bank america\"", exp: []string{}},
+		{name: "only stopwords", query: "the OR a", exp: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := index.Find(test.query, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("act:", act)
+			if !reflect.DeepEqual(resultTitles(act), test.exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
+
+func TestReadStopwords(t *testing.T) {
+	words, err := ReadStopwords(strings.NewReader("# comment\nFoo, bar\n\nbaz\n"))
+	if err != nil {
+		t.Fatal("Cannot read stopwords:", err)
+	}
+	if !reflect.DeepEqual(words, []string{"foo", "bar", "baz"}) {
+		t.Fatal("Wrong result:", words)
+	}
+}
+
+func TestWithStopwords(t *testing.T) {
+	hash := stopwordsHash([]string{"bar", "foo"})
+	tests := []struct {
+		spec string
+		exp  string
+	}{
+		{spec: "words|lowercase", exp: "words|lowercase|stopwords=" + hash},
+		{spec: "words|lowercase|stem=en", exp: "words|lowercase|stopwords=" + hash + "|stem=en"},
+	}
+	for _, test := range tests {
+		act, err := WithStopwords(test.spec, []string{"foo", "bar", "foo"})
+		if err != nil {
+			t.Fatal("Cannot add stopwords:", err)
+		}
+		if act != test.exp {
+			t.Fatalf("Wrong spec %s, expected %s", act, test.exp)
+		}
+	}
+	if _, err := WithStopwords("words|stopwords="+hash, []string{"baz"}); err == nil {
+		t.Fatal("Adding stopwords twice must return an error")
+	}
+}
+
+func TestParseAnalyzerWithStopwords(t *testing.T) {
+	spec, err := WithStopwords("words|lowercase", []string{"foo", "bar"})
+	if err != nil {
+		t.Fatal("Cannot add stopwords:", err)
+	}
+	t.Run("same words", func(t *testing.T) {
+		analyzer, err := ParseAnalyzerWithStopwords(spec, []string{"bar", "foo", "bar"})
+		if err != nil {
+			t.Fatal("Cannot parse analyzer:", err)
+		}
+		if !reflect.DeepEqual(analyzerStopwords(analyzer), []string{"bar", "foo"}) {
+			t.Fatal("Wrong stopwords:", analyzerStopwords(analyzer))
+		}
+	})
+	t.Run("other words", func(t *testing.T) {
+		if _, err := ParseAnalyzerWithStopwords(spec, []string{"foo"}); err == nil {
+			t.Fatal("Stopwords with other hash must return an error")
+		}
+	})
+	t.Run("no words", func(t *testing.T) {
+		if _, err := ParseAnalyzer(spec); err == nil {
+			t.Fatal("Missing stopwords must return an error")
+		}
+	})
+}
+
+func TestIndex_Stopwords_Saved(t *testing.T) {
+	words := []string{"foo", "bar"}
+	spec, err := WithStopwords("words|lowercase", words)
+	if err != nil {
+		t.Fatal("Cannot add stopwords:", err)
+	}
+	analyzer, err := ParseAnalyzerWithStopwords(spec, words)
+	if err != nil {
+		t.Fatal("Cannot parse analyzer:", err)
+	}
+	index, err := BuildWithAnalyzer([]string{"foo baz", "bar qux"}, []string{"1", "2"}, analyzer)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	check := func(t *testing.T, searcher *Searcher) {
+		src, release, err := searcher.source()
+		if err != nil {
+			t.Fatal("Cannot get source:", err)
+		}
+		defer release()
+		act, err := src.analyzer()
+		if err != nil {
+			t.Fatal("Cannot get analyzer:", err)
+		}
+		t.Log("exp=", []string{"bar", "foo"})
+		t.Log("act=", analyzerStopwords(act))
+		if act.Spec() != spec || !reflect.DeepEqual(analyzerStopwords(act), []string{"bar", "foo"}) {
+			t.Fatal("Wrong analyzer")
+		}
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := index.Save(&buf); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		read, err := Read(&buf)
+		if err != nil {
+			t.Fatal("Cannot read index:", err)
+		}
+		check(t, read.Searcher())
+	})
+	t.Run("binary", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := index.SaveBinary(&buf); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		read, err := ReadBinary(&buf)
+		if err != nil {
+			t.Fatal("Cannot read index:", err)
+		}
+		check(t, read.Searcher())
+	})
+	t.Run("mapped", func(t *testing.T) {
+		dir, remove := tempDir(t)
+		defer remove()
+		path := filepath.Join(dir, "index.bin")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal("Cannot create file:", err)
+		}
+		err = index.SaveBinary(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		mapped, err := OpenMapped(path)
+		if err != nil {
+			t.Fatal("Cannot open index:", err)
+		}
+		defer mapped.Close()
+		check(t, mapped.Searcher())
+	})
+	t.Run("segments", func(t *testing.T) {
+		dir, remove := tempDir(t)
+		defer remove()
+		segments, err := OpenSegments(dir)
+		if err != nil {
+			t.Fatal("Cannot open segments:", err)
+		}
+		if err = segments.Add(&index); err != nil {
+			t.Fatal("Cannot add segment:", err)
+		}
+		_ = segments.Close()
+		// update analyzes text by analyzer of segments read from manifest
+		reopened, err := OpenSegments(dir)
+		if err != nil {
+			t.Fatal("Cannot open segments:", err)
+		}
+		defer reopened.Close()
+		if err = reopened.Update("3", "foo quux"); err != nil {
+			t.Fatal("Cannot update text:", err)
+		}
+		check(t, reopened.Searcher())
+	})
+	t.Run("store", func(t *testing.T) {
+		store := NewMemoryStore()
+		if err := index.SaveToStore(store); err != nil {
+			t.Fatal("Cannot save index:", err)
+		}
+		if saved, _, _ := store.GetSetting(analyzerSetting); saved != spec {
+			t.Fatal("Wrong saved spec:", saved)
+		}
+		check(t, NewStoreSearcher(store))
+	})
+}
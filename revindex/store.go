@@ -0,0 +1,355 @@
+package revindex
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// key of setting with spec of analyzer used for texts in store
+const analyzerSetting = "analyzer"
+
+// key of setting with json array of stopwords referred by hash in spec of analyzer
+const stopwordsSetting = "stopwords"
+
+// Store keeps texts and postings of index, e.g. in database.
+// Index of text in searches is its id in store
+type Store interface {
+	// AddTitle saves title of text with its content and length in words and returns id of text.
+	// Text with the same title is replaced and keeps its id
+	AddTitle(title string, body string, length int) (int64, error)
+	// AddPostings saves positions of word in texts by their ids
+	AddPostings(word string, postings map[int64][]int) error
+	// DeleteTitle removes text with title, its postings and words that are not used by other texts.
+	// Returns false if there is no such title
+	DeleteTitle(title string) (bool, error)
+	// GetWordsPostings returns positions of words in texts by their ids. Words without texts are skipped
+	GetWordsPostings(words []string) (map[string]map[int64][]int, error)
+	// GetWordsLike returns at most limit words matching LIKE pattern escaped with '\', the most frequent first
+	GetWordsLike(pattern string, limit int) ([]string, error)
+	// GetWordsByLength returns words with length in characters between min and max sorted by bytes
+	GetWordsByLength(min int, max int) ([]string, error)
+	// GetWordFrequencies returns number of texts with each word
+	GetWordFrequencies() (map[string]int, error)
+	// GetTitles returns titles and lengths of texts by ids. Missing texts are skipped
+	GetTitles(ids []int64) (map[int64]TextInfo, error)
+	// GetTexts returns contents of texts by ids. Missing texts are skipped
+	GetTexts(ids []int64) (map[int64]string, error)
+	// GetTitleIds returns ids of all texts
+	GetTitleIds() ([]int64, error)
+	// GetStats returns number of texts and their average length
+	GetStats() (int, float64, error)
+	// GetVersion returns version of titles in store. It is changed by every change of titles or lengths of texts
+	// and is not reused after store is cleared
+	GetVersion() (int64, error)
+	// GetSetting returns value of setting and false if it is not set
+	GetSetting(key string) (string, bool, error)
+	SetSetting(key string, value string) error
+	// GetDocuments returns sources of all texts by their titles
+	GetDocuments() (map[string]Document, error)
+	// SetDocument saves source of text with title. It does nothing if there is no such title
+	SetDocument(title string, doc Document) error
+}
+
+// TextInfo is a title of text in store with its length in words
+type TextInfo struct {
+	Title  string
+	Length int
+}
+
+// batcher is a store applying changes of function in one transaction
+type batcher interface {
+	Batch(fn func(Store) error) error
+}
+
+// Batch calls function with store. Stores supporting transactions apply its changes at once,
+// so they are faster and nothing is changed if function fails
+func Batch(store Store, fn func(Store) error) error {
+	if b, ok := store.(batcher); ok {
+		return b.Batch(fn)
+	}
+	return fn(store)
+}
+
+// bulkLoader is a store saving texts and postings of the whole index at once, e.g. by COPY to database
+type bulkLoader interface {
+	// AddIndex saves texts of index like AddTitle and postings of its words like AddPostings
+	AddIndex(index *Index) error
+}
+
+// MemoryStore keeps index in memory. It is used for tests and for trying index without database
+type MemoryStore struct {
+	mu       sync.RWMutex
+	lastId   int64
+	version  int64
+	ids      map[string]int64
+	texts    map[int64]*memoryText
+	words    map[string]map[int64][]int
+	settings map[string]string
+}
+
+type memoryText struct {
+	title  string
+	body   string
+	length int
+	doc    Document
+}
+
+// NewMemoryStore creates empty store in memory
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		ids:      make(map[string]int64),
+		texts:    make(map[int64]*memoryText),
+		words:    make(map[string]map[int64][]int),
+		settings: make(map[string]string),
+	}
+}
+
+func (s *MemoryStore) AddTitle(title string, body string, length int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	if id, ok := s.ids[title]; ok {
+		s.texts[id].body, s.texts[id].length = body, length
+		return id, nil
+	}
+	s.lastId++
+	s.ids[title] = s.lastId
+	s.texts[s.lastId] = &memoryText{title: title, body: body, length: length}
+	return s.lastId, nil
+}
+
+func (s *MemoryStore) AddPostings(word string, postings map[int64][]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range postings {
+		if _, ok := s.texts[id]; !ok {
+			return fmt.Errorf("no text with id %d", id)
+		}
+	}
+	saved, ok := s.words[word]
+	if !ok {
+		saved = make(map[int64][]int, len(postings))
+		s.words[word] = saved
+	}
+	for id, positions := range postings {
+		if _, ok := saved[id]; ok {
+			return fmt.Errorf("postings of word '%s' in text %d are already added", word, id)
+		}
+		saved[id] = append([]int(nil), positions...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteTitle(title string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.ids[title]
+	if !ok {
+		return false, nil
+	}
+	s.version++
+	for word, postings := range s.words {
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(s.words, word)
+		}
+	}
+	delete(s.ids, title)
+	delete(s.texts, id)
+	return true, nil
+}
+
+func (s *MemoryStore) GetWordsPostings(words []string) (map[string]map[int64][]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]map[int64][]int, len(words))
+	for _, word := range words {
+		postings, ok := s.words[word]
+		if !ok {
+			continue
+		}
+		res[word] = make(map[int64][]int, len(postings))
+		for id, positions := range postings {
+			res[word][id] = positions
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) GetWordsLike(pattern string, limit int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]string, 0)
+	for word := range s.words {
+		if MatchLike(pattern, word) {
+			res = append(res, word)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(s.words[res[i]]) != len(s.words[res[j]]) {
+			return len(s.words[res[i]]) > len(s.words[res[j]])
+		}
+		return res[i] < res[j]
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) GetWordsByLength(min int, max int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]string, 0)
+	for word := range s.words {
+		if length := utf8.RuneCountInString(word); length >= min && length <= max {
+			res = append(res, word)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (s *MemoryStore) GetWordFrequencies() (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]int, len(s.words))
+	for word, postings := range s.words {
+		res[word] = len(postings)
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) GetTitles(ids []int64) (map[int64]TextInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[int64]TextInfo, len(ids))
+	for _, id := range ids {
+		if text, ok := s.texts[id]; ok {
+			res[id] = TextInfo{Title: text.title, Length: text.length}
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) GetTexts(ids []int64) (map[int64]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[int64]string, len(ids))
+	for _, id := range ids {
+		if text, ok := s.texts[id]; ok {
+			res[id] = text.body
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) GetTitleIds() ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]int64, 0, len(s.texts))
+	for id := range s.texts {
+		res = append(res, id)
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) GetStats() (int, float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.texts) == 0 {
+		return 0, 0, nil
+	}
+	total := 0
+	for _, text := range s.texts {
+		total += text.length
+	}
+	return len(s.texts), float64(total) / float64(len(s.texts)), nil
+}
+
+func (s *MemoryStore) GetVersion() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version, nil
+}
+
+func (s *MemoryStore) GetSetting(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.settings[key]
+	return value, ok, nil
+}
+
+func (s *MemoryStore) SetSetting(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[key] = value
+	return nil
+}
+
+func (s *MemoryStore) GetDocuments() (map[string]Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]Document, len(s.texts))
+	for _, text := range s.texts {
+		res[text.title] = text.doc
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) SetDocument(title string, doc Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.ids[title]; ok {
+		s.texts[id].doc = doc
+	}
+	return nil
+}
+
+// MatchLike checks that word matches LIKE pattern of Store.GetWordsLike with '%', '_' and escaping '\'.
+// Wildcard patterns of queries are matched by it after wildcardToLike
+func MatchLike(pattern string, word string) bool {
+	// runes of pattern and their kinds: '%' and '_' wildcards or 0 for literal runes
+	p := make([]rune, 0, len(pattern))
+	kinds := make([]rune, 0, len(pattern))
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+			p, kinds = append(p, r), append(kinds, 0)
+		case r == '\\':
+			escaped = true
+		case r == '%' || r == '_':
+			p, kinds = append(p, r), append(kinds, r)
+		default:
+			p, kinds = append(p, r), append(kinds, 0)
+		}
+	}
+	w := []rune(word)
+	i, j := 0, 0
+	// position of the last '%' in pattern and position in word where its match ends
+	star, starEnd := -1, 0
+	for j < len(w) {
+		switch {
+		case i < len(p) && (kinds[i] == '_' || kinds[i] == 0 && p[i] == w[j]):
+			i++
+			j++
+		case i < len(p) && kinds[i] == '%':
+			star, starEnd = i, j
+			i++
+		case star != -1:
+			// let the last '%' match one more character
+			starEnd++
+			i, j = star+1, starEnd
+		default:
+			return false
+		}
+	}
+	for i < len(p) && kinds[i] == '%' {
+		i++
+	}
+	return i == len(p)
+}
@@ -0,0 +1,306 @@
+package revindex
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sortedTitles returns sorted titles of results
+func sortedTitles(res []Result) []string {
+	titles := resultTitles(res)
+	sort.Strings(titles)
+	return titles
+}
+
+// slowStore is a stand-in of database counting queries for search and waiting for each of them
+type slowStore struct {
+	Store
+	delay   time.Duration
+	queries int64
+}
+
+func (s *slowStore) query() {
+	atomic.AddInt64(&s.queries, 1)
+	time.Sleep(s.delay)
+}
+
+func (s *slowStore) GetWordsPostings(words []string) (map[string]map[int64][]int, error) {
+	s.query()
+	return s.Store.GetWordsPostings(words)
+}
+
+func (s *slowStore) GetTitles(ids []int64) (map[int64]TextInfo, error) {
+	s.query()
+	return s.Store.GetTitles(ids)
+}
+
+func (s *slowStore) GetTexts(ids []int64) (map[int64]string, error) {
+	s.query()
+	return s.Store.GetTexts(ids)
+}
+
+func (s *slowStore) GetStats() (int, float64, error) {
+	s.query()
+	return s.Store.GetStats()
+}
+
+func (s *slowStore) GetVersion() (int64, error) {
+	s.query()
+	return s.Store.GetVersion()
+}
+
+func (s *slowStore) GetSetting(key string) (string, bool, error) {
+	s.query()
+	return s.Store.GetSetting(key)
+}
+
+// unbatchedSource hides batch methods of source, so postings and titles are got one by one
+type unbatchedSource struct {
+	source
+}
+
+// generateStore saves generated index to slow store
+func generateStore(tb testing.TB, textsNumber int, wordsNumber int, delay time.Duration) *slowStore {
+	store := NewMemoryStore()
+	if err := generateIndex(textsNumber, wordsNumber).SaveToStore(store); err != nil {
+		tb.Fatal("Cannot save index:", err)
+	}
+	return &slowStore{Store: store, delay: delay}
+}
+
+func TestFindInStore_Queries(t *testing.T) {
+	store := generateStore(t, 100, 10, 0)
+	searcher := NewStoreSearcher(store)
+	// analyzer, stats, postings of all words, version, titles and texts. Titles are cached after the first search
+	for i, exp := range []int64{6, 5} {
+		t.Run(fmt.Sprintf("search %d", i), func(t *testing.T) {
+			atomic.StoreInt64(&store.queries, 0)
+			res, err := searcher.Find("w0 w1 \"w2 w3\" OR w4", DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			if len(res) != 100 {
+				t.Fatal("Wrong number of results:", len(res))
+			}
+			act := atomic.LoadInt64(&store.queries)
+			t.Log("exp=", exp)
+			t.Log("act=", act)
+			if act != exp {
+				t.Fatal("Wrong number of queries")
+			}
+		})
+	}
+}
+
+func BenchmarkFindInStore(b *testing.B) {
+	store := generateStore(b, 200, 20, 100*time.Microsecond)
+	phrase := "w0"
+	for i := 1; i < 20; i++ {
+		phrase = phrase + " " + fmt.Sprintf("w%d", i)
+	}
+	// source is created for each search like in searcher of store
+	searcher := NewStoreSearcher(store)
+	benchmarks := []struct {
+		name   string
+		source func() source
+	}{
+		{name: "query per word and text", source: func() source {
+			return unbatchedSource{&storeSource{Store: store}}
+		}},
+		{name: "batched queries", source: func() source {
+			return &storeSource{Store: store}
+		}},
+		{name: "batched queries, cached titles", source: func() source {
+			src, _, err := searcher.snapshot()
+			if err != nil {
+				b.Fatal("Cannot create source:", err)
+			}
+			return src
+		}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			atomic.StoreInt64(&store.queries, 0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := search(bm.source(), phrase, DefaultBM25, false); err != nil {
+					b.Fatal("Find failed:", err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&store.queries))/float64(b.N), "queries/op")
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	index, err := Build(deleteTexts, deleteTitles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	store := NewMemoryStore()
+	if err = index.SaveToStore(store); err != nil {
+		t.Fatal("Cannot save index:", err)
+	}
+	for _, q := range append(deleteQueries, "\"search engine\"", "enigne~", "sear*") {
+		t.Run(q, func(t *testing.T) {
+			exp, err := index.Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			act, err := FindInStore(q, store, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			t.Log("exp=", exp)
+			t.Log("act=", act)
+			if !reflect.DeepEqual(act, exp) {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+
+	t.Run("suggestions", func(t *testing.T) {
+		suggester, err := NewStoreSearcher(store).Suggester()
+		if err != nil {
+			t.Fatal("Cannot create suggester:", err)
+		}
+		if act, _ := suggester.Suggest("serch"); act != "search" {
+			t.Fatal("Wrong suggestion:", act)
+		}
+	})
+
+	t.Run("other analyzer", func(t *testing.T) {
+		other, err := BuildWithAnalyzer([]string{"text"}, []string{"t"}, mustParseAnalyzer(t, "letters"))
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		err = other.SaveToStore(store)
+		t.Log("err:", err)
+		if err == nil {
+			t.Fatal("Save must return an error")
+		}
+	})
+
+	t.Run("delete and update", func(t *testing.T) {
+		if ok, err := store.DeleteTitle("db"); err != nil || !ok {
+			t.Fatal("Wrong result of delete:", ok, err)
+		}
+		if ok, _ := store.DeleteTitle("db"); ok {
+			t.Fatal("Text is deleted twice")
+		}
+		if err := UpdateInStore(store, "st", "texts of new car"); err != nil {
+			t.Fatal("Cannot update text:", err)
+		}
+		exp, err := Build(
+			[]string{deleteTexts[0], deleteTexts[3], "texts of new car"},
+			[]string{"se", "car", "st"},
+		)
+		if err != nil {
+			t.Fatal("Failed to build index:", err)
+		}
+		for _, q := range deleteQueries {
+			expRes, err := exp.Find(q, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			actRes, err := FindInStore(q, store, DefaultBM25)
+			if err != nil {
+				t.Fatal("Find failed:", err)
+			}
+			// order of texts in store differs, so only titles are compared
+			if !reflect.DeepEqual(sortedTitles(actRes), sortedTitles(expRes)) {
+				t.Log("query:", q)
+				t.Log("exp=", expRes)
+				t.Log("act=", actRes)
+				t.Fatal("Wrong result")
+			}
+		}
+		if words, _ := store.GetWordsByLength(1, 100); len(words) != len(exp.Data) {
+			t.Log("exp=", exp.sortedTerms())
+			t.Log("act=", words)
+			t.Fatal("Words of deleted texts are not removed")
+		}
+	})
+
+	t.Run("documents", func(t *testing.T) {
+		doc := Document{Hash: "hash", ModTime: 1}
+		if err := store.SetDocument("se", doc); err != nil {
+			t.Fatal("Cannot set document:", err)
+		}
+		if err := store.SetDocument("unknown", doc); err != nil {
+			t.Fatal("Cannot set document:", err)
+		}
+		act, err := store.GetDocuments()
+		if err != nil {
+			t.Fatal("Cannot get documents:", err)
+		}
+		exp := map[string]Document{"se": doc, "car": {}, "st": {}}
+		t.Log("exp=", exp)
+		t.Log("act=", act)
+		if !reflect.DeepEqual(act, exp) {
+			t.Fatal("Wrong documents")
+		}
+	})
+}
+
+// bulkStore is a store remembering index added at once
+type bulkStore struct {
+	*MemoryStore
+	added *Index
+}
+
+func (s *bulkStore) AddIndex(index *Index) error {
+	s.added = index
+	return nil
+}
+
+func TestIndex_SaveToStore_bulk(t *testing.T) {
+	index, err := Build(deleteTexts, deleteTitles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	store := &bulkStore{MemoryStore: NewMemoryStore()}
+	if err = index.SaveToStore(store); err != nil {
+		t.Fatal("Cannot save index:", err)
+	}
+	if store.added != &index {
+		t.Fatal("Index is not added at once")
+	}
+	if ids, _ := store.GetTitleIds(); len(ids) != 0 {
+		t.Fatal("Texts are added one by one:", ids)
+	}
+	if spec, _, _ := store.GetSetting(analyzerSetting); spec != index.analyzer().Spec() {
+		t.Fatal("Wrong analyzer of store:", spec)
+	}
+}
+
+func TestMatchLike(t *testing.T) {
+	tests := []struct {
+		pattern string
+		word    string
+		exp     bool
+	}{
+		{pattern: "sea%", word: "search", exp: true},
+		{pattern: "s_a%h", word: "search", exp: true},
+		{pattern: "%ch", word: "search", exp: true},
+		{pattern: "sea_", word: "search", exp: false},
+		{pattern: "100\\%", word: "100%", exp: true},
+		{pattern: "100\\%", word: "1000", exp: false},
+		{pattern: "a\\_b", word: "a_b", exp: true},
+		{pattern: "a\\_b", word: "acb", exp: false},
+		{pattern: "пои%", word: "поиск", exp: true},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.word, func(t *testing.T) {
+			if act := MatchLike(test.pattern, test.word); act != test.exp {
+				t.Log("exp=", test.exp)
+				t.Log("act=", act)
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
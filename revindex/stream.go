@@ -0,0 +1,582 @@
+package revindex
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// DefaultBudget is a default memory budget of StreamBuilder in bytes
+const DefaultBudget = 64 << 20
+
+// estimated memory used by word in run besides its bytes and postings
+const runWordOverhead = 64
+
+// StreamBuilder builds binary index of texts added one by one in bounded memory. Texts are read and analyzed
+// by a pool of workers, their postings are collected in memory and flushed to sorted runs in temporary files
+// when they exceed memory budget. Finish merges runs into index in format of SaveBinary
+type StreamBuilder struct {
+	analyzer Analyzer
+	budget   int
+	dir      string
+
+	jobs    chan streamJob
+	results chan streamResult
+	workers sync.WaitGroup
+	// closed when collector handled all results
+	done chan struct{}
+	// number of added texts
+	added int
+
+	mu  sync.Mutex
+	err error
+	// workers and collector are stopped once by Finish or Close
+	stopped sync.Once
+
+	// state of collector
+	titleOffsets *tempFile
+	titles       *tempFile
+	lengths      *tempFile
+	textOffsets  *tempFile
+	texts        *tempFile
+	n            int
+	// total length of texts in words
+	total   uint64
+	run     map[string]*runPostings
+	runSize int
+	runs    []string
+}
+
+// text to read and analyze by worker
+type streamJob struct {
+	seq   int
+	title string
+	text  string
+	// file with text, it is read by worker if it is set
+	path string
+}
+
+// analyzed text
+type streamResult struct {
+	seq    int
+	title  string
+	text   string
+	terms  map[string][]int
+	length int
+	err    error
+}
+
+// postings of word in run. Index of the first text is written as delta from zero
+type runPostings struct {
+	df   int
+	last int
+	buf  []byte
+}
+
+// NewStreamBuilder creates builder of index with analyzer. Postings in memory are limited by budget in bytes,
+// texts are analyzed by workers. Temporary files are created in tmpDir or in default dir for temporary files
+// if it is empty. Default analyzer, budget and number of CPUs are used for zero values. Builder must be finished
+// or closed
+func NewStreamBuilder(analyzer Analyzer, budget int, workers int, tmpDir string) (*StreamBuilder, error) {
+	if analyzer == nil {
+		analyzer = DefaultAnalyzer()
+	}
+	if budget <= 0 {
+		budget = DefaultBudget
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	dir, err := ioutil.TempDir(tmpDir, "revindex-build")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create dir for temporary files: %w", err)
+	}
+	b := StreamBuilder{
+		analyzer: analyzer,
+		budget:   budget,
+		dir:      dir,
+		jobs:     make(chan streamJob, workers),
+		results:  make(chan streamResult, workers),
+		done:     make(chan struct{}),
+		run:      make(map[string]*runPostings),
+	}
+	for _, f := range []struct {
+		file **tempFile
+		name string
+	}{
+		{&b.titleOffsets, "title-offsets"}, {&b.titles, "titles"}, {&b.lengths, "lengths"},
+		{&b.textOffsets, "text-offsets"}, {&b.texts, "texts"},
+	} {
+		if *f.file, err = createTempFile(dir, f.name); err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	b.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go b.work()
+	}
+	go b.collect()
+	return &b, nil
+}
+
+// Add adds text with title. It blocks while all workers are busy
+func (b *StreamBuilder) Add(title string, text string) error {
+	return b.add(streamJob{title: title, text: text})
+}
+
+// AddFile adds text of file with title. File is read by worker
+func (b *StreamBuilder) AddFile(title string, path string) error {
+	return b.add(streamJob{title: title, path: path})
+}
+
+func (b *StreamBuilder) add(job streamJob) error {
+	if err := b.error(); err != nil {
+		return err
+	}
+	job.seq = b.added
+	b.added++
+	b.jobs <- job
+	return nil
+}
+
+// Finish merges runs and writes index to writer. Builder cannot be used after it
+func (b *StreamBuilder) Finish(writer io.Writer) error {
+	defer b.Close()
+	b.stop()
+	if err := b.error(); err != nil {
+		return err
+	}
+	if err := b.flushRun(); err != nil {
+		return err
+	}
+	return b.merge(writer)
+}
+
+// Close stops workers and removes temporary files. Added texts are dropped if builder is not finished
+func (b *StreamBuilder) Close() error {
+	b.stop()
+	for _, f := range []*tempFile{b.titleOffsets, b.titles, b.lengths, b.textOffsets, b.texts} {
+		_ = f.f.Close()
+	}
+	return os.RemoveAll(b.dir)
+}
+
+// stop waits for workers and collector to handle added texts
+func (b *StreamBuilder) stop() {
+	b.stopped.Do(func() {
+		close(b.jobs)
+		b.workers.Wait()
+		close(b.results)
+		<-b.done
+	})
+}
+
+func (b *StreamBuilder) error() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+func (b *StreamBuilder) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// work reads and analyzes texts
+func (b *StreamBuilder) work() {
+	defer b.workers.Done()
+	for job := range b.jobs {
+		res := streamResult{seq: job.seq, title: job.title, text: job.text}
+		if job.path != "" {
+			content, err := ioutil.ReadFile(job.path)
+			if err != nil {
+				res.err = fmt.Errorf("error on reading '%s': %w", job.path, err)
+				b.results <- res
+				continue
+			}
+			res.text = string(content)
+		}
+		tokens := b.analyzer.Analyze(res.text)
+		res.terms = make(map[string][]int)
+		for _, token := range tokens {
+			res.terms[token.Term] = append(res.terms[token.Term], token.Position)
+		}
+		res.length = len(tokens)
+		b.results <- res
+	}
+}
+
+// collect adds analyzed texts to run in order of adding. After error results are skipped, so workers are not blocked
+func (b *StreamBuilder) collect() {
+	defer close(b.done)
+	pending := make(map[int]streamResult)
+	next := 0
+	for res := range b.results {
+		pending[res.seq] = res
+		for {
+			res, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if b.error() != nil {
+				continue
+			}
+			if res.err == nil {
+				res.err = b.addResult(res)
+			}
+			if res.err != nil {
+				b.fail(res.err)
+			}
+		}
+	}
+}
+
+// addResult writes title and text and adds postings of text to run, run is flushed if it exceeds budget
+func (b *StreamBuilder) addResult(res streamResult) error {
+	doc := b.n
+	b.n++
+	if _, err := b.titleOffsets.Write(appendUint64(nil, b.titles.size)); err != nil {
+		return fmt.Errorf("cannot write title: %w", err)
+	}
+	if _, err := io.WriteString(b.titles, res.title); err != nil {
+		return fmt.Errorf("cannot write title: %w", err)
+	}
+	if _, err := b.lengths.Write(appendUint64(nil, uint64(res.length))); err != nil {
+		return fmt.Errorf("cannot write title: %w", err)
+	}
+	b.total += uint64(res.length)
+	if _, err := b.textOffsets.Write(appendUint64(nil, b.texts.size)); err != nil {
+		return fmt.Errorf("cannot write text: %w", err)
+	}
+	if _, err := io.WriteString(b.texts, res.text); err != nil {
+		return fmt.Errorf("cannot write text: %w", err)
+	}
+	for term, positions := range res.terms {
+		p, ok := b.run[term]
+		if !ok {
+			p = &runPostings{}
+			b.run[term] = p
+			b.runSize += len(term) + runWordOverhead
+		}
+		size := len(p.buf)
+		p.buf = AppendUvarint(p.buf, uint64(doc-p.last))
+		p.buf = AppendPositions(p.buf, positions)
+		p.df++
+		p.last = doc
+		b.runSize += len(p.buf) - size
+	}
+	if b.runSize >= b.budget {
+		return b.flushRun()
+	}
+	return nil
+}
+
+// flushRun writes words of run sorted with their postings to temporary file. Record of word is word,
+// uvarint number of texts, uvarint index of the last text, uvarint length of postings and postings
+func (b *StreamBuilder) flushRun() error {
+	if len(b.run) == 0 {
+		return nil
+	}
+	words := make([]string, 0, len(b.run))
+	for word := range b.run {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	f, err := createTempFile(b.dir, fmt.Sprintf("run-%d", len(b.runs)))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 0)
+	for _, word := range words {
+		p := b.run[word]
+		buf = appendString(buf[:0], word)
+		buf = AppendUvarint(buf, uint64(p.df))
+		buf = AppendUvarint(buf, uint64(p.last))
+		buf = AppendUvarint(buf, uint64(len(p.buf)))
+		if _, err = f.Write(append(buf, p.buf...)); err != nil {
+			_ = f.f.Close()
+			return fmt.Errorf("cannot write run: %w", err)
+		}
+	}
+	if err = f.w.Flush(); err != nil {
+		_ = f.f.Close()
+		return fmt.Errorf("cannot write run: %w", err)
+	}
+	if err = f.f.Close(); err != nil {
+		return fmt.Errorf("cannot write run: %w", err)
+	}
+	b.runs = append(b.runs, f.f.Name())
+	b.run = make(map[string]*runPostings)
+	b.runSize = 0
+	return nil
+}
+
+// merge merges runs into words and postings of index and writes index with titles and texts to writer
+func (b *StreamBuilder) merge(writer io.Writer) error {
+	words, err := createTempFile(b.dir, "words")
+	if err != nil {
+		return err
+	}
+	defer words.f.Close()
+	wordOffsets, err := createTempFile(b.dir, "word-offsets")
+	if err != nil {
+		return err
+	}
+	defer wordOffsets.f.Close()
+	postings, err := createTempFile(b.dir, "postings")
+	if err != nil {
+		return err
+	}
+	defer postings.f.Close()
+	postingsOffsets, err := createTempFile(b.dir, "postings-offsets")
+	if err != nil {
+		return err
+	}
+	defer postingsOffsets.f.Close()
+
+	readers := make(runHeap, 0, len(b.runs))
+	for i, name := range b.runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("cannot open run: %w", err)
+		}
+		defer f.Close()
+		r := &runReader{index: i, r: bufio.NewReader(f)}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			readers = append(readers, r)
+		}
+	}
+	heap.Init(&readers)
+	terms := 0
+	group := make([]*runReader, 0, len(readers))
+	for readers.Len() > 0 {
+		// records of word from all runs in order of runs, so indices of texts are ascending
+		word := readers[0].word
+		group = group[:0]
+		for readers.Len() > 0 && readers[0].word == word {
+			group = append(group, heap.Pop(&readers).(*runReader))
+		}
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].index < group[j].index
+		})
+		terms++
+		if _, err = wordOffsets.Write(appendUint64(nil, words.size)); err != nil {
+			return fmt.Errorf("cannot write words: %w", err)
+		}
+		if _, err = io.WriteString(words, word); err != nil {
+			return fmt.Errorf("cannot write words: %w", err)
+		}
+		if _, err = postingsOffsets.Write(appendUint64(nil, postings.size)); err != nil {
+			return fmt.Errorf("cannot write postings: %w", err)
+		}
+		df := 0
+		for _, r := range group {
+			df += r.df
+		}
+		if _, err = postings.Write(AppendUvarint(nil, uint64(df))); err != nil {
+			return fmt.Errorf("cannot write postings: %w", err)
+		}
+		// the first text of each run is written as delta from the last text of previous run
+		last := 0
+		for _, r := range group {
+			first, n := binary.Uvarint(r.buf)
+			if n <= 0 {
+				return errors.New("invalid run")
+			}
+			if _, err = postings.Write(append(AppendUvarint(nil, first-uint64(last)), r.buf[n:]...)); err != nil {
+				return fmt.Errorf("cannot write postings: %w", err)
+			}
+			last = r.last
+			ok, err := r.next()
+			if err != nil {
+				return err
+			}
+			if ok {
+				heap.Push(&readers, r)
+			}
+		}
+	}
+	return b.write(writer, terms, words, wordOffsets, postings, postingsOffsets)
+}
+
+// write writes sections of index collected in temporary files
+func (b *StreamBuilder) write(writer io.Writer, terms int, words, wordOffsets, postings, postingsOffsets *tempFile) error {
+	meta := appendString(nil, b.analyzer.Spec())
+	count := make([]byte, 4)
+	binary.LittleEndian.PutUint32(count, uint32(b.n))
+	termsCount := make([]byte, 4)
+	binary.LittleEndian.PutUint32(termsCount, uint32(terms))
+	// parts of sections, the last offsets of tables are sizes of their data
+	sections := [][]interface{}{
+		sectionMeta:      {meta},
+		sectionStopwords: {encodeTable(analyzerStopwords(b.analyzer), nil)},
+		sectionDocs:      {count, b.titleOffsets, appendUint64(nil, b.titles.size), b.lengths, appendUint64(nil, b.total), b.titles},
+		sectionTexts:     {count, b.textOffsets, appendUint64(nil, b.texts.size), b.texts},
+		sectionTerms:     {termsCount, wordOffsets, appendUint64(nil, words.size), postingsOffsets, appendUint64(nil, postings.size), words},
+		sectionPostings:  {postings},
+	}
+	header := make([]byte, binaryHeaderSize)
+	copy(header, binaryMagic[:])
+	binary.LittleEndian.PutUint32(header[4:], BinaryVersion)
+	offset := uint64(binaryHeaderSize)
+	for i, parts := range sections {
+		size := uint64(0)
+		for _, part := range parts {
+			switch part := part.(type) {
+			case []byte:
+				size += uint64(len(part))
+			case *tempFile:
+				size += part.size
+			}
+		}
+		binary.LittleEndian.PutUint64(header[8+i*16:], offset)
+		binary.LittleEndian.PutUint64(header[16+i*16:], size)
+		offset += size
+	}
+
+	checksum := crc32.NewIEEE()
+	w := io.MultiWriter(writer, checksum)
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("cannot write header: %w", err)
+	}
+	for _, parts := range sections {
+		for _, part := range parts {
+			var err error
+			switch part := part.(type) {
+			case []byte:
+				_, err = w.Write(part)
+			case *tempFile:
+				err = part.copyTo(w)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot write index: %w", err)
+			}
+		}
+	}
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, checksum.Sum32())
+	if _, err := writer.Write(footer); err != nil {
+		return fmt.Errorf("cannot write checksum: %w", err)
+	}
+	return nil
+}
+
+// tempFile is a buffered temporary file with size of written data
+type tempFile struct {
+	f    *os.File
+	w    *bufio.Writer
+	size uint64
+}
+
+func createTempFile(dir string, name string) (*tempFile, error) {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary file: %w", err)
+	}
+	return &tempFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (t *tempFile) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.size += uint64(n)
+	return n, err
+}
+
+// copyTo writes all data of file to writer
+func (t *tempFile) copyTo(writer io.Writer) error {
+	if err := t.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(writer, t.f)
+	return err
+}
+
+// runReader reads records of run one by one
+type runReader struct {
+	// index of run
+	index int
+	r     *bufio.Reader
+	word  string
+	df    int
+	last  int
+	buf   []byte
+}
+
+// next reads the next record and returns false at the end of run
+func (r *runReader) next() (bool, error) {
+	length, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	values := make([]uint64, 3)
+	word := make([]byte, length)
+	if err == nil {
+		_, err = io.ReadFull(r.r, word)
+	}
+	for i := range values {
+		if err == nil {
+			values[i], err = binary.ReadUvarint(r.r)
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot read run: %w", err)
+	}
+	r.word, r.df, r.last = string(word), int(values[0]), int(values[1])
+	r.buf = make([]byte, values[2])
+	if _, err = io.ReadFull(r.r, r.buf); err != nil {
+		return false, fmt.Errorf("cannot read run: %w", err)
+	}
+	return true, nil
+}
+
+// runHeap orders readers by their current words, then by index of run
+type runHeap []*runReader
+
+func (h runHeap) Len() int {
+	return len(h)
+}
+
+func (h runHeap) Less(i, j int) bool {
+	if h[i].word != h[j].word {
+		return h[i].word < h[j].word
+	}
+	return h[i].index < h[j].index
+}
+
+func (h runHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	*h = append(*h, x.(*runReader))
+}
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
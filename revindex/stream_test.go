@@ -0,0 +1,129 @@
+package revindex
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// streamTexts generates texts with overlapping words
+func streamTexts(n int) ([]string, []string) {
+	texts := make([]string, n)
+	titles := make([]string, n)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("text %d about word%d and word%d, text number %d", i, i%7, i%13, i)
+		titles[i] = fmt.Sprint("t", i)
+	}
+	return texts, titles
+}
+
+func TestStreamBuilder(t *testing.T) {
+	texts, titles := streamTexts(300)
+	index, err := Build(texts, titles)
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	var exp bytes.Buffer
+	if err = index.SaveBinary(&exp); err != nil {
+		t.Fatal("Cannot save index:", err)
+	}
+
+	tests := []struct {
+		name    string
+		budget  int
+		workers int
+	}{
+		{name: "one run", budget: 0, workers: 1},
+		{name: "run per text", budget: 1, workers: 4},
+		{name: "several runs", budget: 4096, workers: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder, err := NewStreamBuilder(nil, test.budget, test.workers, "")
+			if err != nil {
+				t.Fatal("Cannot create builder:", err)
+			}
+			for i, text := range texts {
+				if err = builder.Add(titles[i], text); err != nil {
+					t.Fatal("Cannot add text:", err)
+				}
+			}
+			var act bytes.Buffer
+			if err = builder.Finish(&act); err != nil {
+				t.Fatal("Cannot finish index:", err)
+			}
+			if !bytes.Equal(act.Bytes(), exp.Bytes()) {
+				t.Fatal("Index is not equal to saved one")
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		builder, err := NewStreamBuilder(nil, 0, 0, "")
+		if err != nil {
+			t.Fatal("Cannot create builder:", err)
+		}
+		var act bytes.Buffer
+		if err = builder.Finish(&act); err != nil {
+			t.Fatal("Cannot finish index:", err)
+		}
+		index, err := ReadBinary(&act)
+		if err != nil {
+			t.Fatal("Cannot read index:", err)
+		}
+		if len(index.Titles) != 0 || len(index.Data) != 0 {
+			t.Fatal("Index is not empty")
+		}
+	})
+}
+
+func TestStreamBuilder_AddFile(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	path := filepath.Join(dir, "text")
+	if err := ioutil.WriteFile(path, []byte("text of file"), 0644); err != nil {
+		t.Fatal("Cannot write file:", err)
+	}
+
+	builder, err := NewStreamBuilder(nil, 0, 2, dir)
+	if err != nil {
+		t.Fatal("Cannot create builder:", err)
+	}
+	if err = builder.AddFile("file", path); err != nil {
+		t.Fatal("Cannot add file:", err)
+	}
+	var buf bytes.Buffer
+	if err = builder.Finish(&buf); err != nil {
+		t.Fatal("Cannot finish index:", err)
+	}
+	index, err := ReadBinary(&buf)
+	if err != nil {
+		t.Fatal("Cannot read index:", err)
+	}
+	res, err := index.Find("file", DefaultBM25)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	if len(res) != 1 || res[0].Title != "file" || index.Texts[0] != "text of file" {
+		t.Fatal("Wrong result:", res, index.Texts)
+	}
+	// temporary files are removed
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatal("Temporary files are not removed:", files)
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		builder, err := NewStreamBuilder(nil, 0, 2, "")
+		if err != nil {
+			t.Fatal("Cannot create builder:", err)
+		}
+		_ = builder.AddFile("missing", filepath.Join(dir, "missing"))
+		err = builder.Finish(ioutil.Discard)
+		t.Log("err:", err)
+		if err == nil {
+			t.Fatal("Finish must return an error")
+		}
+	})
+}
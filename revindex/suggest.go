@@ -0,0 +1,98 @@
+package revindex
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Suggester proposes corrected queries, where words missing in texts are replaced with similar frequent words.
+// Words are replaced with terms made by analyzer, so they may be stems if analyzer stems words
+type Suggester struct {
+	analyzer Analyzer
+	// sorted words of texts
+	words []string
+	// number of texts with each word
+	frequencies map[string]int
+}
+
+// NewSuggester creates suggester by words of texts analyzed with analyzer and their document frequencies
+func NewSuggester(analyzer Analyzer, frequencies map[string]int) *Suggester {
+	words := make([]string, 0, len(frequencies))
+	for word := range frequencies {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return &Suggester{analyzer: analyzer, words: words, frequencies: frequencies}
+}
+
+// Suggester creates suggester by words of index
+func (index *Index) Suggester() *Suggester {
+	frequencies, _ := indexSource{index}.frequencies()
+	return NewSuggester(index.analyzer(), frequencies)
+}
+
+// Suggest returns query with corrected words and true, or false if all words of query are found in texts.
+// Operators, patterns and words with '~' are kept as they are
+func (s *Suggester) Suggest(q string) (string, bool) {
+	runes := []rune(q)
+	res := make([]rune, 0, len(runes))
+	// end of query part copied to result
+	copied := 0
+	for _, tok := range lexQuery(q) {
+		start := tok.pos - 1
+		switch {
+		case tok.kind == tokenWord && !strings.ContainsAny(tok.text, "*?~"):
+		case tok.kind == tokenPhrase:
+			// skip quote
+			start++
+		default:
+			continue
+		}
+		if tok.modifier != 0 {
+			start++
+		}
+		// correct each word of token separately
+		text := []rune(tok.text)
+		for i := 0; i < len(text); {
+			if unicode.IsSpace(text[i]) {
+				i++
+				continue
+			}
+			end := i
+			for end < len(text) && !unicode.IsSpace(text[end]) {
+				end++
+			}
+			if correction, ok := s.correct(string(text[i:end])); ok {
+				res = append(append(res, runes[copied:start+i]...), []rune(correction)...)
+				copied = start + end
+			}
+			i = end
+		}
+	}
+	if copied == 0 {
+		return "", false
+	}
+	return string(append(res, runes[copied:]...)), true
+}
+
+// correct returns the closest frequent word within allowed number of typos for word missing in texts
+func (s *Suggester) correct(word string) (string, bool) {
+	tokens := s.analyzer.Analyze(word)
+	if len(tokens) != 1 || s.frequencies[tokens[0].Term] > 0 {
+		return "", false
+	}
+	term := tokens[0].Term
+	matches := newLevenshteinAutomaton(term, autoDistance(term)).intersect(s.words)
+	if len(matches) == 0 {
+		return "", false
+	}
+	best := matches[0]
+	for _, match := range matches[1:] {
+		if match.distance < best.distance ||
+			(match.distance == best.distance && s.frequencies[match.word] > s.frequencies[best.word]) {
+			best = match
+		}
+	}
+	return best.word, true
+}
@@ -0,0 +1,81 @@
+package revindex
+
+import (
+	"testing"
+)
+
+func TestSuggester_Suggest(t *testing.T) {
+	texts := []string{
+		"search engine",
+		"search of texts",
+		"starch",
+		"index",
+	}
+	index, err := Build(texts, []string{"1", "2", "3", "4"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	suggester := index.Suggester()
+
+	tests := []struct {
+		name  string
+		query string
+		exp   string
+		ok    bool
+	}{
+		{name: "word", query: "serch", exp: "search", ok: true},
+		{name: "the most frequent of close words", query: "sarch", exp: "search", ok: true},
+		{name: "the closest word", query: "stach", exp: "starch", ok: true},
+		{name: "operators are kept", query: "(+Serch AND -indx) OR enigne", exp: "(+search AND -index) OR engine", ok: true},
+		{name: "words of phrase", query: "\"serch  enigne\" texts", exp: "\"search  engine\" texts", ok: true},
+		{name: "found words", query: "search index", ok: false},
+		{name: "no similar words", query: "qwerty", ok: false},
+		{name: "patterns are kept", query: "serch* serch~", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, ok := suggester.Suggest(test.query)
+			t.Log("exp=", test.exp, test.ok)
+			t.Log("act=", act, ok)
+			if act != test.exp || ok != test.ok {
+				t.Fatal("Wrong result")
+			}
+		})
+	}
+}
+
+func TestSearcher_Suggester(t *testing.T) {
+	index, err := Build([]string{"search engine", "index"}, []string{"1", "2"})
+	if err != nil {
+		t.Fatal("Failed to build index:", err)
+	}
+	searcher := index.Searcher()
+	suggester, err := searcher.Suggester()
+	if err != nil {
+		t.Fatal("Cannot create suggester:", err)
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		act, err := searcher.Suggester()
+		if err != nil {
+			t.Fatal("Cannot create suggester:", err)
+		}
+		if act != suggester {
+			t.Fatal("Suggester must be cached")
+		}
+	})
+
+	t.Run("changed texts", func(t *testing.T) {
+		index.Update("2", "database")
+		act, err := searcher.Suggester()
+		if err != nil {
+			t.Fatal("Cannot create suggester:", err)
+		}
+		if act == suggester {
+			t.Fatal("Suggester must be created again")
+		}
+		if res, _ := act.Suggest("databse"); res != "database" {
+			t.Fatal("Wrong suggestion:", res)
+		}
+	})
+}
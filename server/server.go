@@ -1,35 +1,118 @@
 package server
 
 import (
+	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/polisgo2020/search-K1ta/database"
 	"github.com/polisgo2020/search-K1ta/revindex"
 	"github.com/polisgo2020/search-K1ta/server/templates"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// data of index.html page and response of search API
+type page struct {
+	Phrase  string            `json:"phrase"`
+	Results []revindex.Result `json:"results"`
+	Error   string            `json:"error,omitempty"`
+	// results are found by words similar to the phrase ones
+	Fuzzy bool `json:"fuzzy"`
+	// phrase with corrected words if there are no exact results
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
 type App struct {
-	*database.DB
+	Searcher *revindex.Searcher
+	Scorer   revindex.BM25
+	// search words with typos if there are no exact results
+	FuzzyFallback bool
 }
 
+// number of completions of the last word of phrase
+const completions = 10
+
 func (a *App) index(c echo.Context) error {
-	return c.Render(http.StatusOK, "index.html", nil)
+	return c.Render(http.StatusOK, "index.html", page{})
 }
 
 func (a *App) search(c echo.Context) error {
-	logrus.Infoln(c.Request().RemoteAddr, "Phrase:", c.QueryParam("phrase"))
-	res, err := revindex.FindInDb(c.QueryParam("phrase"), a.DB)
+	res, status := a.find(c)
+	return c.Render(status, "index.html", res)
+}
+
+func (a *App) searchAPI(c echo.Context) error {
+	res, status := a.find(c)
+	return c.JSON(status, res)
+}
+
+// autocomplete returns phrases where the last word is completed to frequent words of texts
+func (a *App) autocomplete(c echo.Context) error {
+	q := c.QueryParam("q")
+	// the last word starts after space or operator characters, they may be multibyte like U+3000
+	start := strings.LastIndexFunc(q, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("()\"+-", r)
+	})
+	if start == -1 {
+		start = 0
+	} else {
+		_, size := utf8.DecodeRuneInString(q[start:])
+		start += size
+	}
+	res := make([]string, 0)
+	if start < len(q) {
+		// completer is created by the first request and after changes of texts
+		completer, err := a.Searcher.Completer(completions)
+		if err != nil {
+			logrus.Error(c.Request().RemoteAddr, "Error:", err)
+			return c.JSON(http.StatusInternalServerError, res)
+		}
+		for _, word := range completer.Complete(q[start:]) {
+			res = append(res, q[:start]+word)
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// find searches phrase of request and returns page with results and status of response
+func (a *App) find(c echo.Context) (page, int) {
+	phrase := c.QueryParam("phrase")
+	logrus.Infoln(c.Request().RemoteAddr, "Phrase:", phrase)
+	res, err := a.Searcher.Find(phrase, a.Scorer)
 	if err != nil {
+		var parseErr *revindex.ParseError
+		if errors.As(err, &parseErr) {
+			logrus.Infoln(c.Request().RemoteAddr, "Invalid query:", err)
+			return page{Phrase: phrase, Error: parseErr.Error()}, http.StatusBadRequest
+		}
 		logrus.Error(c.Request().RemoteAddr, "Error:", err)
-		return c.Render(http.StatusInternalServerError, "index.html", res)
+		return page{Phrase: phrase, Error: "Cannot search phrase"}, http.StatusInternalServerError
+	}
+	p := page{Phrase: phrase, Results: res}
+	if len(res) == 0 {
+		// suggester is created by the first request and after changes of texts
+		suggester, err := a.Searcher.Suggester()
+		if err != nil {
+			logrus.Error(c.Request().RemoteAddr, "Error:", err)
+			return page{Phrase: phrase, Error: "Cannot search phrase"}, http.StatusInternalServerError
+		}
+		p.Suggestion, _ = suggester.Suggest(phrase)
+	}
+	if len(res) == 0 && a.FuzzyFallback {
+		if p.Results, err = a.Searcher.FindFuzzy(phrase, a.Scorer); err != nil {
+			logrus.Error(c.Request().RemoteAddr, "Error:", err)
+			return page{Phrase: phrase, Error: "Cannot search phrase"}, http.StatusInternalServerError
+		}
+		p.Fuzzy = len(p.Results) > 0
 	}
-	logrus.Infoln(c.Request().RemoteAddr, "Result:", res)
-	return c.Render(http.StatusOK, "index.html", res)
+	logrus.Infoln(c.Request().RemoteAddr, "Result:", p.Results)
+	return p, http.StatusOK
 }
 
-func Start(addr string, db *database.DB) error {
+// Start starts server searching texts of index file or database with searcher
+func Start(addr string, searcher *revindex.Searcher, scorer revindex.BM25, fuzzyFallback bool) error {
 	// configure logger
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
@@ -46,7 +129,7 @@ func Start(addr string, db *database.DB) error {
 		}
 	})
 	e.Use(middleware.Recover())
-	app := App{db}
+	app := App{Searcher: searcher, Scorer: scorer, FuzzyFallback: fuzzyFallback}
 
 	// add page renderer
 	renderer, err := templates.Init()
@@ -58,6 +141,8 @@ func Start(addr string, db *database.DB) error {
 	// add routes
 	e.Add(echo.GET, "/", app.index)
 	e.Add(echo.GET, "/search/", app.search)
+	e.Add(echo.GET, "/api/search/", app.searchAPI)
+	e.Add(echo.GET, "/autocomplete/", app.autocomplete)
 	e.Static("/static", "server/static")
 
 	// start server
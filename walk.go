@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Policies of symbolic links in dir with texts
+const (
+	// skip all links
+	symlinksSkip = "skip"
+	// read links to files, skip links to dirs
+	symlinksFiles = "files"
+	// read links to files and walk links to dirs
+	symlinksFollow = "follow"
+)
+
+// Options of walking dir with texts
+type walkOptions struct {
+	// glob patterns of files to read, all files are read if there are no patterns
+	include []string
+	// glob patterns of files and dirs to skip
+	exclude []string
+	// policy of symbolic links
+	symlinks string
+	// read files and dirs with names starting with '.'
+	hidden bool
+}
+
+// File found in dir with texts
+type dirFile struct {
+	path string
+	// slash separated path relative to dir, it is used as title of text
+	title string
+	info  os.FileInfo
+}
+
+// Title of file in dir the same as title of it returned by walkDir
+func fileTitle(root string, file string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("invalid dir '%s': %w", root, err)
+	}
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return "", fmt.Errorf("invalid file '%s': %w", file, err)
+	}
+	rel, err := filepath.Rel(absRoot, absFile)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file '%s' is not in dir '%s'", file, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Walk dir and its subdirs and return files matching options sorted by path
+func walkDir(root string, opts walkOptions) ([]dirFile, error) {
+	for _, pattern := range append(append([]string{}, opts.include...), opts.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	switch opts.symlinks {
+	case "":
+		opts.symlinks = symlinksFiles
+	case symlinksSkip, symlinksFiles, symlinksFollow:
+	default:
+		return nil, fmt.Errorf("invalid policy of symlinks '%s'", opts.symlinks)
+	}
+	res := make([]dirFile, 0)
+	// real paths of walked dirs to stop on cycles of links
+	visited := make(map[string]bool)
+	var walk func(dir string, rel string) error
+	walk = func(dir string, rel string) error {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return fmt.Errorf("error while reading dir '%s': %w", dir, err)
+		}
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("error while reading dir '%s': %w", dir, err)
+		}
+		for _, info := range infos {
+			name := info.Name()
+			title := path.Join(rel, name)
+			if (!opts.hidden && strings.HasPrefix(name, ".")) || matchAnyGlob(opts.exclude, title) {
+				continue
+			}
+			filename := filepath.Join(dir, name)
+			if info.Mode()&os.ModeSymlink != 0 {
+				if opts.symlinks == symlinksSkip {
+					continue
+				}
+				if info, err = os.Stat(filename); err != nil {
+					console.Printf("Skipping broken link '%s': %s\n", filename, err)
+					continue
+				}
+				if info.IsDir() && opts.symlinks != symlinksFollow {
+					continue
+				}
+			}
+			if info.IsDir() {
+				if err = walk(filename, title); err != nil {
+					return err
+				}
+				continue
+			}
+			if !info.Mode().IsRegular() || (len(opts.include) > 0 && !matchAnyGlob(opts.include, title)) {
+				continue
+			}
+			res = append(res, dirFile{path: filename, title: title, info: info})
+		}
+		return nil
+	}
+	if err := walk(root, ""); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Check if slash separated relative path matches any of patterns
+func matchAnyGlob(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if slash separated relative path matches pattern. Pattern without '/' is matched with name of file or dir,
+// otherwise with the whole path, where '**' matches any number of dirs
+func matchGlob(pattern string, relPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(relPath))
+		return ok
+	}
+	return matchGlobParts(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(relPath, "/"))
+}
+
+func matchGlobParts(pattern []string, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchGlobParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}